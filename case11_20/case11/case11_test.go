@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case11/degrade"
 	interceptor2 "interview-cases/case11_20/case11/interceptor"
 	pb2 "interview-cases/case11_20/case11/pb"
 	"interview-cases/case11_20/case11/service"
+	"interview-cases/reject"
 	"interview-cases/test"
 	"log"
 	"net"
@@ -25,9 +27,17 @@ func TestCase11_(t *testing.T) {
 	client := test.InitRedis()
 	svc := service.NewArticleService(client, db)
 	tokenBucket := interceptor2.NewTokenBucket(5, 0) // 最大容量为 5，每秒产生 0 个令牌，方便测试限流
+	// 被限流的时候只查 redis，redis 也没有就拒绝
+	degrader := degrade.NewDegrader(degrade.Limiter(tokenBucket)).
+		Register(pb2.ArticleService_ListArticles_FullMethodName, degrade.Rule{
+			Policies: []degrade.Policy{
+				degrade.CacheOnly(svc.ListArticlesFromCache),
+				degrade.Reject(reject.Info{Description: "数据不存在redis"}),
+			},
+		})
 	// 初始化grpc服务端,注册拦截器
 	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(interceptor2.UnaryServerInterceptor(tokenBucket)),
+		grpc.UnaryInterceptor(degrader.UnaryServerInterceptor()),
 	)
 
 	go func() {
@@ -226,7 +236,7 @@ func TestCase11_(t *testing.T) {
 				assert.NoError(t, err)
 			},
 			wantRes: nil,
-			wantErr: status.Errorf(codes.Unknown, "数据不存在redis"),
+			wantErr: status.Errorf(codes.ResourceExhausted, "数据不存在redis"),
 		},
 	}
 
@@ -242,7 +252,9 @@ func TestCase11_(t *testing.T) {
 			} else {
 				assert.Equal(t, tc.wantRes.Articles, resp.Articles)
 			}
-			assert.Equal(t, tc.wantErr, err)
+			// 降级的错误里面带了 QuotaFailure，只比较错误码和错误信息
+			assert.Equal(t, status.Code(tc.wantErr), status.Code(err))
+			assert.Equal(t, status.Convert(tc.wantErr).Message(), status.Convert(err).Message())
 			tc.after()
 		})
 	}
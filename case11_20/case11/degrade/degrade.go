@@ -0,0 +1,124 @@
+// Package degrade 声明式的降级
+// 每个方法注册一组降级策略，由限流、熔断或者过载的信号触发，
+// 被触发的请求依次尝试这些策略，第一个能处理的策略返回响应
+package degrade
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"interview-cases/reject"
+	"sync"
+)
+
+const (
+	// SignalHeader 和 PolicyHeader 告诉调用方响应是怎么来的
+	SignalHeader = "x-degrade-signal"
+	PolicyHeader = "x-degrade-policy"
+	// PolicyNone 所有的策略都处理不了
+	PolicyNone = "none"
+)
+
+// Rule 一个方法的降级规则
+type Rule struct {
+	// 为空的时候使用 Degrader 的信号
+	Signals []Signal
+	// 按照顺序尝试
+	Policies []Policy
+}
+
+// Event 一次降级，Policy 是最终处理了请求的策略
+type Event struct {
+	Method string
+	Signal string
+	Policy string
+}
+
+type Degrader struct {
+	signals []Signal
+	rules   map[string]Rule
+	mu      sync.Mutex
+	stats   map[Event]int64
+}
+
+// NewDegrader signals 是所有方法默认的降级信号，按照顺序检查，第一个触发的信号会被记录下来
+func NewDegrader(signals ...Signal) *Degrader {
+	return &Degrader{
+		signals: signals,
+		rules:   make(map[string]Rule),
+		stats:   make(map[Event]int64),
+	}
+}
+
+// Register 注册方法的降级规则，method 是完整的方法名，比如说 /proto.ArticleService/ListArticles
+// 要在启动 gRPC 服务之前注册
+func (d *Degrader) Register(method string, rule Rule) *Degrader {
+	d.rules[method] = rule
+	return d
+}
+
+// Stats 每个方法被哪个信号触发了降级，又是哪个策略处理的
+func (d *Degrader) Stats() map[Event]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make(map[Event]int64, len(d.stats))
+	for k, v := range d.stats {
+		res[k] = v
+	}
+	return res
+}
+
+// UnaryServerInterceptor 没有注册规则的方法不降级
+func (d *Degrader) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rule, ok := d.rules[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		signals := rule.Signals
+		if len(signals) == 0 {
+			signals = d.signals
+		}
+		for _, s := range signals {
+			if s.Triggered(ctx, info.FullMethod) {
+				return d.fallback(ctx, req, info.FullMethod, s.Name(), rule.Policies)
+			}
+		}
+		resp, err := handler(ctx, req)
+		for _, s := range signals {
+			if r, ok := s.(Reporter); ok {
+				r.Report(ctx, info.FullMethod, err)
+			}
+		}
+		if err == nil {
+			for _, p := range rule.Policies {
+				if r, ok := p.(Recorder); ok {
+					r.Record(ctx, req, resp)
+				}
+			}
+		}
+		return resp, err
+	}
+}
+
+func (d *Degrader) fallback(ctx context.Context, req interface{}, method, signal string, policies []Policy) (interface{}, error) {
+	for _, p := range policies {
+		resp, err := p.Serve(ctx, req)
+		if errors.Is(err, ErrNotServed) {
+			continue
+		}
+		d.record(ctx, Event{Method: method, Signal: signal, Policy: p.Name()})
+		return resp, err
+	}
+	d.record(ctx, Event{Method: method, Signal: signal, Policy: PolicyNone})
+	return nil, reject.Error(reject.Info{Description: "服务降级，没有可用的兜底数据"})
+}
+
+func (d *Degrader) record(ctx context.Context, e Event) {
+	d.mu.Lock()
+	d.stats[e]++
+	d.mu.Unlock()
+	// 不是通过 gRPC 服务端调用的时候会失败，比如说单元测试，忽略就可以
+	_ = grpc.SetHeader(ctx, metadata.Pairs(SignalHeader, e.Signal, PolicyHeader, e.Policy))
+}
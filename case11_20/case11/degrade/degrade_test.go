@@ -0,0 +1,191 @@
+package degrade
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case11/pb"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const method = "/proto.ArticleService/ListArticles"
+
+// switchSignal 手动控制要不要降级
+type switchSignal struct {
+	name string
+	on   atomic.Bool
+}
+
+func (s *switchSignal) Name() string {
+	return s.name
+}
+
+func (s *switchSignal) Triggered(ctx context.Context, method string) bool {
+	return s.on.Load()
+}
+
+type mockBreaker struct {
+	open              bool
+	success, failures int
+}
+
+func (b *mockBreaker) Allow() bool {
+	return !b.open
+}
+
+func (b *mockBreaker) MarkSuccess() {
+	b.success++
+}
+
+func (b *mockBreaker) MarkFailed() {
+	b.failures++
+}
+
+func byAuthor(req interface{}) string {
+	return req.(*pb.ListArticlesRequest).Author
+}
+
+func TestDegrader(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	limited := &switchSignal{name: "limiter"}
+	stale := NewStaleCacheWithClock(byAuthor, 10, time.Minute, clk)
+	cache := map[string]*pb.ListArticlesResponse{
+		"cached": {Articles: []*pb.Article{{Id: 1, Title: "缓存"}}},
+	}
+	cacheOnly := CacheOnly(func(ctx context.Context, req interface{}) (interface{}, error) {
+		resp, ok := cache[byAuthor(req)]
+		if !ok {
+			return nil, errors.New("缓存未命中")
+		}
+		return resp, nil
+	})
+	fallback := &pb.ListArticlesResponse{Articles: []*pb.Article{{Id: 0, Title: "默认"}}}
+	d := NewDegrader(limited).
+		Register(method, Rule{Policies: []Policy{cacheOnly, stale, Static(fallback)}}).
+		Register("/proto.ArticleService/Pay", Rule{Policies: []Policy{cacheOnly, Reject(reject.Info{RetryAfter: time.Second})}})
+	interceptor := d.UnaryServerInterceptor()
+	var calls int
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return &pb.ListArticlesResponse{Articles: []*pb.Article{{Id: 2, Title: byAuthor(req)}}}, nil
+	}
+	call := func(method, author string) (*pb.ListArticlesResponse, error) {
+		resp, err := interceptor(context.Background(), &pb.ListArticlesRequest{Author: author},
+			&grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*pb.ListArticlesResponse), nil
+	}
+
+	// 正常处理的响应会被 StaleCache 记下来
+	resp, err := call(method, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", resp.Articles[0].Title)
+	assert.Equal(t, 1, calls)
+
+	limited.on.Store(true)
+	// 缓存里面有
+	resp, err = call(method, "cached")
+	require.NoError(t, err)
+	assert.Equal(t, "缓存", resp.Articles[0].Title)
+	// 缓存里面没有，用过时的响应
+	resp, err = call(method, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", resp.Articles[0].Title)
+	// 过时太久了，只能用默认的响应
+	clk.Advance(time.Minute + time.Second)
+	resp, err = call(method, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "默认", resp.Articles[0].Title)
+	// 改了返回的响应也不会影响下一个请求
+	resp.Articles[0].Title = "被改了"
+	resp, err = call(method, "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, "默认", resp.Articles[0].Title)
+	// 最后拒绝
+	_, err = call("/proto.ArticleService/Pay", "unknown")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	retryAfter, ok := reject.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+	// 没有注册规则的方法不降级
+	_, err = call("/proto.ArticleService/Other", "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	assert.Equal(t, map[Event]int64{
+		{Method: method, Signal: "limiter", Policy: "cache_only"}:                  1,
+		{Method: method, Signal: "limiter", Policy: "stale_cache"}:                 1,
+		{Method: method, Signal: "limiter", Policy: "static"}:                      2,
+		{Method: "/proto.ArticleService/Pay", Signal: "limiter", Policy: "reject"}: 1,
+	}, d.Stats())
+}
+
+func TestDegrader_NoPolicy(t *testing.T) {
+	d := NewDegrader(Overload(func() bool { return true })).
+		Register(method, Rule{Policies: []Policy{CacheOnly(func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, errors.New("缓存未命中")
+		})}})
+	_, err := d.UnaryServerInterceptor()(context.Background(), &pb.ListArticlesRequest{},
+		&grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, map[Event]int64{{Method: method, Signal: "overload", Policy: PolicyNone}: 1}, d.Stats())
+}
+
+func TestSignals(t *testing.T) {
+	breaker := &mockBreaker{}
+	limited := &switchSignal{name: "limiter"}
+	// 规则里面的信号优先于默认的信号，按照顺序检查
+	d := NewDegrader(limited).Register(method, Rule{
+		Signals:  []Signal{Marked(), BreakerSignal(breaker, nil)},
+		Policies: []Policy{Static(&pb.ListArticlesResponse{})},
+	})
+	interceptor := d.UnaryServerInterceptor()
+	handlerErr := errors.New("数据库出错")
+	call := func(ctx context.Context) {
+		_, _ = interceptor(ctx, &pb.ListArticlesRequest{}, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, handlerErr
+			})
+	}
+	limited.on.Store(true)
+	call(context.Background())
+	// 正常处理的结果反馈给熔断器
+	assert.Equal(t, 1, breaker.failures)
+	call(context.WithValue(context.Background(), "RateLimited", true))
+	breaker.open = true
+	call(context.Background())
+	assert.Equal(t, 1, breaker.failures)
+
+	assert.Equal(t, map[Event]int64{
+		{Method: method, Signal: "marked", Policy: "static"}:  1,
+		{Method: method, Signal: "breaker", Policy: "static"}: 1,
+	}, d.Stats())
+}
+
+func TestStaleCache_Evict(t *testing.T) {
+	stale := NewStaleCache(byAuthor, 2, time.Minute)
+	ctx := context.Background()
+	for _, author := range []string{"a", "b"} {
+		stale.Record(ctx, &pb.ListArticlesRequest{Author: author}, &pb.ListArticlesResponse{})
+	}
+	// 用过 a 之后，淘汰的是 b
+	_, err := stale.Serve(ctx, &pb.ListArticlesRequest{Author: "a"})
+	require.NoError(t, err)
+	stale.Record(ctx, &pb.ListArticlesRequest{Author: "c"}, &pb.ListArticlesResponse{})
+	_, err = stale.Serve(ctx, &pb.ListArticlesRequest{Author: "b"})
+	assert.ErrorIs(t, err, ErrNotServed)
+	_, err = stale.Serve(ctx, &pb.ListArticlesRequest{Author: "a"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,169 @@
+package degrade
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"sync"
+	"time"
+)
+
+// ErrNotServed 降级策略处理不了这个请求，交给下一个策略
+var ErrNotServed = errors.New("降级策略无法处理这个请求")
+
+// Policy 降级策略
+type Policy interface {
+	Name() string
+	// Serve 返回 ErrNotServed 的时候会尝试下一个策略，其它的错误直接返回给调用方
+	Serve(ctx context.Context, req interface{}) (interface{}, error)
+}
+
+// Recorder 需要记录正常响应的策略，比如说 StaleCache
+type Recorder interface {
+	Record(ctx context.Context, req, resp interface{})
+}
+
+// KeyFunc 从请求里面提取缓存的 key
+type KeyFunc func(req interface{}) string
+
+// Loader 只查缓存的方法，比如说 ArticleService.ListArticlesFromCache
+type Loader func(ctx context.Context, req interface{}) (interface{}, error)
+
+type cacheOnly struct {
+	load Loader
+}
+
+// CacheOnly 只查缓存，不查数据库，缓存没有就交给下一个策略
+func CacheOnly(load Loader) Policy {
+	return cacheOnly{load: load}
+}
+
+func (c cacheOnly) Name() string {
+	return "cache_only"
+}
+
+func (c cacheOnly) Serve(ctx context.Context, req interface{}) (interface{}, error) {
+	resp, err := c.load(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotServed, err)
+	}
+	return resp, nil
+}
+
+// StaleCache 在本地保留最近的正常响应，降级的时候返回，哪怕已经过时了
+// 只保留 size 个 key，超出的时候淘汰最久没有被用到的那个
+type StaleCache struct {
+	mu       sync.Mutex
+	key      KeyFunc
+	size     int
+	maxStale time.Duration
+	clock    clock.Clock
+	entries  map[string]*list.Element
+	// 越靠前越是最近使用过的
+	lru *list.List
+}
+
+type staleEntry struct {
+	key  string
+	resp interface{}
+	at   time.Time
+}
+
+// NewStaleCache maxStale 是最多能接受多久以前的响应
+func NewStaleCache(key KeyFunc, size int, maxStale time.Duration) *StaleCache {
+	return NewStaleCacheWithClock(key, size, maxStale, clock.New())
+}
+
+func NewStaleCacheWithClock(key KeyFunc, size int, maxStale time.Duration, clk clock.Clock) *StaleCache {
+	return &StaleCache{
+		key:      key,
+		size:     size,
+		maxStale: maxStale,
+		clock:    clk,
+		entries:  make(map[string]*list.Element, size),
+		lru:      list.New(),
+	}
+}
+
+func (s *StaleCache) Name() string {
+	return "stale_cache"
+}
+
+func (s *StaleCache) Record(ctx context.Context, req, resp interface{}) {
+	key := s.key(req)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*staleEntry)
+		entry.resp, entry.at = resp, s.clock.Now()
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&staleEntry{key: key, resp: resp, at: s.clock.Now()})
+	for s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*staleEntry).key)
+	}
+}
+
+func (s *StaleCache) Serve(ctx context.Context, req interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[s.key(req)]
+	if !ok {
+		return nil, ErrNotServed
+	}
+	entry := elem.Value.(*staleEntry)
+	if s.clock.Since(entry.at) > s.maxStale {
+		return nil, ErrNotServed
+	}
+	s.lru.MoveToFront(elem)
+	return clone(entry.resp), nil
+}
+
+type static struct {
+	resp interface{}
+}
+
+// Static 返回固定的默认响应
+func Static(resp interface{}) Policy {
+	return static{resp: resp}
+}
+
+func (s static) Name() string {
+	return "static"
+}
+
+func (s static) Serve(ctx context.Context, req interface{}) (interface{}, error) {
+	return clone(s.resp), nil
+}
+
+type rejectPolicy struct {
+	info reject.Info
+}
+
+// Reject 直接拒绝，一般放在最后
+func Reject(info reject.Info) Policy {
+	return rejectPolicy{info: info}
+}
+
+func (r rejectPolicy) Name() string {
+	return "reject"
+}
+
+func (r rejectPolicy) Serve(ctx context.Context, req interface{}) (interface{}, error) {
+	return nil, reject.Error(r.info)
+}
+
+// clone 同一个响应会返回给多个请求，gRPC 序列化的时候不能被别人改动
+func clone(resp interface{}) interface{} {
+	if msg, ok := resp.(proto.Message); ok {
+		return proto.Clone(msg)
+	}
+	return resp
+}
@@ -0,0 +1,108 @@
+package degrade
+
+import (
+	"context"
+)
+
+// Signal 决定请求要不要降级
+type Signal interface {
+	Name() string
+	// Triggered 返回 true 代表这个请求要走降级策略
+	Triggered(ctx context.Context, method string) bool
+}
+
+// Reporter 需要知道请求正常处理的结果的信号，比如说熔断器
+type Reporter interface {
+	Report(ctx context.Context, method string, err error)
+}
+
+// Allower 限流器，interceptor.TokenBucket 就满足这个接口
+type Allower interface {
+	Allow() bool
+}
+
+type limiterSignal struct {
+	l Allower
+}
+
+// Limiter 限流器拿不到令牌就降级
+func Limiter(l Allower) Signal {
+	return limiterSignal{l: l}
+}
+
+func (s limiterSignal) Name() string {
+	return "limiter"
+}
+
+func (s limiterSignal) Triggered(ctx context.Context, method string) bool {
+	return !s.l.Allow()
+}
+
+// Breaker 熔断器
+type Breaker interface {
+	// Allow 熔断器打开的时候返回 false
+	Allow() bool
+	MarkSuccess()
+	MarkFailed()
+}
+
+type breakerSignal struct {
+	b Breaker
+	// 哪些错误算作失败，为 nil 的时候所有错误都算
+	isFailure func(err error) bool
+}
+
+// BreakerSignal 熔断器打开的时候降级，正常处理的结果会反馈给熔断器
+func BreakerSignal(b Breaker, isFailure func(err error) bool) Signal {
+	return breakerSignal{b: b, isFailure: isFailure}
+}
+
+func (s breakerSignal) Name() string {
+	return "breaker"
+}
+
+func (s breakerSignal) Triggered(ctx context.Context, method string) bool {
+	return !s.b.Allow()
+}
+
+func (s breakerSignal) Report(ctx context.Context, method string, err error) {
+	if err != nil && (s.isFailure == nil || s.isFailure(err)) {
+		s.b.MarkFailed()
+		return
+	}
+	s.b.MarkSuccess()
+}
+
+type funcSignal struct {
+	name string
+	fn   func() bool
+}
+
+// Overload 按照系统负载降级，比如说 CPU 或者内存使用率过高
+func Overload(fn func() bool) Signal {
+	return funcSignal{name: "overload", fn: fn}
+}
+
+func (s funcSignal) Name() string {
+	return s.name
+}
+
+func (s funcSignal) Triggered(ctx context.Context, method string) bool {
+	return s.fn()
+}
+
+type markedSignal struct{}
+
+// Marked 兼容 interceptor.UnaryServerInterceptor，它会在被限流的请求的 ctx 里面打上 RateLimited 标记
+func Marked() Signal {
+	return markedSignal{}
+}
+
+func (s markedSignal) Name() string {
+	return "marked"
+}
+
+func (s markedSignal) Triggered(ctx context.Context, method string) bool {
+	limited, ok := ctx.Value("RateLimited").(bool)
+	return ok && limited
+}
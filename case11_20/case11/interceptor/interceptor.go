@@ -3,6 +3,9 @@ package interceptor
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"interview-cases/reject"
+	"net"
 )
 
 func UnaryServerInterceptor(tb *TokenBucket) grpc.UnaryServerInterceptor {
@@ -14,3 +17,51 @@ func UnaryServerInterceptor(tb *TokenBucket) grpc.UnaryServerInterceptor {
 		return handler(ctx, req)
 	}
 }
+
+// RejectingUnaryServerInterceptor 被限流的请求直接返回 codes.ResourceExhausted，
+// 并且告诉调用方多久之后才会有令牌
+func RejectingUnaryServerInterceptor(tb *TokenBucket) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !tb.Consume(1) {
+			return nil, reject.Error(reject.Info{
+				Limit:      int(tb.capacity),
+				Remaining:  int(tb.Tokens()),
+				RetryAfter: tb.RetryAfter(1),
+				Subject:    "method:" + info.FullMethod,
+			})
+		}
+		return handler(ctx, req)
+	}
+}
+
+// KeyFunc 决定请求使用哪个令牌桶
+type KeyFunc func(ctx context.Context, info *grpc.UnaryServerInfo) string
+
+// ByPeer 按照调用者的 IP 限流
+func ByPeer(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// ByMethod 按照方法限流
+func ByMethod(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	return info.FullMethod
+}
+
+// KeyedUnaryServerInterceptor 每个 key 单独一个令牌桶
+// 和 UnaryServerInterceptor 一样，被限流的请求只是打上标记，由业务决定怎么降级
+func KeyedUnaryServerInterceptor(registry *TokenBucketRegistry, keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !registry.Get(keyFunc(ctx, info)).Allow() {
+			ctx = context.WithValue(ctx, "RateLimited", true)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,38 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"testing"
+	"time"
+)
+
+func TestRejectingUnaryServerInterceptor(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	interceptor := RejectingUnaryServerInterceptor(NewTokenBucketWithClock(1, 4, clk))
+	info := &grpc.UnaryServerInfo{FullMethod: "/article.ArticleService/GetArticle"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	resp, err := interceptor(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	// 每秒 4 个令牌，过了 100ms 还要再等 150ms
+	clk.Advance(100 * time.Millisecond)
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	retryAfter, ok := reject.RetryAfter(err)
+	require.True(t, ok)
+	assert.Equal(t, 150*time.Millisecond, retryAfter)
+
+	clk.Advance(retryAfter)
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,61 @@
+package interceptor
+
+import (
+	"container/list"
+	"sync"
+)
+
+// TokenBucketRegistry 按照 key 维护令牌桶，key 可以是调用者，也可以是方法
+// 调用者的数量是没有上限的，所以最多只保留 size 个令牌桶，
+// 超出的时候淘汰最久没有被使用的那个。被淘汰的令牌桶下次用到的时候会重新创建一个满的，
+// 而长时间没有请求的令牌桶本来就是满的，所以淘汰它们不影响限流的效果
+type TokenBucketRegistry struct {
+	mu       sync.Mutex
+	size     int
+	capacity int64
+	rate     int64
+	buckets  map[string]*list.Element
+	// 越靠前越是最近使用过的
+	lru *list.List
+}
+
+type registryEntry struct {
+	key    string
+	bucket *TokenBucket
+}
+
+// NewTokenBucketRegistry capacity 和 rate 是每个令牌桶的参数
+func NewTokenBucketRegistry(size int, capacity, rate int64) *TokenBucketRegistry {
+	return &TokenBucketRegistry{
+		size:     size,
+		capacity: capacity,
+		rate:     rate,
+		buckets:  make(map[string]*list.Element, size),
+		lru:      list.New(),
+	}
+}
+
+// Get 获取 key 对应的令牌桶，不存在就创建一个
+func (r *TokenBucketRegistry) Get(key string) *TokenBucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.buckets[key]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*registryEntry).bucket
+	}
+	bucket := NewTokenBucket(r.capacity, r.rate)
+	r.buckets[key] = r.lru.PushFront(&registryEntry{key: key, bucket: bucket})
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.buckets, oldest.Value.(*registryEntry).key)
+	}
+	return bucket
+}
+
+// Len 当前有多少个令牌桶
+func (r *TokenBucketRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.Len()
+}
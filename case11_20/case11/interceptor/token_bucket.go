@@ -1,27 +1,63 @@
 package interceptor
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/clock"
+	"math"
 	"sync"
 	"time"
 )
 
+var ErrExceedCapacity = errors.New("请求的令牌数超过了令牌桶容量")
+
 // TokenBucket 代表一个令牌桶限流器
 type TokenBucket struct {
-	mu          sync.Mutex
-	capacity    int64 // 桶的最大容量
-	tokens      int64 // 当前令牌数
+	mu       sync.Mutex
+	capacity int64 // 桶的最大容量
+	// 当前令牌数，用浮点数是为了不丢掉不足一秒的那部分令牌
+	// 有预约的时候可能是负数，代表已经被预支了的令牌
+	tokens      float64
 	rate        int64 // 每秒生成的令牌数
 	lastUpdated time.Time
+	// lastEvent 最后一个预约可以使用令牌的时间，取消预约的时候据此判断有多少令牌已经被后面的预约预支了
+	lastEvent time.Time
+	clock     clock.Clock
 }
 
 // NewTokenBucket 创建一个新的令牌桶限流器
 func NewTokenBucket(capacity, rate int64) *TokenBucket {
+	return NewTokenBucketWithClock(capacity, rate, clock.New())
+}
+
+// NewTokenBucketWithClock 测试的时候传入 clock.Fake，不需要真的等令牌生成
+func NewTokenBucketWithClock(capacity, rate int64, clk clock.Clock) *TokenBucket {
 	return &TokenBucket{
 		capacity:    capacity,
-		tokens:      capacity, // 初始化时满桶
+		tokens:      float64(capacity), // 初始化时满桶
 		rate:        rate,
-		lastUpdated: time.Now(),
+		lastUpdated: clk.Now(),
+		clock:       clk,
+	}
+}
+
+// refill 按照流逝的时间连续地补充令牌，调用者要持有锁
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.lastUpdated)
+	if elapsed <= 0 {
+		return
 	}
+	tb.lastUpdated = now
+	// 手动 Add 的令牌可能超过容量，这时候就不再补充了
+	if tb.tokens < float64(tb.capacity) {
+		tb.tokens = math.Min(float64(tb.capacity), tb.tokens+elapsed.Seconds()*float64(tb.rate))
+	}
+}
+
+// Allow 尝试消费一个令牌
+func (tb *TokenBucket) Allow() bool {
+	return tb.Consume(1)
 }
 
 // Consume 尝试消费指定数量的令牌
@@ -29,34 +65,154 @@ func (tb *TokenBucket) Consume(tokens int64) bool {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	// 计算自从上次更新以来应该添加的令牌数量
-	tokenToAdd := tb.rate * int64(time.Since(tb.lastUpdated).Seconds())
-	if tokenToAdd > 0 {
-		tb.tokens = min(tb.capacity, tb.tokens+tokenToAdd)
-		tb.lastUpdated = time.Now()
-	}
-
-	if tb.tokens < tokens {
+	tb.refill(tb.clock.Now())
+	if tb.tokens < float64(tokens) {
 		return false // 不足，拒绝请求
 	}
 
-	tb.tokens -= tokens
+	tb.tokens -= float64(tokens)
 	return true // 允许请求
 }
 
+// Reserve 预约 n 个令牌
+// 令牌不够的时候会预支未来的令牌，返回的 Reservation 告诉调用者要等多久
+// 如果 n 超过了容量，或者令牌桶不再生成令牌，那么预约会失败，Reservation.OK 返回 false
+func (tb *TokenBucket) Reserve(n int64) *Reservation {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	tb.refill(now)
+	if n > tb.capacity {
+		return &Reservation{}
+	}
+	lack := float64(n) - tb.tokens
+	if lack > 0 && tb.rate <= 0 {
+		return &Reservation{}
+	}
+	var delay time.Duration
+	if lack > 0 {
+		delay = time.Duration(lack / float64(tb.rate) * float64(time.Second))
+	}
+	tb.tokens -= float64(n)
+	timeToAct := now.Add(delay)
+	tb.lastEvent = timeToAct
+	return &Reservation{
+		ok:        true,
+		tb:        tb,
+		tokens:    n,
+		timeToAct: timeToAct,
+	}
+}
+
+// Wait 阻塞直到拿到 n 个令牌，或者 ctx 过期
+func (tb *TokenBucket) Wait(ctx context.Context, n int64) error {
+	r := tb.Reserve(n)
+	if !r.OK() {
+		return fmt.Errorf("%w, 请求 %d 个令牌", ErrExceedCapacity, n)
+	}
+	delay := r.Delay()
+	if delay <= 0 {
+		return nil
+	}
+	// 等不到令牌生成就已经过期了，没必要傻等
+	// ctx 的超时时间总是真实的时间，所以比较的是剩余的时长
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		r.Cancel()
+		return context.DeadlineExceeded
+	}
+	timer := tb.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
 // Tokens 返回还剩余多少令牌
 func (tb *TokenBucket) Tokens() int64 {
-	return tb.tokens
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill(tb.clock.Now())
+	return int64(math.Floor(tb.tokens))
+}
+
+// RetryAfter 还要等多久才能有 n 个令牌，永远等不到的时候返回负数
+func (tb *TokenBucket) RetryAfter(n int64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill(tb.clock.Now())
+	lack := float64(n) - tb.tokens
+	if lack <= 0 {
+		return 0
+	}
+	if n > tb.capacity || tb.rate <= 0 {
+		return -1
+	}
+	return time.Duration(lack / float64(tb.rate) * float64(time.Second))
 }
 
 // Add 往令牌桶手动添加令牌 仅用于测试
 func (tb *TokenBucket) Add(count int64) {
-	tb.tokens += count
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens += float64(count)
 }
 
-func min(a, b int64) int64 {
-	if a < b {
-		return a
+// Reservation 一次令牌预约
+type Reservation struct {
+	ok        bool
+	tb        *TokenBucket
+	tokens    int64
+	timeToAct time.Time
+	canceled  bool
+}
+
+// OK 预约是否成功
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 还要等多久才能使用预约的令牌
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(math.MaxInt64)
+	}
+	return max(0, r.tb.clock.Until(r.timeToAct))
+}
+
+// Cancel 放弃预约，把令牌还回去
+// 已经到了可以使用的时间，说明令牌已经被用掉了，就不会再归还
+// 后面的预约已经预支了的那部分令牌也不会归还，否则令牌桶里的令牌会比实际的多
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.tb.mu.Lock()
+	defer r.tb.mu.Unlock()
+	if r.canceled {
+		return
+	}
+	now := r.tb.clock.Now()
+	if !now.Before(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+	// 在这个预约之后的预约，从 r.timeToAct 到 lastEvent 这段时间生成的令牌都已经预支出去了
+	restore := float64(r.tokens) - r.tb.lastEvent.Sub(r.timeToAct).Seconds()*float64(r.tb.rate)
+	if restore <= 0 {
+		return
+	}
+	r.tb.refill(now)
+	r.tb.tokens = math.Min(float64(r.tb.capacity), r.tb.tokens+restore)
+	if r.timeToAct.Equal(r.tb.lastEvent) {
+		// 最后一个预约取消了，lastEvent 退回到它之前
+		prev := r.timeToAct.Add(-time.Duration(float64(r.tokens) / float64(r.tb.rate) * float64(time.Second)))
+		if !prev.Before(now) {
+			r.tb.lastEvent = prev
+		}
 	}
-	return b
 }
@@ -0,0 +1,143 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/clock"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_FractionalRefill(t *testing.T) {
+	// 每秒 100 个令牌，也就是 10ms 一个
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	tb := NewTokenBucketWithClock(1, 100, clk)
+	require.True(t, tb.Allow())
+	assert.False(t, tb.Allow())
+	// 原本按秒取整，不足一秒一个令牌都补不回来
+	clk.Advance(5 * time.Millisecond)
+	assert.False(t, tb.Allow())
+	clk.Advance(5 * time.Millisecond)
+	assert.True(t, tb.Allow())
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	tb := NewTokenBucketWithClock(2, 10, clk)
+	r := tb.Reserve(2)
+	require.True(t, r.OK())
+	assert.Equal(t, time.Duration(0), r.Delay())
+
+	// 令牌不够，预支未来的令牌，要等 100ms
+	r = tb.Reserve(1)
+	require.True(t, r.OK())
+	assert.Equal(t, 100*time.Millisecond, r.Delay())
+	clk.Advance(40 * time.Millisecond)
+	assert.Equal(t, 60*time.Millisecond, r.Delay())
+
+	// 取消之后令牌还回去，后面的预约不需要等那么久
+	r.Cancel()
+	r = tb.Reserve(1)
+	require.True(t, r.OK())
+	assert.Equal(t, 60*time.Millisecond, r.Delay())
+	// 已经到了可以使用的时间，取消也不会归还令牌
+	clk.Advance(60 * time.Millisecond)
+	r.Cancel()
+	assert.Equal(t, int64(0), tb.Tokens())
+
+	// 超过容量
+	assert.False(t, tb.Reserve(3).OK())
+	// 不生成令牌的桶，不够的时候预约不了
+	assert.False(t, NewTokenBucket(1, 0).Reserve(2).OK())
+}
+
+// 被后面的预约预支了的令牌，取消的时候不会归还
+func TestTokenBucket_CancelBorrowed(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	tb := NewTokenBucketWithClock(2, 10, clk)
+	require.True(t, tb.Reserve(2).OK())
+	r1 := tb.Reserve(1)
+	require.Equal(t, 100*time.Millisecond, r1.Delay())
+	r2 := tb.Reserve(1)
+	require.Equal(t, 200*time.Millisecond, r2.Delay())
+
+	// r1 的令牌已经被 r2 预支了，什么都不还
+	r1.Cancel()
+	r3 := tb.Reserve(1)
+	assert.Equal(t, 300*time.Millisecond, r3.Delay())
+
+	// r3 是最后一个预约，令牌全部还回去
+	r3.Cancel()
+	r4 := tb.Reserve(1)
+	assert.Equal(t, 300*time.Millisecond, r4.Delay())
+	clk.Advance(300 * time.Millisecond)
+	assert.Equal(t, int64(0), tb.Tokens())
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	tb := NewTokenBucketWithClock(1, 20, clk)
+	require.True(t, tb.Allow())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.Wait(context.Background(), 1)
+	}()
+	// 50ms 才会生成一个令牌
+	clk.BlockUntil(1)
+	clk.Advance(49 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("令牌还没有生成")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	require.NoError(t, <-done)
+
+	// 超时时间内等不到令牌，直接返回，并且令牌不会被占用
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := tb.Wait(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	clk.Advance(50 * time.Millisecond)
+	assert.True(t, tb.Allow())
+
+	// ctx 被取消，预约的令牌还回去
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		done <- tb.Wait(ctx, 1)
+	}()
+	clk.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	clk.Advance(50 * time.Millisecond)
+	assert.True(t, tb.Allow())
+
+	err = tb.Wait(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrExceedCapacity)
+}
+
+func TestTokenBucketRegistry(t *testing.T) {
+	registry := NewTokenBucketRegistry(2, 1, 0)
+	a := registry.Get("a")
+	require.True(t, a.Allow())
+	// 同一个 key 拿到的是同一个令牌桶
+	assert.Same(t, a, registry.Get("a"))
+	assert.False(t, registry.Get("a").Allow())
+
+	registry.Get("b")
+	// a 最近被用过，所以淘汰的是 b
+	registry.Get("a")
+	registry.Get("c")
+	assert.Equal(t, 2, registry.Len())
+	assert.Same(t, a, registry.Get("a"))
+
+	for i := 0; i < 100; i++ {
+		registry.Get(fmt.Sprintf("key_%d", i))
+	}
+	assert.Equal(t, 2, registry.Len())
+	// a 被淘汰了，再次获取的是一个新的满的令牌桶
+	assert.True(t, registry.Get("a").Allow())
+}
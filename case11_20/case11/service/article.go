@@ -37,7 +37,8 @@ func (s *ArticleService) ListArticles(ctx context.Context, req *pb.ListArticlesR
 		return resp, nil
 	}
 
-	// 请求被限流
+	// 请求被限流，interceptor.UnaryServerInterceptor 会打上这个标记
+	// 用 degrade 的时候被限流的请求走降级策略，不会到这里
 	if rateLimited, ok := ctx.Value("RateLimited").(bool); ok && rateLimited {
 		return nil, errors.New("数据不存在redis")
 	}
@@ -50,6 +51,11 @@ func (s *ArticleService) ListArticles(ctx context.Context, req *pb.ListArticlesR
 	return resp, err
 }
 
+// ListArticlesFromCache 只查 redis，被限流的时候作为 degrade.CacheOnly 的降级策略
+func (s *ArticleService) ListArticlesFromCache(ctx context.Context, req interface{}) (interface{}, error) {
+	return s.getArticleListFromRedis(ctx, "article:"+req.(*pb.ListArticlesRequest).Author)
+}
+
 func (s *ArticleService) getArticleListFromRedis(ctx context.Context, key string) (*pb.ListArticlesResponse, error) {
 	// 从 Redis 获取文章列表
 	res, err := s.Client.Get(ctx, key).Bytes()
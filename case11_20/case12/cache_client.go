@@ -0,0 +1,245 @@
+package case12
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"interview-cases/clock"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrCacheMiss = errors.New("缓存不存在")
+
+const (
+	// 搬数据失败的时候间隔 100ms、200ms、400ms…… 重试，一共试 5 次
+	handoffAttempts = 5
+	handoffInterval = 100 * time.Millisecond
+)
+
+// CacheClient 通过 HashRing 找到缓存节点，槽迁移的时候在后台把数据从旧节点搬到新节点
+// 搬完之前，新节点上没有的 key 会再读一次旧节点并且回填，所以迁移不会带来大量的缓存未命中
+type CacheClient struct {
+	ring   *HashRing
+	client *http.Client
+	clock  clock.Clock
+
+	mu sync.RWMutex
+	// 正在迁移的槽，以及它原来的节点
+	// 一个槽还没搬完又迁移了的话，只记录最近一次的旧节点，双读只读这一个
+	transitions map[int]*Node
+	// 每个槽还没执行的迁移，同一个槽的迁移按顺序一个一个执行
+	pending  map[int][]Migration
+	handoffs sync.WaitGroup
+}
+
+// NewCacheClient 会注册 ring 的迁移回调，一个 ring 只能有一个 CacheClient
+func NewCacheClient(ring *HashRing, client *http.Client) *CacheClient {
+	return NewCacheClientWithClock(ring, client, clock.New())
+}
+
+// NewCacheClientWithClock 迁移失败之后重试的间隔由 clk 决定
+func NewCacheClientWithClock(ring *HashRing, client *http.Client, clk clock.Clock) *CacheClient {
+	c := &CacheClient{
+		ring:        ring,
+		client:      client,
+		clock:       clk,
+		transitions: make(map[int]*Node),
+		pending:     make(map[int][]Migration),
+	}
+	ring.OnMigrate(c.startHandoff)
+	return c
+}
+
+func (c *CacheClient) Get(ctx context.Context, uid int) (string, error) {
+	slot, node, _ := c.ring.Locate(uid)
+	key := strconv.Itoa(uid)
+	e, err := c.get(ctx, node, slot, key)
+	if !errors.Is(err, ErrCacheMiss) {
+		return e.Value, err
+	}
+	c.mu.RLock()
+	from, ok := c.transitions[slot]
+	c.mu.RUnlock()
+	if !ok || from == node {
+		return "", err
+	}
+	// 双读，旧节点上有就回填到新节点，带着原来的版本，不会覆盖更新的数据
+	e, err = c.get(ctx, from, slot, key)
+	if err != nil {
+		return "", err
+	}
+	if err := c.importSlot(ctx, node, slot, map[string]Entry{key: e}); err != nil {
+		slog.Error("回填缓存失败", slog.String("node", node.address), slog.Any("err", err))
+	}
+	return e.Value, nil
+}
+
+// Set 写入的值带着路由表的版本，迁移的时候靠它判断哪边的数据更新
+func (c *CacheClient) Set(ctx context.Context, uid int, val string) error {
+	slot, node, version := c.ring.Locate(uid)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.cacheURL(node, slot, strconv.Itoa(uid)), bytes.NewReader([]byte(val)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(VersionHeader, strconv.FormatUint(version, 10))
+	return c.send(req, nil)
+}
+
+// Wait 等待正在进行的迁移结束，方便测试
+func (c *CacheClient) Wait() {
+	c.handoffs.Wait()
+}
+
+// Migrating 正在迁移的槽的数量
+func (c *CacheClient) Migrating() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.transitions)
+}
+
+// startHandoff 在 ring 的写锁里面执行，只记录迁移的状态，搬数据在后台
+// 每个槽最多一个 goroutine 在搬数据，槽来回迁移的时候后面的迁移要等前面的搬完
+func (c *CacheClient) startHandoff(plan []Migration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range plan {
+		c.transitions[m.Slot] = m.From
+		queue, running := c.pending[m.Slot]
+		c.pending[m.Slot] = append(queue, m)
+		if !running {
+			c.handoffs.Add(1)
+			go c.drain(m.Slot)
+		}
+	}
+}
+
+// drain 按顺序执行一个槽的迁移，执行完了就退出
+func (c *CacheClient) drain(slot int) {
+	defer c.handoffs.Done()
+	for {
+		c.mu.Lock()
+		queue := c.pending[slot]
+		if len(queue) == 0 {
+			delete(c.pending, slot)
+			c.mu.Unlock()
+			return
+		}
+		m := queue[0]
+		c.pending[slot] = queue[1:]
+		c.mu.Unlock()
+		c.handoff(m)
+	}
+}
+
+// handoff 新节点从旧节点拉取整个槽，导入的时候不覆盖新节点上更新的数据
+// 失败了按照指数退避重试，重试的时候靠双读兜底，一直失败就放弃，没搬过来的数据只是缓存未命中
+func (c *CacheClient) handoff(m Migration) {
+	ctx := context.Background()
+	interval := handoffInterval
+	for i := 1; ; i++ {
+		err := c.copySlot(ctx, m)
+		if err == nil {
+			break
+		}
+		logger := slog.With(slog.Int("slot", m.Slot), slog.String("from", m.From.address),
+			slog.String("to", m.To.address), slog.Any("err", err))
+		if i == handoffAttempts {
+			logger.Error("迁移槽失败，放弃迁移")
+			break
+		}
+		logger.Warn("迁移槽失败，稍后重试", slog.Duration("interval", interval))
+		c.clock.Sleep(interval)
+		interval *= 2
+	}
+	c.mu.Lock()
+	// 迁移的过程中这个槽又迁移了，交给新的迁移处理
+	// 这时候 From 可能又是这个槽的节点了（A→B→A），不能删
+	moved := c.transitions[m.Slot] != m.From
+	if !moved {
+		delete(c.transitions, m.Slot)
+	}
+	c.mu.Unlock()
+	if moved || c.ring.owner(m.Slot) == m.From {
+		return
+	}
+	if err := c.do(ctx, http.MethodDelete, c.slotURL(m.From, m.Slot), nil, nil); err != nil {
+		slog.Error("删除槽失败", slog.Int("slot", m.Slot), slog.String("node", m.From.address), slog.Any("err", err))
+	}
+}
+
+// copySlot 把整个槽从 From 导出再导入到 To
+func (c *CacheClient) copySlot(ctx context.Context, m Migration) error {
+	var kvs map[string]Entry
+	err := c.do(ctx, http.MethodGet, c.slotURL(m.From, m.Slot), nil, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&kvs)
+	})
+	if err != nil {
+		return err
+	}
+	return c.importSlot(ctx, m.To, m.Slot, kvs)
+}
+
+func (c *CacheClient) get(ctx context.Context, node *Node, slot int, key string) (Entry, error) {
+	var e Entry
+	err := c.do(ctx, http.MethodGet, c.cacheURL(node, slot, key), nil, func(resp *http.Response) error {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		e.Value = string(data)
+		e.Version, err = strconv.ParseUint(resp.Header.Get(VersionHeader), 10, 64)
+		return err
+	})
+	return e, err
+}
+
+func (c *CacheClient) importSlot(ctx context.Context, node *Node, slot int, kvs map[string]Entry) error {
+	data, err := json.Marshal(kvs)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, c.slotURL(node, slot), bytes.NewReader(data), nil)
+}
+
+// do 404 返回 ErrCacheMiss，其它非 2xx 的响应都是错误
+func (c *CacheClient) do(ctx context.Context, method, u string, body io.Reader, decode func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	return c.send(req, decode)
+}
+
+func (c *CacheClient) send(req *http.Request, decode func(*http.Response) error) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrCacheMiss
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("缓存节点返回 %d", resp.StatusCode)
+	}
+	if decode == nil {
+		return nil
+	}
+	return decode(resp)
+}
+
+func (c *CacheClient) cacheURL(node *Node, slot int, key string) string {
+	return fmt.Sprintf("%s/cache/%d/%s", node.address, slot, url.PathEscape(key))
+}
+
+func (c *CacheClient) slotURL(node *Node, slot int) string {
+	return fmt.Sprintf("%s/slots/%d", node.address, slot)
+}
@@ -0,0 +1,167 @@
+package case12
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// VersionHeader 写入和读取的时候通过这个头传递数据的版本
+const VersionHeader = "X-Cache-Version"
+
+// CacheServer 一个缓存节点，数据按照槽分开存，迁移的时候整个槽导出、导入
+// 每个值都带着写入时路由表的版本，导入的时候只覆盖版本更小的数据
+//
+//	GET    /cache/{slot}/{key}  读取，不存在返回 404
+//	PUT    /cache/{slot}/{key}  写入
+//	GET    /slots/{slot}        导出整个槽
+//	POST   /slots/{slot}        导入，只覆盖版本更小的 key
+//	DELETE /slots/{slot}        删除整个槽
+type CacheServer struct {
+	mu    sync.RWMutex
+	slots map[int]map[string]Entry
+	mux   *http.ServeMux
+}
+
+// Entry 缓存的值和写入时的版本
+type Entry struct {
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+func NewCacheServer() *CacheServer {
+	s := &CacheServer{
+		slots: make(map[int]map[string]Entry),
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /cache/{slot}/{key}", s.get)
+	s.mux.HandleFunc("PUT /cache/{slot}/{key}", s.set)
+	s.mux.HandleFunc("GET /slots/{slot}", s.export)
+	s.mux.HandleFunc("POST /slots/{slot}", s.importSlot)
+	s.mux.HandleFunc("DELETE /slots/{slot}", s.deleteSlot)
+	return s
+}
+
+func (s *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Len 一共缓存了多少个 key
+func (s *CacheServer) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var cnt int
+	for _, kvs := range s.slots {
+		cnt += len(kvs)
+	}
+	return cnt
+}
+
+func slotOf(w http.ResponseWriter, r *http.Request) (int, bool) {
+	slot, err := strconv.Atoi(r.PathValue("slot"))
+	if err != nil {
+		http.Error(w, "槽不合法", http.StatusBadRequest)
+		return 0, false
+	}
+	return slot, true
+}
+
+func (s *CacheServer) get(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotOf(w, r)
+	if !ok {
+		return
+	}
+	s.mu.RLock()
+	e, ok := s.slots[slot][r.PathValue("key")]
+	s.mu.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set(VersionHeader, strconv.FormatUint(e.Version, 10))
+	_, _ = io.WriteString(w, e.Value)
+}
+
+func (s *CacheServer) set(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotOf(w, r)
+	if !ok {
+		return
+	}
+	var version uint64
+	if v := r.Header.Get(VersionHeader); v != "" {
+		var err error
+		if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "版本不合法", http.StatusBadRequest)
+			return
+		}
+	}
+	val, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kvs, ok := s.slots[slot]
+	if !ok {
+		kvs = make(map[string]Entry)
+		s.slots[slot] = kvs
+	}
+	kvs[r.PathValue("key")] = Entry{Value: string(val), Version: version}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *CacheServer) export(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotOf(w, r)
+	if !ok {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	kvs := s.slots[slot]
+	if kvs == nil {
+		kvs = map[string]Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(kvs)
+}
+
+// importSlot 迁移的时候新节点上可能已经有更新的数据了，不能覆盖，
+// 槽迁移走又迁移回来的时候旧节点上留着的是旧数据，要被覆盖
+func (s *CacheServer) importSlot(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotOf(w, r)
+	if !ok {
+		return
+	}
+	var kvs map[string]Entry
+	if err := json.NewDecoder(r.Body).Decode(&kvs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.slots[slot]
+	if !ok {
+		cur = make(map[string]Entry, len(kvs))
+		s.slots[slot] = cur
+	}
+	for k, e := range kvs {
+		if old, ok := cur[k]; !ok || old.Version < e.Version {
+			cur[k] = e
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *CacheServer) deleteSlot(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotOf(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	delete(s.slots, slot)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
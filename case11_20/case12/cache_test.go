@@ -0,0 +1,238 @@
+package case12
+
+import (
+	"context"
+	"fmt"
+	"interview-cases/clock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCacheNodes 启动 n 个进程内的缓存节点，导出槽的请求要等 gate 关闭之后才处理
+func newCacheNodes(t *testing.T, names []string, gate chan struct{}) ([]*Node, []*CacheServer) {
+	nodes := make([]*Node, 0, len(names))
+	servers := make([]*CacheServer, 0, len(names))
+	for _, name := range names {
+		cs := NewCacheServer()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/slots/") {
+				<-gate
+			}
+			cs.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		nodes = append(nodes, NewNode(name, server.URL))
+		servers = append(servers, cs)
+	}
+	return nodes, servers
+}
+
+func totalLen(servers []*CacheServer) int {
+	var cnt int
+	for _, s := range servers {
+		cnt += s.Len()
+	}
+	return cnt
+}
+
+// 测试场景
+// 3 个节点缓存了 120 个 key，加入第 4 个节点，数据还没有搬完的时候通过双读不会未命中，
+// 搬完之后旧节点上的数据被删掉，迁移过程中新写入的数据不会被旧数据覆盖
+func TestCacheClient_AddNode(t *testing.T) {
+	gate := make(chan struct{})
+	nodes, servers := newCacheNodes(t, []string{"a", "b", "c", "d"}, gate)
+	ring := NewHashRing(nodes[:3], 12, func(req any) int {
+		return req.(int) % 12
+	})
+	client := NewCacheClient(ring, http.DefaultClient)
+	ctx := context.Background()
+	for uid := 0; uid < 120; uid++ {
+		require.NoError(t, client.Set(ctx, uid, fmt.Sprintf("v%d", uid)))
+	}
+	_, err := client.Get(ctx, 1000)
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	// 3、7、11 号槽迁移到 d
+	plan, err := ring.AddNode(nodes[3])
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	assert.Equal(t, 3, client.Migrating())
+	assert.Equal(t, 0, servers[3].Len())
+	require.NoError(t, client.Set(ctx, 3, "new"))
+
+	for uid := 0; uid < 120; uid++ {
+		val, err := client.Get(ctx, uid)
+		require.NoError(t, err)
+		if uid == 3 {
+			assert.Equal(t, "new", val)
+			continue
+		}
+		assert.Equal(t, fmt.Sprintf("v%d", uid), val)
+	}
+	// 读过的 key 都回填到了 d
+	assert.Equal(t, 30, servers[3].Len())
+
+	close(gate)
+	client.Wait()
+	assert.Equal(t, 0, client.Migrating())
+	assert.Equal(t, 30, servers[3].Len())
+	// 旧节点上迁移走的槽被删掉了
+	assert.Equal(t, 120, totalLen(servers))
+	val, err := client.Get(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "new", val)
+}
+
+// 测试场景
+// 热点槽触发 Balance，迁移走的槽不需要双读也能读到
+func TestCacheClient_Balance(t *testing.T) {
+	gate := make(chan struct{})
+	close(gate)
+	nodes, servers := newCacheNodes(t, []string{"a", "b", "c"}, gate)
+	ring := NewHashRing(nodes, 12, func(req any) int {
+		return req.(int) % 12
+	})
+	client := NewCacheClient(ring, http.DefaultClient)
+	ctx := context.Background()
+	for uid := 0; uid < 120; uid++ {
+		require.NoError(t, client.Set(ctx, uid, fmt.Sprintf("v%d", uid)))
+	}
+
+	ring.SetRequestNumOfSlot([]int{1000, 1000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	ring.Balance()
+	client.Wait()
+	assert.Equal(t, uint64(1), ring.Version())
+	assert.Equal(t, 0, client.Migrating())
+	assert.Equal(t, 120, totalLen(servers))
+	// a 只剩下 0 号槽
+	assert.Equal(t, 10, servers[0].Len())
+	for uid := 0; uid < 120; uid++ {
+		val, err := client.Get(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("v%d", uid), val)
+	}
+}
+
+// 测试场景
+// 槽从 a 迁移到 d，还没搬完又迁移回 a，a 上的数据不会被删掉，
+// 在 d 上写入的数据会覆盖 a 上留着的旧数据，迁移回来之后新写入的数据也还在
+func TestCacheClient_MoveBack(t *testing.T) {
+	gate := make(chan struct{})
+	nodes, servers := newCacheNodes(t, []string{"a", "b", "c", "d"}, gate)
+	ring := NewHashRing(nodes[:3], 12, func(req any) int {
+		return req.(int) % 12
+	})
+	client := NewCacheClient(ring, http.DefaultClient)
+	ctx := context.Background()
+	for uid := 0; uid < 120; uid++ {
+		require.NoError(t, client.Set(ctx, uid, fmt.Sprintf("v%d", uid)))
+	}
+
+	// 3、7、11 号槽迁移到 d，再迁移回 a、b、c
+	plan, err := ring.AddNode(nodes[3])
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	require.NoError(t, client.Set(ctx, 3, "new"))
+	plan, err = ring.RemoveNode("d")
+	require.NoError(t, err)
+	require.Len(t, plan, 3)
+	assert.Equal(t, nodes[0], ring.GetNode(3))
+	assert.Equal(t, nodes[1], ring.GetNode(7))
+	require.NoError(t, client.Set(ctx, 7, "new"))
+
+	close(gate)
+	client.Wait()
+	assert.Equal(t, 0, client.Migrating())
+	assert.Equal(t, 0, servers[3].Len())
+	assert.Equal(t, 120, totalLen(servers))
+	for uid := 0; uid < 120; uid++ {
+		val, err := client.Get(ctx, uid)
+		require.NoError(t, err)
+		if uid == 3 || uid == 7 {
+			assert.Equal(t, "new", val)
+			continue
+		}
+		assert.Equal(t, fmt.Sprintf("v%d", uid), val)
+	}
+}
+
+// newFlakyNodes 启动 n 个进程内的缓存节点，前 failures 次导出槽的请求返回 500
+func newFlakyNodes(t *testing.T, names []string, failures int32) ([]*Node, []*CacheServer) {
+	var cnt atomic.Int32
+	cnt.Store(failures)
+	nodes := make([]*Node, 0, len(names))
+	servers := make([]*CacheServer, 0, len(names))
+	for _, name := range names {
+		cs := NewCacheServer()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/slots/") && cnt.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			cs.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		nodes = append(nodes, NewNode(name, server.URL))
+		servers = append(servers, cs)
+	}
+	return nodes, servers
+}
+
+// 测试场景
+// 搬数据失败之后按照 100ms、200ms 退避重试，第三次成功，旧节点上的槽被删掉
+// 一直失败的话试 5 次之后放弃，迁移状态和旧节点上的槽也都清理掉
+func TestCacheClient_HandoffRetry(t *testing.T) {
+	testCases := []struct {
+		name      string
+		failures  int32
+		intervals []time.Duration
+		wantNew   int
+	}{
+		{
+			name:      "重试成功",
+			failures:  2,
+			intervals: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+			wantNew:   10,
+		},
+		{
+			name:      "放弃迁移",
+			failures:  100,
+			intervals: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes, servers := newFlakyNodes(t, []string{"a", "b"}, tc.failures)
+			clk := clock.NewFake(time.Unix(1700000000, 0))
+			ring := NewHashRing(nodes[:1], 2, func(req any) int {
+				return req.(int) % 2
+			})
+			client := NewCacheClientWithClock(ring, http.DefaultClient, clk)
+			ctx := context.Background()
+			for uid := 0; uid < 20; uid++ {
+				require.NoError(t, client.Set(ctx, uid, fmt.Sprintf("v%d", uid)))
+			}
+
+			// 1 号槽迁移到 b
+			plan, err := ring.AddNode(nodes[1])
+			require.NoError(t, err)
+			require.Len(t, plan, 1)
+			for _, d := range tc.intervals {
+				clk.BlockUntil(1)
+				assert.Equal(t, 1, client.Migrating())
+				clk.Advance(d)
+			}
+			client.Wait()
+			assert.Equal(t, 0, client.Migrating())
+			assert.Equal(t, 10, servers[0].Len())
+			assert.Equal(t, tc.wantNew, servers[1].Len())
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package case12
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -73,3 +76,97 @@ func TestHashRing_Balance(t *testing.T) {
 		})
 	}
 }
+
+func TestHashRing_Membership(t *testing.T) {
+	a := &Node{name: "a", address: "a_address"}
+	b := &Node{name: "b", address: "b_address"}
+	c := &Node{name: "c", address: "c_address"}
+	d := &Node{name: "d", address: "d_address"}
+	// 一开始 a 是 0-3，b 是 4-7，c 是 8-11
+	h := NewHashRing([]*Node{a, b, c}, 12, func(req any) int {
+		return req.(int) % 12
+	})
+	assert.Equal(t, uint64(0), h.Version())
+
+	// 每个节点让出最后一个槽
+	plan, err := h.AddNode(d)
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Slot: 3, From: a, To: d},
+		{Slot: 7, From: b, To: d},
+		{Slot: 11, From: c, To: d},
+	}, plan)
+	assert.Equal(t, uint64(1), h.Version())
+	assert.Equal(t, d, h.GetNode(7))
+	assert.Equal(t, b, h.GetNode(6))
+
+	_, err = h.AddNode(&Node{name: "a"})
+	assert.ErrorIs(t, err, ErrNodeExists)
+
+	// b 的槽依次交给槽最少的节点
+	plan, err = h.RemoveNode("b")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Slot: 4, From: b, To: a},
+		{Slot: 5, From: b, To: c},
+		{Slot: 6, From: b, To: d},
+	}, plan)
+	assert.Equal(t, uint64(2), h.Version())
+	assert.Equal(t, []*Node{a, c, d}, h.Nodes())
+	for uid := 0; uid < 12; uid++ {
+		assert.NotEqual(t, b, h.GetNode(uid))
+	}
+
+	_, err = h.RemoveNode("b")
+	assert.ErrorIs(t, err, ErrNodeNotFound)
+	_, err = h.RemoveNode("a")
+	require.NoError(t, err)
+	_, err = h.RemoveNode("c")
+	require.NoError(t, err)
+	_, err = h.RemoveNode("d")
+	assert.ErrorIs(t, err, ErrLastNode)
+	for uid := 0; uid < 12; uid++ {
+		assert.Equal(t, d, h.GetNode(uid))
+	}
+}
+
+// 增删节点、Balance 的时候并发请求，需要 -race 运行
+func TestHashRing_Membership_Concurrent(t *testing.T) {
+	nodes := []*Node{
+		{name: "a", address: "a_address"},
+		{name: "b", address: "b_address"},
+	}
+	h := NewHashRing(nodes, DefaultHashRingSlotNum, func(req any) int {
+		return req.(int) % DefaultHashRingSlotNum
+	})
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for uid := i; !stop.Load(); uid += 8 {
+				assert.NotNil(t, h.GetNode(uid))
+			}
+		}(i)
+	}
+
+	var migrated int
+	for i := 0; i < 10; i++ {
+		plan, err := h.AddNode(&Node{name: fmt.Sprintf("n%d", i)})
+		require.NoError(t, err)
+		migrated += len(plan)
+		if i%3 == 0 {
+			_, err = h.RemoveNode(fmt.Sprintf("n%d", i))
+			require.NoError(t, err)
+		}
+	}
+	h.Balance()
+	stop.Store(true)
+	wg.Wait()
+
+	assert.Len(t, h.Nodes(), 8)
+	// 每次加节点只迁移新节点应得的那一份
+	assert.Less(t, migrated, 10*DefaultHashRingSlotNum/3)
+	assert.Equal(t, uint64(15), h.Version())
+}
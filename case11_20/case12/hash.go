@@ -1,27 +1,42 @@
 package case12
 
 import (
-	"fmt"
-	"github.com/ecodeclub/ekit/slice"
-	"math"
+	"interview-cases/clock"
 	"sync"
+	"time"
 )
 
 const DefaultHashRingSlotNum = 1024
 
+const (
+	// 默认统计最近 10 秒每个槽的请求数
+	defaultSlotWindowBuckets = 10
+	defaultSlotWindowWidth   = time.Second
+)
+
 type HashCodeFunc func(req any) int
 
 type HashRing struct {
-	nodes            []*Node
-	slotOfNodes      []*Node
-	requestNumOfSlot []int
+	nodes       []*Node
+	slotOfNodes []*Node
+	// 窗口内每个槽的请求数，过期的请求会被遗忘
+	requestNumOfSlot *slotWindow
 	slotNum          int
 	nodeNum          int
 	lock             sync.RWMutex
 	hashCodeFunc     HashCodeFunc
+	// 路由表每变化一次加一
+	version uint64
+	// 槽迁移的时候在写锁里面回调，新的路由生效之前就能知道哪些槽在迁移
+	onMigrate func([]Migration)
 }
 
 func NewHashRing(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc) *HashRing {
+	return NewHashRingWithClock(nodes, slotNum, hashCodeFunc, clock.New())
+}
+
+// NewHashRingWithClock 请求统计的窗口由 clk 决定
+func NewHashRingWithClock(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc, clk clock.Clock) *HashRing {
 	cnt := len(nodes)
 	avg := slotNum / cnt
 	ns := make([]*Node, 0, slotNum)
@@ -33,7 +48,7 @@ func NewHashRing(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc) *HashRin
 		} else {
 			total += avg
 		}
-		for ; k <= total; k++ {
+		for ; k < total; k++ {
 			ns = append(ns, nodes[i])
 		}
 
@@ -41,7 +56,7 @@ func NewHashRing(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc) *HashRin
 	return &HashRing{
 		nodes:            nodes,
 		slotOfNodes:      ns,
-		requestNumOfSlot: make([]int, slotNum),
+		requestNumOfSlot: newSlotWindow(slotNum, defaultSlotWindowBuckets, defaultSlotWindowWidth, clk),
 		slotNum:          slotNum,
 		nodeNum:          len(nodes),
 		hashCodeFunc:     hashCodeFunc,
@@ -49,99 +64,67 @@ func NewHashRing(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc) *HashRin
 }
 
 func (h *HashRing) GetNode(uid int) *Node {
+	_, node, _ := h.Locate(uid)
+	return node
+}
+
+// Locate 返回 uid 所在的槽和节点，以及这时候路由表的版本
+// 同一个版本里一个槽只在一个节点上，所以版本越大写入的数据越新
+func (h *HashRing) Locate(uid int) (int, *Node, uint64) {
 	sKey := h.hashCodeFunc(uid)
 	h.countSlotRequest(sKey)
-	return h.slotOfNodes[sKey]
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return sKey, h.slotOfNodes[sKey], h.version
 }
 
-func (h *HashRing) countSlotRequest(sKey int) {
-	h.requestNumOfSlot[sKey]++
+// owner 槽现在所在的节点，不计入请求数
+func (h *HashRing) owner(slot int) *Node {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.slotOfNodes[slot]
 }
 
-func (h *HashRing) Balance() {
+// OnMigrate 注册槽迁移的回调，回调是在写锁里面执行的，不能阻塞，也不能再调用 HashRing 的方法
+func (h *HashRing) OnMigrate(fn func([]Migration)) {
 	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.onMigrate = fn
+}
 
-	// 计算前缀和，方便快速计算子数组的和
-	prefixSum := h.prefixSums()
-
-	totalRequest := slice.Sum[int](h.requestNumOfSlot)
-	avgRequest := totalRequest / h.nodeNum
-
-	//fmt.Printf("总请求数为：%d，平均请求数为：%d\n", totalRequest, avgRequest)
-
-	// dp[i][j] 表示前 i 个元素分成 j 段，最小的偏差值
-	dp := make([][]int, h.slotNum+1)
-	cuts := make([][]int, h.slotNum+1)
-	for i := range dp {
-		dp[i] = make([]int, h.nodeNum+1)
-		cuts[i] = make([]int, h.nodeNum+1)
-		for j := range dp[i] {
-			if j == 0 {
-				dp[i][j] = 0 // 初始状态
-			} else {
-				dp[i][j] = math.MaxInt64 // 其他状态初始化为最大值
-			}
-		}
-	}
-
-	// 动态规划计算
-	for j := 2; j <= h.nodeNum; j++ {
-		for i := j; i <= h.slotNum; i++ {
-			for k := j - 1; k < i; k++ {
-				sum := prefixSum[i] - prefixSum[k] // 计算子数组和
-				diff := int(math.Abs(float64(avgRequest - sum)))
-				if dp[k][j-1]+diff < dp[i][j] {
-					dp[i][j] = dp[k][j-1] + diff
-					cuts[i][j] = k
-				}
-			}
-			//fmt.Printf("dp[%d][%d] = %d\n", i, j, dp[i][j])     // 打印dp表更新
-			//fmt.Printf("cuts[%d][%d] = %d\n", i, j, cuts[i][j]) // 打印cuts表更新
-		}
-	}
-
-	// 回溯找到切割点
-	bestSplitKey := make([]int, h.nodeNum)
-	bestSplit := make([][]int, h.nodeNum)
-	idx := h.slotNum
-	for j := h.nodeNum; j > 0; j-- {
-		prevIdx := cuts[idx][j]
-		bestSplitKey[j-1] = idx
-		bestSplit[j-1] = h.requestNumOfSlot[prevIdx:idx]
-		//fmt.Printf("回溯切割点: %d, 子数组: %v\n", prevIdx, bestSplit[j-1]) // 打印切割点
-		idx = prevIdx
+// notifyMigrate 调用者要持有写锁
+func (h *HashRing) notifyMigrate(plan []Migration) {
+	if h.onMigrate != nil && len(plan) > 0 {
+		h.onMigrate(plan)
 	}
+}
 
-	// 打印最终结果
-	for i, subarray := range bestSplit {
-		fmt.Printf("子数组 %d: %v, 和: %d\n", i+1, subarray, slice.Sum[int](subarray))
-	}
-	//fmt.Printf("最小偏差总和: %d\n", dp[h.slotNum][h.nodeNum])
-
-	// 初始化hash槽
-	ns := make([]*Node, 0, h.slotNum)
-	key := 0
-	for k, v := range h.nodes {
-		for ; key < bestSplitKey[k]; key++ {
-			ns = append(ns, v)
-		}
-	}
+func (h *HashRing) countSlotRequest(sKey int) {
+	h.requestNumOfSlot.add(sKey, 1)
+}
 
-	h.slotOfNodes = ns
-	h.requestNumOfSlot = make([]int, h.slotNum)
-	h.lock.Unlock()
+// Version 路由表的版本
+func (h *HashRing) Version() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.version
 }
 
-// 计算数组的部分和
-func (h *HashRing) prefixSums() []int {
-	prefixSum := make([]int, h.slotNum+1)
-	for i := 1; i <= h.slotNum; i++ {
-		prefixSum[i] = prefixSum[i-1] + h.requestNumOfSlot[i-1]
+// Balance 按照窗口内每个槽的请求数重新切分，不管现在均不均匀
+// 路由表在计算的过程中被修改了就重新计算
+func (h *HashRing) Balance() {
+	for {
+		res := h.computeBalance()
+		if _, ok := h.applyBalance(res); ok {
+			return
+		}
 	}
-	return prefixSum
 }
 
-// 方便测试
+// 方便测试，覆盖当前窗口的请求数
 func (h *HashRing) SetRequestNumOfSlot(requestNums []int) {
-	h.requestNumOfSlot = requestNums
+	h.requestNumOfSlot.reset()
+	for i, n := range requestNums {
+		h.requestNumOfSlot.add(i, int64(n))
+	}
 }
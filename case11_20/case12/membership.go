@@ -0,0 +1,117 @@
+package case12
+
+import (
+	"errors"
+)
+
+var (
+	ErrNodeExists   = errors.New("节点已经存在")
+	ErrNodeNotFound = errors.New("节点不存在")
+	ErrLastNode     = errors.New("不能删除最后一个节点")
+)
+
+// Migration 一个槽从 From 迁移到 To
+// 调用者按照迁移计划搬数据，数据搬完之前 From 上的缓存还是有效的
+type Migration struct {
+	Slot int
+	From *Node
+	To   *Node
+}
+
+// AddNode 加入一个节点，从槽最多的节点上依次拿一个槽给新节点，
+// 直到新节点拿到平均数量的槽，其它槽都不动，所以迁移的槽是最少的
+func (h *HashRing) AddNode(node *Node) ([]Migration, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if indexOfNode(h.nodes, node.name) >= 0 {
+		return nil, ErrNodeExists
+	}
+	owned := h.ownedSlots()
+	h.nodes = append(h.nodes, node)
+	h.nodeNum = len(h.nodes)
+
+	target := h.slotNum / h.nodeNum
+	plan := make([]Migration, 0, target)
+	for len(plan) < target {
+		// 槽最多的节点，一样多的时候取前面的，结果是确定的
+		var from *Node
+		for _, n := range h.nodes[:len(h.nodes)-1] {
+			if from == nil || len(owned[n]) > len(owned[from]) {
+				from = n
+			}
+		}
+		// 从后往前拿，剩下的槽尽量连续
+		slots := owned[from]
+		slot := slots[len(slots)-1]
+		owned[from] = slots[:len(slots)-1]
+		plan = append(plan, Migration{Slot: slot, From: from, To: node})
+	}
+	h.apply(plan)
+	return plan, nil
+}
+
+// RemoveNode 删除一个节点，它的槽每次交给槽最少的节点，其它节点的槽都不动
+func (h *HashRing) RemoveNode(name string) ([]Migration, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	idx := indexOfNode(h.nodes, name)
+	if idx < 0 {
+		return nil, ErrNodeNotFound
+	}
+	if h.nodeNum == 1 {
+		return nil, ErrLastNode
+	}
+	removed := h.nodes[idx]
+	owned := h.ownedSlots()
+	nodes := make([]*Node, 0, len(h.nodes)-1)
+	nodes = append(nodes, h.nodes[:idx]...)
+	nodes = append(nodes, h.nodes[idx+1:]...)
+	h.nodes = nodes
+	h.nodeNum = len(nodes)
+
+	plan := make([]Migration, 0, len(owned[removed]))
+	for _, slot := range owned[removed] {
+		var to *Node
+		for _, n := range h.nodes {
+			if to == nil || len(owned[n]) < len(owned[to]) {
+				to = n
+			}
+		}
+		owned[to] = append(owned[to], slot)
+		plan = append(plan, Migration{Slot: slot, From: removed, To: to})
+	}
+	h.apply(plan)
+	return plan, nil
+}
+
+// Nodes 当前所有的节点
+func (h *HashRing) Nodes() []*Node {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	res := make([]*Node, len(h.nodes))
+	copy(res, h.nodes)
+	return res
+}
+
+// apply 按照迁移计划修改路由表，调用者要持有写锁
+// 请求统计保留，迁移之后 Balance 还能用
+func (h *HashRing) apply(plan []Migration) {
+	// 复制一份再替换，和 Balance 一样整体替换路由表
+	ns := make([]*Node, len(h.slotOfNodes))
+	copy(ns, h.slotOfNodes)
+	for _, m := range plan {
+		ns[m.Slot] = m.To
+	}
+	h.slotOfNodes = ns
+	h.version++
+	h.notifyMigrate(plan)
+}
+
+// ownedSlots 每个节点有哪些槽，从小到大，调用者要持有锁
+func (h *HashRing) ownedSlots() map[*Node][]int {
+	owned := make(map[*Node][]int, len(h.nodes))
+	for slot, n := range h.slotOfNodes {
+		owned[n] = append(owned[n], slot)
+	}
+	return owned
+}
@@ -11,6 +11,11 @@ type Node struct {
 	address string
 }
 
+// NewNode address 是缓存节点的地址，例如 CacheServer 的 http://127.0.0.1:8080
+func NewNode(name, address string) *Node {
+	return &Node{name: name, address: address}
+}
+
 func (n *Node) GetCache(uid int) (string, error) {
 	fileName := fmt.Sprintf("cache/c_%s_%d.txt", n.address, uid)
 	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
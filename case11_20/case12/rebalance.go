@@ -0,0 +1,302 @@
+package case12
+
+import (
+	"interview-cases/clock"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 请求太少的时候不均衡只是噪音
+	minRebalanceRequests = 1000
+	// 新的切分至少要把不均衡的程度降低 10%，不然不值得迁移
+	minRebalanceGain = 0.1
+)
+
+// slotWindow 按时间分桶统计每个槽的请求数，旧的桶会随着时间过期
+type slotWindow struct {
+	buckets []slotBucket
+	width   time.Duration
+	clock   clock.Clock
+}
+
+type slotBucket struct {
+	// 桶的开始时间，UnixNano
+	start  atomic.Int64
+	counts []int64
+}
+
+func newSlotWindow(slotNum, size int, width time.Duration, clk clock.Clock) *slotWindow {
+	w := &slotWindow{
+		buckets: make([]slotBucket, size),
+		width:   width,
+		clock:   clk,
+	}
+	for i := range w.buckets {
+		w.buckets[i].counts = make([]int64, slotNum)
+	}
+	return w
+}
+
+// current 返回当前时间对应的桶，桶过期了就清空
+// 清空和计数不是一个原子操作，桶切换的瞬间可能会丢掉几个计数，对于找热点来说可以接受
+func (w *slotWindow) current() *slotBucket {
+	start := w.clock.Now().Truncate(w.width).UnixNano()
+	b := &w.buckets[int(start/int64(w.width))%len(w.buckets)]
+	for {
+		old := b.start.Load()
+		if old >= start {
+			return b
+		}
+		if b.start.CompareAndSwap(old, start) {
+			for i := range b.counts {
+				atomic.StoreInt64(&b.counts[i], 0)
+			}
+			return b
+		}
+	}
+}
+
+func (w *slotWindow) add(slot int, n int64) {
+	atomic.AddInt64(&w.current().counts[slot], n)
+}
+
+// snapshot 窗口内每个槽的请求数
+func (w *slotWindow) snapshot() []int64 {
+	oldest := w.clock.Now().Truncate(w.width).Add(-w.width * time.Duration(len(w.buckets)-1)).UnixNano()
+	res := make([]int64, len(w.buckets[0].counts))
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.start.Load() < oldest {
+			continue
+		}
+		for slot := range b.counts {
+			res[slot] += atomic.LoadInt64(&b.counts[slot])
+		}
+	}
+	return res
+}
+
+func (w *slotWindow) reset() {
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		b.start.Store(0)
+		for slot := range b.counts {
+			atomic.StoreInt64(&b.counts[slot], 0)
+		}
+	}
+}
+
+// partition 把槽按顺序切成 n 段，让负载最大的一段尽量小，返回每一段的结束位置（不包含）
+// 二分最大负载，贪心检查能不能切成不超过 n 段，复杂度是 O(slots × log(total))。
+// 每个槽的负载额外加 1，没有请求的时候槽也能平均分配
+func partition(loads []int64, n int) []int {
+	weights := make([]int64, len(loads))
+	var lo, hi int64
+	for i, l := range loads {
+		weights[i] = l + 1
+		lo = max(lo, weights[i])
+		hi += weights[i]
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if segments(weights, mid) <= n {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	ends := make([]int, 0, n)
+	var sum int64
+	for i, w := range weights {
+		// 剩下的槽刚好够后面的节点每个分一个，也要切开
+		remainSlots, remainNodes := len(weights)-i, n-len(ends)-1
+		if i > 0 && len(ends) < n-1 && (sum+w > lo || remainSlots == remainNodes) {
+			ends = append(ends, i)
+			sum = 0
+		}
+		sum += w
+	}
+	ends = append(ends, len(weights))
+	// 槽比节点少的时候，后面的节点分不到槽
+	for len(ends) < n {
+		ends = append(ends, len(weights))
+	}
+	return ends
+}
+
+// segments 每一段不超过 limit 的时候最少要切成几段
+func segments(weights []int64, limit int64) int {
+	cnt := 1
+	var sum int64
+	for _, w := range weights {
+		if sum+w > limit {
+			cnt++
+			sum = 0
+		}
+		sum += w
+	}
+	return cnt
+}
+
+// imbalance 负载最大的节点是平均负载的多少倍，没有请求的时候是 1
+func imbalance(loads []int64, slotOfNodes []*Node, nodes []*Node) float64 {
+	nodeLoads := make(map[*Node]int64, len(nodes))
+	var total int64
+	for slot, l := range loads {
+		nodeLoads[slotOfNodes[slot]] += l
+		total += l
+	}
+	if total == 0 {
+		return 1
+	}
+	var maxLoad int64
+	for _, l := range nodeLoads {
+		maxLoad = max(maxLoad, l)
+	}
+	return float64(maxLoad) * float64(len(nodes)) / float64(total)
+}
+
+// Imbalance 窗口内负载最大的节点是平均负载的多少倍
+func (h *HashRing) Imbalance() float64 {
+	loads := h.requestNumOfSlot.snapshot()
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return imbalance(loads, h.slotOfNodes, h.nodes)
+}
+
+type balancePlan struct {
+	version     uint64
+	slotOfNodes []*Node
+	total       int64
+	// 切分前后的不均衡程度
+	before float64
+	after  float64
+}
+
+// computeBalance 只在复制数据的时候持有读锁，切分是在锁外面算的
+func (h *HashRing) computeBalance() balancePlan {
+	loads := h.requestNumOfSlot.snapshot()
+	h.lock.RLock()
+	version := h.version
+	nodes := append([]*Node(nil), h.nodes...)
+	before := imbalance(loads, h.slotOfNodes, nodes)
+	h.lock.RUnlock()
+
+	ns := make([]*Node, 0, len(loads))
+	for i, end := range partition(loads, len(nodes)) {
+		for len(ns) < end {
+			ns = append(ns, nodes[i])
+		}
+	}
+	var total int64
+	for _, l := range loads {
+		total += l
+	}
+	return balancePlan{
+		version:     version,
+		slotOfNodes: ns,
+		total:       total,
+		before:      before,
+		after:       imbalance(loads, ns, nodes),
+	}
+}
+
+// applyBalance 路由表在计算的过程中变了就放弃，返回 false
+func (h *HashRing) applyBalance(p balancePlan) ([]Migration, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.version != p.version {
+		return nil, false
+	}
+	var plan []Migration
+	for slot, n := range p.slotOfNodes {
+		if h.slotOfNodes[slot] != n {
+			plan = append(plan, Migration{Slot: slot, From: h.slotOfNodes[slot], To: n})
+		}
+	}
+	if len(plan) > 0 {
+		h.slotOfNodes = p.slotOfNodes
+		h.version++
+		h.notifyMigrate(plan)
+	}
+	return plan, true
+}
+
+// Rebalancer 后台定时检查，不均衡的程度超过阈值的时候自动重新切分
+// 两次切分之间至少间隔 cooldown，并且新的切分要明显更好才迁移，免得来回抖动
+type Rebalancer struct {
+	ring      *HashRing
+	threshold float64
+	cooldown  time.Duration
+	interval  time.Duration
+	clock     clock.Clock
+	lastAt    time.Time
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRebalancer 每隔 interval 检查一次，负载最大的节点超过平均负载的 threshold 倍的时候切分
+// 时间由 ring 的时钟决定
+func NewRebalancer(ring *HashRing, interval time.Duration, threshold float64, cooldown time.Duration) *Rebalancer {
+	r := newRebalancer(ring, interval, threshold, cooldown)
+	go r.loop()
+	return r
+}
+
+func newRebalancer(ring *HashRing, interval time.Duration, threshold float64, cooldown time.Duration) *Rebalancer {
+	return &Rebalancer{
+		ring:      ring,
+		threshold: threshold,
+		cooldown:  cooldown,
+		interval:  interval,
+		clock:     ring.requestNumOfSlot.clock,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (r *Rebalancer) loop() {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			r.check()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Close 停止后台检查，可以重复调用
+func (r *Rebalancer) Close() {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// check 检查一次，返回迁移计划，没有切分的时候返回 nil
+func (r *Rebalancer) check() []Migration {
+	now := r.clock.Now()
+	if !r.lastAt.IsZero() && now.Sub(r.lastAt) < r.cooldown {
+		return nil
+	}
+	p := r.ring.computeBalance()
+	if p.total < minRebalanceRequests || p.before <= r.threshold {
+		return nil
+	}
+	if p.after > p.before*(1-minRebalanceGain) {
+		return nil
+	}
+	plan, ok := r.ring.applyBalance(p)
+	if !ok || len(plan) == 0 {
+		return nil
+	}
+	r.lastAt = now
+	slog.Info("槽负载不均衡，重新切分", slog.Float64("before", p.before),
+		slog.Float64("after", p.after), slog.Int("migrations", len(plan)))
+	return plan
+}
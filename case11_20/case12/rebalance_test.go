@@ -0,0 +1,143 @@
+package case12
+
+import (
+	"testing"
+	"time"
+
+	"interview-cases/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	testCases := []struct {
+		name  string
+		loads []int64
+		n     int
+		want  []int
+	}{
+		{
+			name:  "没有请求的时候平均分配",
+			loads: make([]int64, 12),
+			n:     3,
+			want:  []int{4, 8, 12},
+		},
+		{
+			// 每个槽加 1 之后是 11 到 101，最大的一段最小是 61+71+81
+			name:  "递增的负载",
+			loads: []int64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+			n:     3,
+			want:  []int{5, 8, 10},
+		},
+		{
+			name:  "一个热点槽单独一段",
+			loads: []int64{0, 0, 1000, 0, 0, 0},
+			n:     3,
+			want:  []int{2, 3, 6},
+		},
+		{
+			name:  "热点在最后，前面的节点也至少分一个槽",
+			loads: []int64{0, 0, 0, 1000},
+			n:     3,
+			want:  []int{2, 3, 4},
+		},
+		{
+			name:  "槽比节点少",
+			loads: []int64{1, 1},
+			n:     3,
+			want:  []int{1, 2, 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, partition(tc.loads, tc.n))
+		})
+	}
+}
+
+func TestSlotWindow(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	w := newSlotWindow(4, 10, time.Second, clk)
+	w.add(0, 10)
+	clk.Advance(5 * time.Second)
+	w.add(1, 5)
+	assert.Equal(t, []int64{10, 5, 0, 0}, w.snapshot())
+	// 最早的桶滑出了窗口
+	clk.Advance(5 * time.Second)
+	w.add(1, 5)
+	assert.Equal(t, []int64{0, 10, 0, 0}, w.snapshot())
+	w.reset()
+	assert.Equal(t, []int64{0, 0, 0, 0}, w.snapshot())
+}
+
+// 测试场景
+// 3 个节点 12 个槽，a 上面的槽变热了，超过阈值之后自动切分，冷却时间内不会再切分
+func TestRebalancer(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	a := &Node{name: "a", address: "a_address"}
+	b := &Node{name: "b", address: "b_address"}
+	c := &Node{name: "c", address: "c_address"}
+	h := NewHashRingWithClock([]*Node{a, b, c}, 12, func(req any) int {
+		return req.(int) % 12
+	}, clk)
+	r := newRebalancer(h, time.Second, 1.5, time.Minute)
+	request := func(uid, n int) {
+		for i := 0; i < n; i++ {
+			h.GetNode(uid)
+		}
+	}
+
+	// 均匀的请求不会切分
+	for uid := 0; uid < 12; uid++ {
+		request(uid, 100)
+	}
+	assert.InDelta(t, 1.0, h.Imbalance(), 0.001)
+	assert.Nil(t, r.check())
+
+	// a 上面的 0 和 1 号槽变热了
+	request(0, 1200)
+	request(1, 1200)
+	assert.InDelta(t, 3*2800.0/3600, h.Imbalance(), 0.001)
+	plan := r.check()
+	require.NotEmpty(t, plan)
+	assert.Equal(t, uint64(1), h.Version())
+	// 每个热点槽单独一个节点，剩下的槽都给 c
+	assert.Equal(t, a, h.GetNode(0))
+	assert.Equal(t, b, h.GetNode(1))
+	assert.Equal(t, c, h.GetNode(2))
+	assert.Less(t, h.Imbalance(), 1.5)
+
+	// 冷却时间内热点换了位置也不切分
+	request(11, 5000)
+	assert.Greater(t, h.Imbalance(), 1.5)
+	assert.Nil(t, r.check())
+
+	// 过了冷却时间，窗口里面也只剩下新的热点，c 上面的 10 和 11 号槽
+	clk.Advance(time.Minute)
+	request(10, 2500)
+	request(11, 2500)
+	require.NotEmpty(t, r.check())
+	assert.Equal(t, uint64(2), h.Version())
+	assert.Equal(t, a, h.GetNode(9))
+	assert.Equal(t, b, h.GetNode(10))
+	assert.Equal(t, c, h.GetNode(11))
+
+	// 单个热点槽没法再切分了，不会来回迁移
+	clk.Advance(time.Minute)
+	request(11, 5000)
+	assert.Greater(t, h.Imbalance(), 1.5)
+	assert.Nil(t, r.check())
+	assert.Equal(t, uint64(2), h.Version())
+}
+
+func TestRebalancer_Close(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	h := NewHashRingWithClock([]*Node{{name: "a", address: "a_address"}}, 12, func(req any) int {
+		return req.(int) % 12
+	}, clk)
+	r := NewRebalancer(h, time.Second, 1.5, time.Minute)
+	r.Close()
+	// 重复关闭不会 panic
+	assert.NotPanics(t, r.Close)
+}
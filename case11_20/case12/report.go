@@ -0,0 +1,87 @@
+package case12
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Report 路由算法的评估结果
+type Report struct {
+	Name string
+	// 每个节点的 key 数量的标准差除以平均值，越小越均匀
+	LoadStdDev float64
+	// 加一个节点的时候迁移的 key 的比例，理想情况是 1/(n+1)
+	MovedOnAdd float64
+	// 删一个节点的时候迁移的 key 的比例，理想情况是 1/n
+	MovedOnRemove float64
+}
+
+// RouterFactory 用同一批节点创建路由，每一项评估都用一个新的路由
+type RouterFactory func(nodes []*Node) Router
+
+// Evaluate 用 nodeNum 个节点、keyNum 个 key 评估路由算法
+// 删除的是中间的节点，jump consistent hash 这种只能在末尾增删的算法会吃亏
+// 查找的耗时跑一遍计时不准，用 BenchmarkRouter_Route 对比
+func Evaluate(name string, newRouter RouterFactory, nodeNum, keyNum int) Report {
+	nodes := make([]*Node, 0, nodeNum)
+	for i := 0; i < nodeNum; i++ {
+		nodes = append(nodes, &Node{name: fmt.Sprintf("node-%d", i), address: fmt.Sprintf("node-%d_address", i)})
+	}
+	keys := make([]string, 0, keyNum)
+	for i := 0; i < keyNum; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	res := Report{Name: name}
+
+	r := newRouter(nodes)
+	before := make([]*Node, keyNum)
+	for i, key := range keys {
+		before[i] = r.Route(key)
+	}
+	res.LoadStdDev = loadStdDev(before, nodeNum)
+
+	_ = r.AddNode(&Node{name: fmt.Sprintf("node-%d", nodeNum), address: fmt.Sprintf("node-%d_address", nodeNum)})
+	res.MovedOnAdd = moved(r, keys, before)
+
+	r = newRouter(nodes)
+	_ = r.RemoveNode(nodes[nodeNum/2].name)
+	res.MovedOnRemove = moved(r, keys, before)
+	return res
+}
+
+func loadStdDev(owners []*Node, nodeNum int) float64 {
+	loads := make(map[*Node]int, nodeNum)
+	for _, n := range owners {
+		loads[n]++
+	}
+	avg := float64(len(owners)) / float64(nodeNum)
+	var sum float64
+	for _, l := range loads {
+		sum += (float64(l) - avg) * (float64(l) - avg)
+	}
+	// 一个 key 都没有分到的节点也要算进去
+	sum += float64(nodeNum-len(loads)) * avg * avg
+	return math.Sqrt(sum/float64(nodeNum)) / avg
+}
+
+func moved(r Router, keys []string, before []*Node) float64 {
+	var cnt int
+	for i, key := range keys {
+		if r.Route(key) != before[i] {
+			cnt++
+		}
+	}
+	return float64(cnt) / float64(len(keys))
+}
+
+// FormatReports 输出成表格，方便对比
+func FormatReports(reports []Report) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-12s %10s %10s %10s\n", "算法", "负载偏差", "加节点迁移", "删节点迁移")
+	for _, r := range reports {
+		fmt.Fprintf(&sb, "%-12s %9.2f%% %9.2f%% %9.2f%%\n",
+			r.Name, r.LoadStdDev*100, r.MovedOnAdd*100, r.MovedOnRemove*100)
+	}
+	return sb.String()
+}
@@ -0,0 +1,274 @@
+package case12
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Router 把 key 路由到节点
+// 不同的算法在均匀程度、增删节点时迁移的 key 数量和查找的开销上各有取舍，可以用 Evaluate 对比
+type Router interface {
+	Route(key string) *Node
+	AddNode(node *Node) error
+	RemoveNode(name string) error
+}
+
+// hash64 fnv 分布不够均匀，再用 splitmix64 打散一下
+func hash64(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return mix64(h.Sum64())
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// RingRouter 带虚拟节点的一致性哈希环
+// 每个节点在环上放 replicas 个虚拟节点，key 顺时针找到的第一个虚拟节点就是它的节点。
+// 增删节点只影响相邻的一段，虚拟节点越多越均匀，但是环越大查找越慢
+type RingRouter struct {
+	lock     sync.RWMutex
+	replicas int
+	hashes   []uint64
+	owners   map[uint64]*Node
+	nodes    []*Node
+}
+
+func NewRingRouter(nodes []*Node, replicas int) *RingRouter {
+	r := &RingRouter{
+		replicas: replicas,
+		owners:   make(map[uint64]*Node, len(nodes)*replicas),
+	}
+	for _, n := range nodes {
+		r.nodes = append(r.nodes, n)
+		r.place(n)
+	}
+	r.sort()
+	return r
+}
+
+// place 把节点的虚拟节点放到环上，调用者要持有写锁并且之后重新排序
+func (r *RingRouter) place(n *Node) {
+	for i := 0; i < r.replicas; i++ {
+		h := hash64(n.name + "#" + strconv.Itoa(i))
+		// 哈希冲突的概率很低，冲突了先到先得
+		if _, ok := r.owners[h]; ok {
+			continue
+		}
+		r.owners[h] = n
+		r.hashes = append(r.hashes, h)
+	}
+}
+
+func (r *RingRouter) sort() {
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *RingRouter) Route(key string) *Node {
+	h := hash64(key)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.owners[r.hashes[idx]]
+}
+
+func (r *RingRouter) AddNode(node *Node) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if indexOfNode(r.nodes, node.name) >= 0 {
+		return ErrNodeExists
+	}
+	r.nodes = append(r.nodes, node)
+	r.place(node)
+	r.sort()
+	return nil
+}
+
+func (r *RingRouter) RemoveNode(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	idx := indexOfNode(r.nodes, name)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	removed := r.nodes[idx]
+	r.nodes = append(r.nodes[:idx:idx], r.nodes[idx+1:]...)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == removed {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+	return nil
+}
+
+// JumpRouter Google 的 jump consistent hash
+// 不需要额外的内存，查找是 O(log n)，分布也很均匀，但是只能在末尾增删桶。
+// 删除中间的节点时把最后一个节点挪到它的位置，所以会多迁移最后一个节点的 key
+type JumpRouter struct {
+	lock  sync.RWMutex
+	nodes []*Node
+}
+
+func NewJumpRouter(nodes []*Node) *JumpRouter {
+	return &JumpRouter{nodes: append([]*Node(nil), nodes...)}
+}
+
+// jumpHash 返回 [0, buckets) 之间的桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (r *JumpRouter) Route(key string) *Node {
+	h := hash64(key)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.nodes) == 0 {
+		return nil
+	}
+	return r.nodes[jumpHash(h, len(r.nodes))]
+}
+
+func (r *JumpRouter) AddNode(node *Node) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if indexOfNode(r.nodes, node.name) >= 0 {
+		return ErrNodeExists
+	}
+	r.nodes = append(r.nodes, node)
+	return nil
+}
+
+func (r *JumpRouter) RemoveNode(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	idx := indexOfNode(r.nodes, name)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	last := len(r.nodes) - 1
+	nodes := append([]*Node(nil), r.nodes[:last]...)
+	if idx != last {
+		nodes[idx] = r.nodes[last]
+	}
+	r.nodes = nodes
+	return nil
+}
+
+// RendezvousRouter 最高随机权重哈希（HRW）
+// key 和每个节点算一个分数，分数最高的节点就是它的节点。
+// 增删节点只迁移最少的 key，也不需要虚拟节点，代价是每次查找都要遍历所有节点
+type RendezvousRouter struct {
+	lock  sync.RWMutex
+	nodes []*Node
+	// 节点名字的哈希，查找的时候不用重复计算
+	seeds []uint64
+}
+
+func NewRendezvousRouter(nodes []*Node) *RendezvousRouter {
+	r := &RendezvousRouter{}
+	for _, n := range nodes {
+		r.nodes = append(r.nodes, n)
+		r.seeds = append(r.seeds, hash64(n.name))
+	}
+	return r
+}
+
+func (r *RendezvousRouter) Route(key string) *Node {
+	h := hash64(key)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var res *Node
+	var best uint64
+	for i, n := range r.nodes {
+		score := mix64(h ^ r.seeds[i])
+		if res == nil || score > best {
+			res, best = n, score
+		}
+	}
+	return res
+}
+
+func (r *RendezvousRouter) AddNode(node *Node) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if indexOfNode(r.nodes, node.name) >= 0 {
+		return ErrNodeExists
+	}
+	r.nodes = append(r.nodes, node)
+	r.seeds = append(r.seeds, hash64(node.name))
+	return nil
+}
+
+func (r *RendezvousRouter) RemoveNode(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	idx := indexOfNode(r.nodes, name)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	r.nodes = append(r.nodes[:idx:idx], r.nodes[idx+1:]...)
+	r.seeds = append(r.seeds[:idx:idx], r.seeds[idx+1:]...)
+	return nil
+}
+
+// SlotRouter 把原来的槽位表 HashRing 适配成 Router
+// key 先哈希到槽，槽再查表找到节点，查找是 O(1)，增删节点按照迁移计划只动最少的槽
+type SlotRouter struct {
+	ring *HashRing
+}
+
+func NewSlotRouter(nodes []*Node, slotNum int) *SlotRouter {
+	return &SlotRouter{
+		ring: NewHashRing(nodes, slotNum, func(req any) int {
+			return req.(int)
+		}),
+	}
+}
+
+// Route 只查表，不像 HashRing.GetNode 那样统计槽的请求数
+func (r *SlotRouter) Route(key string) *Node {
+	return r.ring.owner(int(hash64(key) % uint64(r.ring.slotNum)))
+}
+
+func (r *SlotRouter) AddNode(node *Node) error {
+	_, err := r.ring.AddNode(node)
+	return err
+}
+
+func (r *SlotRouter) RemoveNode(name string) error {
+	_, err := r.ring.RemoveNode(name)
+	return err
+}
+
+func indexOfNode(nodes []*Node, name string) int {
+	for i, n := range nodes {
+		if n.name == name {
+			return i
+		}
+	}
+	return -1
+}
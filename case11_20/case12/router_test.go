@@ -0,0 +1,121 @@
+package case12
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var routerFactories = []struct {
+	name string
+	new  RouterFactory
+}{
+	{name: "ring", new: func(nodes []*Node) Router { return NewRingRouter(nodes, 160) }},
+	{name: "jump", new: func(nodes []*Node) Router { return NewJumpRouter(nodes) }},
+	{name: "rendezvous", new: func(nodes []*Node) Router { return NewRendezvousRouter(nodes) }},
+	{name: "slot", new: func(nodes []*Node) Router { return NewSlotRouter(nodes, DefaultHashRingSlotNum) }},
+}
+
+func newTestNodes(n int) []*Node {
+	nodes := make([]*Node, 0, n)
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &Node{name: fmt.Sprintf("node-%d", i), address: fmt.Sprintf("node-%d_address", i)})
+	}
+	return nodes
+}
+
+func TestRouter(t *testing.T) {
+	for _, f := range routerFactories {
+		t.Run(f.name, func(t *testing.T) {
+			nodes := newTestNodes(5)
+			r := f.new(nodes)
+			before := make(map[string]*Node, 1000)
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				before[key] = r.Route(key)
+				// 同一个 key 总是路由到同一个节点
+				assert.Equal(t, before[key], r.Route(key))
+			}
+
+			// 加节点的时候，key 要么不动，要么迁移到新节点
+			added := &Node{name: "node-5", address: "node-5_address"}
+			require.NoError(t, r.AddNode(added))
+			assert.ErrorIs(t, r.AddNode(added), ErrNodeExists)
+			for key, n := range before {
+				cur := r.Route(key)
+				if cur != n {
+					assert.Equal(t, added, cur)
+				}
+				before[key] = cur
+			}
+
+			// 删掉新节点的时候，只有新节点上的 key 迁移
+			require.NoError(t, r.RemoveNode(added.name))
+			assert.ErrorIs(t, r.RemoveNode(added.name), ErrNodeNotFound)
+			for key, n := range before {
+				cur := r.Route(key)
+				if n == added {
+					assert.NotEqual(t, added, cur)
+				} else {
+					assert.Equal(t, n, cur)
+				}
+			}
+		})
+	}
+}
+
+// 路由只查表，不会计入重新切分用的请求数
+func TestSlotRouter_RouteNotCounted(t *testing.T) {
+	r := NewSlotRouter(newTestNodes(3), DefaultHashRingSlotNum)
+	for i := 0; i < 100; i++ {
+		require.NotNil(t, r.Route(fmt.Sprintf("key-%d", i)))
+	}
+	assert.Equal(t, int64(0), r.ring.computeBalance().total)
+}
+
+// 测试场景
+// 10 个节点，10 万个 key，对比各个算法
+func TestEvaluate(t *testing.T) {
+	// 哈希环每个节点 160 个虚拟节点还是有 10% 左右的偏差，
+	// 删除中间的节点的时候，jump 要多迁移最后一个节点的 key
+	wants := map[string]struct {
+		maxStdDev     float64
+		movedOnRemove float64
+	}{
+		"ring":       {maxStdDev: 0.2, movedOnRemove: 0.1},
+		"jump":       {maxStdDev: 0.05, movedOnRemove: 0.2},
+		"rendezvous": {maxStdDev: 0.05, movedOnRemove: 0.1},
+		"slot":       {maxStdDev: 0.05, movedOnRemove: 0.1},
+	}
+	reports := make([]Report, 0, len(routerFactories))
+	for _, f := range routerFactories {
+		res := Evaluate(f.name, f.new, 10, 100000)
+		reports = append(reports, res)
+		want := wants[f.name]
+		assert.Less(t, res.LoadStdDev, want.maxStdDev, f.name)
+		// 理想情况是 1/11
+		assert.InDelta(t, 1.0/11, res.MovedOnAdd, 0.02, f.name)
+		assert.InDelta(t, want.movedOnRemove, res.MovedOnRemove, 0.02, f.name)
+	}
+	t.Log("\n" + FormatReports(reports))
+}
+
+func BenchmarkRouter_Route(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	for _, nodeNum := range []int{10, 100} {
+		for _, f := range routerFactories {
+			b.Run(fmt.Sprintf("%s/%d", f.name, nodeNum), func(b *testing.B) {
+				r := f.new(newTestNodes(nodeNum))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r.Route(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
@@ -2,6 +2,7 @@ package v4
 
 import (
 	"errors"
+	"interview-cases/clock"
 	"log"
 	"sync"
 	"time"
@@ -48,10 +49,22 @@ type Client struct {
 	mu               sync.RWMutex
 	recoveryInterval time.Duration
 	stopChan         chan struct{} // 用于停止后台恢复协程
+	clock            clock.Clock
+	// 每个服务一个自适应限流器
+	throttleMu sync.Mutex
+	throttles  map[string]*throttle
+	// 每个服务一份对冲配置和延迟统计
+	hedgeMu sync.Mutex
+	hedgers map[string]*hedger
 }
 
 // NewClient 创建一个新的客户端实例
 func NewClient(minWeight, maxWeight, defaultWeight int, lb LoadBalancer, recoveryInterval time.Duration) (*Client, error) {
+	return NewClientWithClock(minWeight, maxWeight, defaultWeight, lb, recoveryInterval, clock.New())
+}
+
+// NewClientWithClock 节点恢复的时间由 clk 决定，测试的时候不需要真的等
+func NewClientWithClock(minWeight, maxWeight, defaultWeight int, lb LoadBalancer, recoveryInterval time.Duration, clk clock.Clock) (*Client, error) {
 	if minWeight < 0 || maxWeight <= minWeight || defaultWeight < minWeight || defaultWeight > maxWeight {
 		return nil, errors.New("无效的权重配置")
 	}
@@ -62,6 +75,9 @@ func NewClient(minWeight, maxWeight, defaultWeight int, lb LoadBalancer, recover
 		loadBalancer:     lb,
 		recoveryInterval: recoveryInterval,
 		stopChan:         make(chan struct{}),
+		clock:            clk,
+		throttles:        make(map[string]*throttle),
+		hedgers:          make(map[string]*hedger),
 	}
 	go c.recoveryLoop()
 	return c, nil
@@ -69,11 +85,11 @@ func NewClient(minWeight, maxWeight, defaultWeight int, lb LoadBalancer, recover
 
 // recoveryLoop 后台恢复循环
 func (c *Client) recoveryLoop() {
-	ticker := time.NewTicker(c.recoveryInterval)
+	ticker := c.clock.NewTicker(c.recoveryInterval)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			c.tryRecoverNodes()
 		case <-c.stopChan:
 			return
@@ -90,14 +106,16 @@ func (c *Client) Close() {
 func (c *Client) AddNode(url string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	node := &Node{URL: url, Weight: c.defaultWeight, Status: StatusHealthy, LastCheckAt: time.Now()}
+	node := &Node{URL: url, Weight: c.defaultWeight, Status: StatusHealthy, LastCheckAt: c.clock.Now()}
 	c.healthyNodes = append(c.healthyNodes, node)
 }
 
 // GetNode 获取一个可用的服务节点
+// 不经过客户端自适应限流，也不计入限流的统计，需要限流的调用者用 GetNodeFor 和 Report
 func (c *Client) GetNode() (*Node, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	// 负载均衡器选节点的时候会修改自己的状态，惰性恢复也会修改节点，所以要用写锁
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	availableNodes := c.getAvailableNodes()
 	if len(availableNodes) == 0 {
@@ -109,7 +127,7 @@ func (c *Client) GetNode() (*Node, error) {
 // getAvailableNodes 获取所有可用的节点，并进行惰性恢复检查
 func (c *Client) getAvailableNodes() []*Node {
 	// 获取当前时间，用于比较节点的最后检查时间
-	now := time.Now()
+	now := c.clock.Now()
 	// 创建一个切片来存储所有可用的节点
 	var availableNodes []*Node
 
@@ -152,7 +170,7 @@ func (c *Client) UpdateNodeStatus(url string, err error) {
 		return
 	}
 
-	node.LastCheckAt = time.Now()
+	node.LastCheckAt = c.clock.Now()
 
 	if err == nil {
 		c.moveNode(node, StatusHealthy)
@@ -237,7 +255,7 @@ func (c *Client) tryRecoverNodes() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	now := time.Now()
+	now := c.clock.Now()
 	for i := 0; i < len(c.unhealthyNodes); {
 		node := c.unhealthyNodes[i]
 		if now.Sub(node.LastCheckAt) >= c.recoveryInterval {
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"interview-cases/clock"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -180,13 +182,19 @@ func TestUpdateNodeStatus_NonexistentNode(t *testing.T) {
 // TestTryRecoverNodes 测试节点恢复功能
 func TestTryRecoverNodes(t *testing.T) {
 	lb := new(MockLoadBalancer)
-	client, _ := NewClient(1, 10, 5, lb, time.Millisecond)
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	client, _ := NewClientWithClock(1, 10, 5, lb, time.Second, clk)
 	client.AddNode("http://example.com")
 
 	client.UpdateNodeStatus("http://example.com", ErrNetworkFailure)
 	assert.Len(t, client.unhealthyNodes, 1)
 
-	time.Sleep(2 * time.Millisecond) // 等待恢复间隔
+	// 还没到恢复间隔
+	clk.Advance(999 * time.Millisecond)
+	client.tryRecoverNodes()
+	assert.Len(t, client.unhealthyNodes, 1)
+
+	clk.Advance(time.Millisecond) // 等待恢复间隔
 	client.tryRecoverNodes()
 
 	assert.Len(t, client.unhealthyNodes, 0)
@@ -0,0 +1,287 @@
+package v4
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Attempt 在 node 上执行一次请求，ctx 被取消的时候要尽快返回
+type Attempt func(ctx context.Context, node *Node) error
+
+const (
+	// 默认在 p95 的延迟还没有返回的时候对冲
+	defaultHedgePercentile = 0.95
+	// 默认对冲的请求不超过请求数的 10%
+	defaultHedgeRatio = 0.1
+	// 最近多少个请求的延迟用来计算阈值
+	hedgeSamples = 100
+	// 每新增多少个样本重新计算一次阈值
+	hedgeRecomputeEvery = 10
+	// 样本太少的时候阈值没有意义，不对冲
+	minHedgeSamples = 10
+	// 对冲预算统计最近 10 秒的请求
+	defaultHedgeWindow  = 10 * time.Second
+	defaultHedgeBuckets = 10
+)
+
+// HedgeStats 对冲请求的统计
+type HedgeStats struct {
+	// 累计的请求数
+	Requests int64
+	// 累计发出去的对冲请求数
+	Hedged int64
+	// 累计对冲请求先返回的次数
+	HedgeWins int64
+	// 累计超过阈值但是因为预算不够没有对冲的次数
+	BudgetRejected int64
+	// 当前的对冲阈值，样本不够的时候是 0
+	Threshold time.Duration
+}
+
+// hedger 对冲请求
+// 第一个请求超过阈值还没有返回的时候，往另外一个节点再发一个请求，哪个先成功用哪个，另外一个取消掉。
+// 阈值是最近成功请求延迟的分位数，对冲的请求数不能超过窗口内请求数的 ratio 倍，
+// 免得下游整体变慢的时候所有请求都对冲，把下游的压力翻倍
+type hedger struct {
+	mu         sync.Mutex
+	percentile float64
+	ratio      float64
+	// 最近的延迟，环形缓冲区
+	latencies []time.Duration
+	next      int
+	// 缓存的阈值，以及计算之后新增的样本数
+	cached  time.Duration
+	stale   int
+	buckets []hedgeBucket
+	width   time.Duration
+	stats   HedgeStats
+}
+
+type hedgeBucket struct {
+	start    time.Time
+	requests int64
+	hedges   int64
+}
+
+func newHedger(percentile, ratio float64, window time.Duration, buckets int) *hedger {
+	return &hedger{
+		percentile: percentile,
+		ratio:      ratio,
+		latencies:  make([]time.Duration, 0, hedgeSamples),
+		buckets:    make([]hedgeBucket, buckets),
+		width:      window / time.Duration(buckets),
+	}
+}
+
+// current 返回 now 对应的桶，过期的桶会被清空，调用者要持有锁
+func (h *hedger) current(now time.Time) *hedgeBucket {
+	start := now.Truncate(h.width)
+	b := &h.buckets[int(start.UnixNano()/int64(h.width))%len(h.buckets)]
+	if !b.start.Equal(start) {
+		*b = hedgeBucket{start: start}
+	}
+	return b
+}
+
+// recompute 排序计算分位数，调用者要持有锁
+// 排序要复制整个样本，所以不在每个请求里面算，而是每 hedgeRecomputeEvery 个样本算一次
+func (h *hedger) recompute() {
+	h.stale = 0
+	if len(h.latencies) < minHedgeSamples {
+		h.cached = 0
+		return
+	}
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted)) * h.percentile)
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	h.cached = sorted[idx]
+}
+
+// begin 记录一次请求，返回对冲的阈值，0 表示不对冲
+func (h *hedger) begin(now time.Time) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current(now).requests++
+	h.stats.Requests++
+	return h.cached
+}
+
+// acquire 对冲之前检查预算
+func (h *hedger) acquire(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	oldest := now.Truncate(h.width).Add(-h.width * time.Duration(len(h.buckets)-1))
+	var requests, hedges int64
+	for _, b := range h.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		requests += b.requests
+		hedges += b.hedges
+	}
+	if float64(hedges+1) > h.ratio*float64(requests) {
+		h.stats.BudgetRejected++
+		return false
+	}
+	h.current(now).hedges++
+	h.stats.Hedged++
+	return true
+}
+
+// observe 只记录成功请求的延迟，被取消的请求不知道要多久
+func (h *hedger) observe(latency time.Duration, hedge bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < cap(h.latencies) {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % len(h.latencies)
+	}
+	h.stale++
+	// 样本刚好够的时候马上算一次，之后每攒够一批再算
+	if len(h.latencies) == minHedgeSamples || h.stale >= hedgeRecomputeEvery {
+		h.recompute()
+	}
+	if hedge {
+		h.stats.HedgeWins++
+	}
+}
+
+func (h *hedger) setPolicy(percentile, ratio float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.percentile = percentile
+	h.ratio = ratio
+	h.recompute()
+}
+
+func (h *hedger) snapshot() HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats
+	stats.Threshold = h.cached
+	return stats
+}
+
+// hedgerFor 获取服务的对冲配置，不存在就用默认的配置创建一个
+func (c *Client) hedgerFor(service string) *hedger {
+	c.hedgeMu.Lock()
+	defer c.hedgeMu.Unlock()
+	h, ok := c.hedgers[service]
+	if !ok {
+		h = newHedger(defaultHedgePercentile, defaultHedgeRatio, defaultHedgeWindow, defaultHedgeBuckets)
+		c.hedgers[service] = h
+	}
+	return h
+}
+
+// SetHedgePolicy 设置服务的对冲阈值分位数和对冲预算的比例
+func (c *Client) SetHedgePolicy(service string, percentile, ratio float64) {
+	c.hedgerFor(service).setPolicy(percentile, ratio)
+}
+
+// HedgeStats 服务的对冲统计
+func (c *Client) HedgeStats(service string) HedgeStats {
+	return c.hedgerFor(service).snapshot()
+}
+
+type hedgeResult struct {
+	node    *Node
+	err     error
+	latency time.Duration
+	hedge   bool
+}
+
+// Hedge 执行一次请求，超过阈值还没有返回的时候往另外一个节点发一个对冲请求
+// 返回第一个成功的结果，另外一个请求会被取消。每个请求的结果都会更新节点状态，
+// 自适应限流只按照最终的结果记一次，和 GetNodeFor 的一次请求对应。
+// 第一个请求在阈值之前就失败了不会对冲，重试交给调用者
+func (c *Client) Hedge(ctx context.Context, service string, attempt Attempt) error {
+	node, err := c.GetNodeFor(service)
+	if err != nil {
+		return err
+	}
+	h := c.hedgerFor(service)
+	threshold := h.begin(c.clock.Now())
+
+	ctx, cancel := context.WithCancel(ctx)
+	// 返回的时候取消还没有结束的请求
+	defer cancel()
+	// 有缓冲，输掉的请求结束的时候不会阻塞
+	results := make(chan hedgeResult, 2)
+	// 延迟从请求开始算，对冲请求的延迟也包含等待阈值的时间，这才是调用者看到的延迟
+	start := c.clock.Now()
+	run := func(node *Node, hedge bool) {
+		err := attempt(ctx, node)
+		results <- hedgeResult{node: node, err: err, latency: c.clock.Since(start), hedge: hedge}
+	}
+	go run(node, false)
+	inflight := 1
+
+	var timeout <-chan time.Time
+	if threshold > 0 {
+		timer := c.clock.NewTimer(threshold)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+	for {
+		select {
+		case <-timeout:
+			timeout = nil
+			other := c.otherNode(node)
+			if other == nil || !h.acquire(c.clock.Now()) {
+				continue
+			}
+			go run(other, true)
+			inflight++
+		case res := <-results:
+			inflight--
+			// 被取消的请求不是节点的问题
+			if !errors.Is(res.err, context.Canceled) {
+				c.UpdateNodeStatus(res.node.URL, res.err)
+			}
+			if res.err == nil {
+				h.observe(res.latency, res.hedge)
+				c.throttleFor(service).accept()
+				return nil
+			}
+			// 都失败了返回最后一个错误，还没到阈值就失败了也不再对冲
+			if inflight == 0 {
+				if !errors.Is(res.err, ErrThrottling) {
+					c.throttleFor(service).accept()
+				}
+				return res.err
+			}
+		case <-ctx.Done():
+			// 调用者取消的请求不是服务端拒绝的，也要记一次 accept，不然会被当成服务端在限流
+			c.throttleFor(service).accept()
+			return ctx.Err()
+		}
+	}
+}
+
+// otherNode 换一个节点，选不到就返回 nil
+// 不把 node 从候选里面去掉，免得负载均衡器因为节点数量变化重置状态
+func (c *Client) otherNode(node *Node) *Node {
+	c.mu.RLock()
+	n := len(c.healthyNodes) + len(c.probationNodes)
+	c.mu.RUnlock()
+	for i := 0; i < n; i++ {
+		other, err := c.GetNode()
+		if err != nil {
+			return nil
+		}
+		if other.URL != node.URL {
+			return other
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package v4
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"interview-cases/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowFirst 第一个请求一直不返回，直到被取消或者 release，后面的请求立刻成功
+// 第一个请求开始执行的时候关闭 started
+func slowFirst(started chan<- struct{}, release <-chan struct{}) Attempt {
+	var first atomic.Pointer[Node]
+	return func(ctx context.Context, node *Node) error {
+		if first.CompareAndSwap(nil, node) {
+			close(started)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+func TestClient_Hedge(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	client, err := NewClientWithClock(1, 10, 5, &WeightedRoundRobinLoadBalancer{}, time.Second, clk)
+	require.NoError(t, err)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		client.AddNode(fmt.Sprintf("http://node%d.com", i))
+	}
+	// 对冲的请求不超过请求数的 5%
+	client.SetHedgePolicy("order", 0.95, 0.05)
+	ctx := context.Background()
+
+	// 样本不够的时候不对冲
+	assert.Equal(t, time.Duration(0), client.HedgeStats("order").Threshold)
+	h := client.hedgerFor("order")
+	for i := 1; i <= 20; i++ {
+		h.observe(time.Duration(i)*time.Millisecond, false)
+	}
+	assert.Equal(t, 20*time.Millisecond, client.HedgeStats("order").Threshold)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, client.Hedge(ctx, "order", func(ctx context.Context, node *Node) error {
+			return nil
+		}))
+	}
+	// 20 个 0 加上 1 到 20ms，p95 是 19ms
+	threshold := client.HedgeStats("order").Threshold
+	assert.Equal(t, 19*time.Millisecond, threshold)
+
+	// 第一个请求超过阈值，对冲的请求先返回，第一个请求被取消
+	var canceled atomic.Bool
+	done := make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		attempt := slowFirst(started, nil)
+		done <- client.Hedge(ctx, "order", func(ctx context.Context, node *Node) error {
+			err := attempt(ctx, node)
+			if err != nil {
+				canceled.Store(true)
+			}
+			return err
+		})
+	}()
+	// 后台恢复的 ticker 和对冲的 timer
+	clk.BlockUntil(2)
+	<-started
+	clk.Advance(threshold)
+	require.NoError(t, <-done)
+	assert.Eventually(t, canceled.Load, time.Second, time.Millisecond)
+	stats := client.HedgeStats("order")
+	assert.Equal(t, int64(21), stats.Requests)
+	assert.Equal(t, int64(1), stats.Hedged)
+	assert.Equal(t, int64(1), stats.HedgeWins)
+	// 对冲请求的延迟从请求开始算，包含了等待阈值的时间
+	assert.Equal(t, threshold, h.latencies[len(h.latencies)-1])
+	// 被取消的请求不影响节点状态
+	assert.Len(t, client.healthyNodes, 3)
+
+	// 22 个请求的 5% 不够再对冲一次，只能等第一个请求返回
+	release := make(chan struct{})
+	started = make(chan struct{})
+	go func() {
+		done <- client.Hedge(ctx, "order", slowFirst(started, release))
+	}()
+	clk.BlockUntil(2)
+	<-started
+	clk.Advance(threshold)
+	assert.Eventually(t, func() bool {
+		return client.HedgeStats("order").BudgetRejected == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+	require.NoError(t, <-done)
+	stats = client.HedgeStats("order")
+	assert.Equal(t, int64(1), stats.Hedged)
+	assert.Equal(t, int64(1), stats.HedgeWins)
+
+	// 第一个请求在阈值之前失败了，不对冲
+	err = client.Hedge(ctx, "order", func(ctx context.Context, node *Node) error {
+		return ErrTimeout
+	})
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Len(t, client.probationNodes, 1)
+	assert.Equal(t, int64(1), client.HedgeStats("order").Hedged)
+
+	// 调用者取消的请求也算 accept，不会让客户端以为服务端在限流
+	before := client.ThrottleStats("order")
+	cancelCtx, cancel := context.WithCancel(ctx)
+	started = make(chan struct{})
+	go func() {
+		done <- client.Hedge(cancelCtx, "order", slowFirst(started, nil))
+	}()
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	after := client.ThrottleStats("order")
+	assert.Equal(t, before.Requests+1, after.Requests)
+	assert.Equal(t, before.Accepts+1, after.Accepts)
+}
+
+// 测试场景
+// 三个节点里面有一个变慢了，每个请求都要 2s，对冲之后不会被这个节点拖慢
+func TestClient_Hedge_SlowNode(t *testing.T) {
+	var slow atomic.Bool
+	servers := make([]*httptest.Server, 3)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if i == 0 && slow.Load() {
+				// 模拟超时，请求被取消的时候提前结束
+				select {
+				case <-time.After(2 * time.Second):
+					w.WriteHeader(http.StatusRequestTimeout)
+				case <-r.Context().Done():
+				}
+				return
+			}
+			time.Sleep(time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer servers[i].Close()
+	}
+	client, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, 5*time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+	for _, server := range servers {
+		client.AddNode(server.URL)
+	}
+	// 有三分之一的请求会落到慢节点上，预算要够
+	client.SetHedgePolicy("order", 0.95, 0.5)
+	get := func(ctx context.Context, node *Node) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return ErrTimeout
+		}
+		return nil
+	}
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, client.Hedge(context.Background(), "order", get))
+	}
+
+	slow.Store(true)
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		require.NoError(t, client.Hedge(context.Background(), "order", get))
+	}
+	// 不对冲的话至少要 20s
+	assert.Less(t, time.Since(start), 2*time.Second)
+	stats := client.HedgeStats("order")
+	// 正常的节点偶尔也会超过 p95，所以只能确定慢节点上的请求都被对冲了
+	assert.GreaterOrEqual(t, stats.HedgeWins, int64(10))
+	assert.Equal(t, int64(0), stats.BudgetRejected)
+}
+
+// 阈值缓存起来，每新增 hedgeRecomputeEvery 个样本才重新计算
+func TestHedger_Threshold(t *testing.T) {
+	h := newHedger(0.5, 0.1, time.Second, 10)
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < minHedgeSamples-1; i++ {
+		h.observe(time.Millisecond, false)
+	}
+	assert.Equal(t, time.Duration(0), h.begin(now))
+	// 样本够了马上计算
+	h.observe(time.Millisecond, false)
+	assert.Equal(t, time.Millisecond, h.begin(now))
+
+	// 还没攒够一批，阈值不变
+	for i := 0; i < hedgeRecomputeEvery-1; i++ {
+		h.observe(time.Second, false)
+	}
+	assert.Equal(t, time.Millisecond, h.begin(now))
+	h.observe(time.Second, false)
+	assert.Equal(t, time.Second, h.begin(now))
+
+	// 修改分位数之后马上重新计算
+	h.setPolicy(0.1, 0.1)
+	assert.Equal(t, time.Millisecond, h.begin(now))
+}
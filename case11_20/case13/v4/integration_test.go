@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/suite"
+
+	"interview-cases/clock"
 )
 
 // TestIntegrationSuite 运行集成测试套件
@@ -21,6 +23,7 @@ type IntegrationTestSuite struct {
 	suite.Suite
 	client *Client
 	nodes  []*httptest.Server
+	clk    *clock.Fake
 }
 
 // SetupSuite 在所有测试开始前运行，用于设置测试环境
@@ -38,7 +41,8 @@ func (s *IntegrationTestSuite) SetupSuite() {
 func (s *IntegrationTestSuite) SetupTest() {
 	// 创建客户端
 	lb := &WeightedRoundRobinLoadBalancer{} // 假设我们有一个轮询负载均衡器
-	client, err := NewClient(1, 100, 10, lb, 5*time.Second)
+	s.clk = clock.NewFake(time.Unix(1700000000, 0))
+	client, err := NewClientWithClock(1, 100, 10, lb, 5*time.Second, s.clk)
 	s.NoError(err)
 	s.client = client
 
@@ -171,7 +175,7 @@ func (s *IntegrationTestSuite) TestNodeStateTransition() {
 	s.Run("UnhealthyToProbation", func() {
 		node, _ := s.client.GetNode()
 		s.client.UpdateNodeStatus(node.URL, ErrNetworkFailure) // 先变为不健康
-		time.Sleep(s.client.recoveryInterval + time.Second)    // 等待恢复间隔
+		s.clk.Advance(s.client.recoveryInterval + time.Second) // 等待恢复间隔
 		s.client.tryRecoverNodes()                             // 手动触发恢复尝试
 		s.Assert().Equal(StatusProbation, s.client.findNode(node.URL).Status)
 	})
@@ -213,7 +217,7 @@ func (s *IntegrationTestSuite) TestNodeRecoveryMechanism() {
 	s.Run("AutomaticRecoveryAttempt", func() {
 		node, _ := s.client.GetNode()
 		s.client.UpdateNodeStatus(node.URL, ErrNetworkFailure)
-		time.Sleep(s.client.recoveryInterval + time.Second)
+		s.clk.Advance(s.client.recoveryInterval + time.Second)
 		s.client.tryRecoverNodes()
 		s.Assert().Equal(StatusProbation, s.client.findNode(node.URL).Status)
 	})
@@ -221,7 +225,7 @@ func (s *IntegrationTestSuite) TestNodeRecoveryMechanism() {
 	s.Run("WeightManagementDuringRecovery", func() {
 		node, _ := s.client.GetNode()
 		s.client.UpdateNodeStatus(node.URL, ErrNetworkFailure)
-		time.Sleep(s.client.recoveryInterval + time.Second)
+		s.clk.Advance(s.client.recoveryInterval + time.Second)
 		s.client.tryRecoverNodes()
 		s.Assert().Equal(s.client.minWeight, s.client.findNode(node.URL).Weight)
 	})
@@ -310,20 +314,21 @@ func (s *IntegrationTestSuite) TestConfigurationValidity() {
 	})
 
 	s.Run("RecoveryIntervalSetting", func() {
-		client, _ := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, 1*time.Second)
+		clk := clock.NewFake(time.Unix(1700000000, 0))
+		client, _ := NewClientWithClock(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, 1*time.Second, clk)
 		url := "http://example.com"
 		client.AddNode(url)
 		node, _ := client.GetNode()
 		client.UpdateNodeStatus(node.URL, ErrNetworkFailure)
-		time.Sleep(2 * time.Second)
+		clk.Advance(2 * time.Second)
 		client.tryRecoverNodes()
 		s.Assert().Equal(StatusProbation, client.findNode(node.URL).Status)
 
-		client, _ = NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, 10*time.Second)
+		client, _ = NewClientWithClock(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, 10*time.Second, clk)
 		client.AddNode(url)
 		node, _ = client.GetNode()
 		client.UpdateNodeStatus(node.URL, ErrNetworkFailure)
-		time.Sleep(2 * time.Second)
+		clk.Advance(2 * time.Second)
 		client.tryRecoverNodes()
 		s.Assert().Equal(StatusUnhealthy, client.findNode(node.URL).Status)
 	})
@@ -0,0 +1,173 @@
+package v4
+
+import (
+	"errors"
+	"interview-cases/clock"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrLocalThrottled 客户端自适应限流，请求没有发出去
+var ErrLocalThrottled = errors.New("客户端自适应限流")
+
+const (
+	// 默认的 K，允许请求数是服务端接受的请求数的 2 倍
+	defaultThrottleK = 2.0
+	// 统计最近两分钟的请求
+	defaultThrottleWindow  = 2 * time.Minute
+	defaultThrottleBuckets = 12
+)
+
+// ThrottleStats 自适应限流的统计
+type ThrottleStats struct {
+	// 窗口内的请求数，包括被本地拒绝的请求
+	Requests int64
+	// 窗口内服务端接受的请求数
+	Accepts int64
+	// 累计被本地拒绝的请求数
+	LocalRejected int64
+	// 当前本地拒绝的概率
+	RejectProbability float64
+}
+
+// throttle Google SRE 的客户端自适应限流
+// 服务端限流的时候，客户端按照 max(0, (requests - K*accepts) / (requests + 1)) 的概率在本地直接拒绝，
+// 免得服务端花资源去拒绝注定会失败的请求
+type throttle struct {
+	mu       sync.Mutex
+	k        float64
+	buckets  []throttleBucket
+	width    time.Duration
+	clock    clock.Clock
+	rejected int64
+	random   func() float64
+}
+
+type throttleBucket struct {
+	start    time.Time
+	requests int64
+	accepts  int64
+}
+
+func newThrottle(k float64, window time.Duration, buckets int, clk clock.Clock) *throttle {
+	return &throttle{
+		k:       k,
+		buckets: make([]throttleBucket, buckets),
+		width:   window / time.Duration(buckets),
+		clock:   clk,
+		random:  rand.Float64,
+	}
+}
+
+// current 返回当前时间对应的桶，过期的桶会被清空，调用者要持有锁
+func (t *throttle) current() *throttleBucket {
+	now := t.clock.Now()
+	start := now.Truncate(t.width)
+	b := &t.buckets[int(start.UnixNano()/int64(t.width))%len(t.buckets)]
+	if !b.start.Equal(start) {
+		*b = throttleBucket{start: start}
+	}
+	return b
+}
+
+// sum 调用者要持有锁
+func (t *throttle) sum() (requests, accepts int64) {
+	oldest := t.clock.Now().Truncate(t.width).Add(-t.width * time.Duration(len(t.buckets)-1))
+	for _, b := range t.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		requests += b.requests
+		accepts += b.accepts
+	}
+	return
+}
+
+func (t *throttle) probability(requests, accepts int64) float64 {
+	return math.Max(0, (float64(requests)-t.k*float64(accepts))/float64(requests+1))
+}
+
+// allow 不管是不是被本地拒绝，都算一次请求
+func (t *throttle) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests, accepts := t.sum()
+	p := t.probability(requests, accepts)
+	t.current().requests++
+	if p > 0 && t.random() < p {
+		t.rejected++
+		return false
+	}
+	return true
+}
+
+func (t *throttle) accept() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current().accepts++
+}
+
+func (t *throttle) setK(k float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.k = k
+}
+
+func (t *throttle) stats() ThrottleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests, accepts := t.sum()
+	return ThrottleStats{
+		Requests:          requests,
+		Accepts:           accepts,
+		LocalRejected:     t.rejected,
+		RejectProbability: t.probability(requests, accepts),
+	}
+}
+
+// throttleFor 获取服务的自适应限流器，不存在就用默认的 K 创建一个
+func (c *Client) throttleFor(service string) *throttle {
+	c.throttleMu.Lock()
+	defer c.throttleMu.Unlock()
+	t, ok := c.throttles[service]
+	if !ok {
+		t = newThrottle(defaultThrottleK, defaultThrottleWindow, defaultThrottleBuckets, c.clock)
+		c.throttles[service] = t
+	}
+	return t
+}
+
+// SetThrottleK 设置服务的 K，K 越小越早开始在本地拒绝，一般在 1.1 到 2 之间
+func (c *Client) SetThrottleK(service string, k float64) {
+	c.throttleFor(service).setK(k)
+}
+
+// GetNodeFor 先选节点，再经过服务的自适应限流
+// 被本地拒绝的时候返回 ErrLocalThrottled。没有可用节点的时候请求根本发不出去，
+// 服务端也不会有任何反馈，所以不计入限流的统计，不然拒绝的概率会无缘无故地上升
+func (c *Client) GetNodeFor(service string) (*Node, error) {
+	node, err := c.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if !c.throttleFor(service).allow() {
+		return nil, ErrLocalThrottled
+	}
+	return node, nil
+}
+
+// Report 更新节点状态，并且记录服务端有没有接受这个请求
+// 只有服务端明确限流才算没有接受，网络故障之类的错误交给节点的状态管理
+func (c *Client) Report(service, url string, err error) {
+	c.UpdateNodeStatus(url, err)
+	if !errors.Is(err, ErrThrottling) {
+		c.throttleFor(service).accept()
+	}
+}
+
+// ThrottleStats 服务的自适应限流统计
+func (c *Client) ThrottleStats(service string) ThrottleStats {
+	return c.throttleFor(service).stats()
+}
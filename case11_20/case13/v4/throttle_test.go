@@ -0,0 +1,95 @@
+package v4
+
+import (
+	"testing"
+	"time"
+
+	"interview-cases/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Throttle(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	client, err := NewClientWithClock(1, 10, 5, &WeightedRoundRobinLoadBalancer{}, time.Second, clk)
+	require.NoError(t, err)
+	defer client.Close()
+	client.AddNode("http://example.com")
+	client.SetThrottleK("order", 1.5)
+
+	// 正常的时候不会在本地拒绝
+	for i := 0; i < 100; i++ {
+		node, err := client.GetNodeFor("order")
+		require.NoError(t, err)
+		client.Report("order", node.URL, nil)
+	}
+	assert.Equal(t, ThrottleStats{Requests: 100, Accepts: 100}, client.ThrottleStats("order"))
+
+	// 服务端开始限流，请求数超过 1.5 倍的接受数之后开始在本地拒绝
+	for i := 0; i < 1000; i++ {
+		node, err := client.GetNodeFor("order")
+		if err != nil {
+			assert.ErrorIs(t, err, ErrLocalThrottled)
+			continue
+		}
+		client.Report("order", node.URL, ErrThrottling)
+	}
+	stats := client.ThrottleStats("order")
+	assert.Equal(t, int64(1100), stats.Requests)
+	assert.Equal(t, int64(100), stats.Accepts)
+	// 稳定之后拒绝的概率接近 (1100 - 150) / 1101
+	assert.InDelta(t, 950.0/1101, stats.RejectProbability, 0.001)
+	assert.Greater(t, stats.LocalRejected, int64(500))
+
+	// 其它服务使用默认的 K，互不影响
+	assert.Equal(t, ThrottleStats{}, client.ThrottleStats("user"))
+
+	// 过了统计窗口，重新开始统计
+	clk.Advance(defaultThrottleWindow)
+	stats = client.ThrottleStats("order")
+	assert.Equal(t, int64(0), stats.Requests)
+	assert.Equal(t, float64(0), stats.RejectProbability)
+	_, err = client.GetNodeFor("order")
+	assert.NoError(t, err)
+}
+
+func TestThrottle_Probability(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	th := newThrottle(2, time.Minute, 6, clk)
+	// random 固定返回 0.5，拒绝的概率超过 0.5 的时候拒绝
+	th.random = func() float64 { return 0.5 }
+	th.accept()
+	th.accept()
+	for i := 0; i < 10; i++ {
+		require.True(t, th.allow())
+	}
+	// (10 - 2*2) / 11 > 0.5
+	assert.False(t, th.allow())
+	clk.Advance(50 * time.Second)
+	for i := 0; i < 4; i++ {
+		th.accept()
+	}
+	assert.Equal(t, ThrottleStats{Requests: 11, Accepts: 6, LocalRejected: 1}, th.stats())
+	// 最早的桶滑出了窗口
+	clk.Advance(10 * time.Second)
+	assert.Equal(t, ThrottleStats{Accepts: 4, LocalRejected: 1}, th.stats())
+}
+
+// 没有可用节点的时候请求没有发出去，不计入限流的统计
+func TestClient_GetNodeFor_NoNodes(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	client, err := NewClientWithClock(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute, clk)
+	require.NoError(t, err)
+	defer client.Close()
+	for i := 0; i < 100; i++ {
+		_, err = client.GetNodeFor("order")
+		assert.Equal(t, ErrNoAvailableNodes, err)
+	}
+	assert.Equal(t, ThrottleStats{}, client.ThrottleStats("order"))
+
+	// 节点恢复之后不会因为之前的失败被本地拒绝
+	client.AddNode("http://localhost:8080")
+	_, err = client.GetNodeFor("order")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,83 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"interview-cases/case11_20/case15/pb"
+)
+
+// Client 业务方使用的延迟消息 SDK
+// 和 Producer 不同，它通过 gRPC 同步写入延迟平台，
+// 返回成功就代表消息已经落库，不会因为 delay_topic 堆积或者转储失败而丢消息
+type Client struct {
+	client pb.DelayServiceClient
+}
+
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{client: pb.NewDelayServiceClient(cc)}
+}
+
+// ScheduleResult 单条延迟消息的写入结果
+type ScheduleResult struct {
+	// 延迟消息的 ID
+	Id int64
+	// 为 true 说明 Key 已经存在，Id 是已有的那条消息的 ID
+	Duplicated bool
+	// 批量写入的时候，单条消息失败的原因
+	Err error
+}
+
+// Schedule 提交一条延迟消息
+func (c *Client) Schedule(ctx context.Context, msg DelayMsg) (ScheduleResult, error) {
+	resp, err := c.client.Schedule(ctx, &pb.ScheduleRequest{Msg: toPB(msg)})
+	if err != nil {
+		return ScheduleResult{}, err
+	}
+	return ScheduleResult{Id: resp.GetId(), Duplicated: resp.GetDuplicated()}, nil
+}
+
+// ScheduleBatch 批量提交延迟消息，返回结果和 msgs 一一对应
+// 返回的 error 只代表调用本身失败，单条消息的失败放在 ScheduleResult.Err 里面
+func (c *Client) ScheduleBatch(ctx context.Context, msgs []DelayMsg) ([]ScheduleResult, error) {
+	pbMsgs := make([]*pb.DelayMsg, 0, len(msgs))
+	for _, msg := range msgs {
+		pbMsgs = append(pbMsgs, toPB(msg))
+	}
+	resp, err := c.client.ScheduleBatch(ctx, &pb.ScheduleBatchRequest{Msgs: pbMsgs})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ScheduleResult, 0, len(resp.GetResults()))
+	for _, r := range resp.GetResults() {
+		res := ScheduleResult{Id: r.GetId(), Duplicated: r.GetDuplicated()}
+		if r.GetErr() != "" {
+			res.Err = errors.New(r.GetErr())
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Produce 和 Producer.Produce 的签名保持一致，方便业务方直接替换
+func (c *Client) Produce(ctx context.Context,
+	msg []byte,
+	// 时间戳，毫秒数
+	deadline int64,
+	bizTopic string) error {
+	_, err := c.Schedule(ctx, DelayMsg{
+		Value:    msg,
+		Topic:    bizTopic,
+		Deadline: deadline,
+	})
+	return err
+}
+
+func toPB(msg DelayMsg) *pb.DelayMsg {
+	return &pb.DelayMsg{
+		Value:    msg.Value,
+		Key:      msg.Key,
+		Topic:    msg.Topic,
+		Deadline: msg.Deadline,
+	}
+}
@@ -7,6 +7,8 @@ import (
 )
 
 // Producer 业务方使用的 producer
+//
+// Deprecated: 发到 delay_topic 之后业务方拿不到任何落库的确认，使用 Client 代替
 type Producer struct {
 	producer *kafka.Producer
 }
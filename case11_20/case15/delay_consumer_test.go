@@ -12,6 +12,7 @@ import (
 	"interview-cases/case11_20/case15/biz/producer"
 	"interview-cases/case11_20/case15/delay_platform"
 	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store/mysql"
 	"interview-cases/test"
 	"log"
 	"testing"
@@ -60,7 +61,8 @@ func (s *TestSuite) initConsumerAndProducer() {
 	require.NoError(s.T(), err)
 	s.producer = producer.NewProducer(kafkaProducer)
 	require.NoError(s.T(), err)
-	msgDAO := dao.NewDelayMsgDAO(s.db)
+	msgDAO, err := dao.NewDelayMsgDAO(s.db, 1)
+	require.NoError(s.T(), err)
 
 	config := &kafka.ConfigMap{
 		"bootstrap.servers":  s.addr,
@@ -98,7 +100,7 @@ func (s *TestSuite) initSenders(kafkaProducer *kafka.Producer, msgDAO *dao.Delay
 	// 在实践中，这个地方应该做成抢占式的，
 	// 也就是一张表一个分布式锁，谁拿到就谁来发送
 	for _, table := range tables {
-		sender := delay_platform.NewDelayMsgSender(topicMap, msgDAO, table)
+		sender := delay_platform.NewDelayMsgSender(topicMap, mysql.NewStore(msgDAO, table))
 		// 启动延迟消息发送者
 		go sender.SendMsg()
 	}
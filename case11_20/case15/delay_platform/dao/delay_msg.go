@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
-	"sync/atomic"
+	"gorm.io/gorm/clause"
+	"hash/crc32"
+	"strconv"
 	"time"
 )
 
@@ -25,20 +29,32 @@ type DelayMsg struct {
 type DelayMsgDAO struct {
 	db     *gorm.DB
 	tables []string
-	index  atomic.Int64
+	// 分库分表之后自增主键不再全局唯一，所以用雪花算法生成 ID
+	node *snowflake.Node
 }
 
 // NewDelayMsgDAO 如果你有多个集群，那么这里传入多个 db 来轮询
-func NewDelayMsgDAO(db *gorm.DB) *DelayMsgDAO {
+// nodeID 是雪花算法的节点 ID，每个平台实例必须不一样，不然生成的 ID 会冲突
+func NewDelayMsgDAO(db *gorm.DB, nodeID int64) (*DelayMsgDAO, error) {
+	node, err := snowflake.NewNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
 	return &DelayMsgDAO{
-		db: db,
+		db:   db,
+		node: node,
 		tables: []string{
 			"delay_msg_db_0.delay_msg_tab_0",
 			"delay_msg_db_0.delay_msg_tab_1",
 			"delay_msg_db_1.delay_msg_tab_0",
 			"delay_msg_db_1.delay_msg_tab_1",
 		},
-	}
+	}, nil
+}
+
+// Tables 所有的分表
+func (d *DelayMsgDAO) Tables() []string {
+	return append([]string(nil), d.tables...)
 }
 
 func (d *DelayMsgDAO) getTables() []string {
@@ -52,16 +68,80 @@ func (d *DelayMsgDAO) getTables() []string {
 }
 
 // Insert 轮询加入
+// ID 也用雪花算法生成，不能依赖每张表自己的自增主键，不然不同表里面的 ID 会重复
 func (d *DelayMsgDAO) Insert(ctx context.Context, msg DelayMsg) error {
 	now := time.Now().UnixMilli()
+	msg.Id = d.node.Generate().Int64()
 	msg.Ctime = now
 	msg.Utime = now
 	// 等待被转发
 	msg.Status = 0
-	// 轮询
-	idx := d.index.Add(1) % int64(len(d.tables))
 	// 要指定表
-	return d.db.WithContext(ctx).Table(d.tables[idx]).Create(&msg).Error
+	return d.db.WithContext(ctx).Table(d.TableOf(msg)).Create(&msg).Error
+}
+
+// InsertIdempotent 以 Key 作为幂等键插入，返回最终落库的消息
+// 如果 Key 已经存在，那么返回的是已有的那条消息，并且第二个返回值为 true
+func (d *DelayMsgDAO) InsertIdempotent(ctx context.Context, msg DelayMsg) (DelayMsg, bool, error) {
+	now := time.Now().UnixMilli()
+	msg.Id = d.node.Generate().Int64()
+	msg.Ctime = now
+	msg.Utime = now
+	msg.Status = 0
+	tab := d.TableOf(msg)
+	res := d.db.WithContext(ctx).Table(tab).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&msg)
+	if res.Error != nil {
+		return DelayMsg{}, false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return msg, false, nil
+	}
+	// 唯一索引冲突，说明是重复提交，把已有的那条找出来
+	var existing DelayMsg
+	err := d.db.WithContext(ctx).Table(tab).
+		Where("`key` = ?", msg.Key.String).
+		First(&existing).Error
+	return existing, err == nil, err
+}
+
+// BatchInsert 按照目标表分组之后并发地批量插入，重复的消息会被忽略
+// 没有 ID 的消息会在这里分配 ID 并且写回 msgs，所以调用者重试的时候传入同一个 msgs，
+// 已经插入成功的那部分会因为主键冲突被忽略，不会插入两次
+func (d *DelayMsgDAO) BatchInsert(ctx context.Context, msgs []DelayMsg) error {
+	now := time.Now().UnixMilli()
+	groups := make(map[string][]DelayMsg, len(d.tables))
+	for i := range msgs {
+		if msgs[i].Id == 0 {
+			msgs[i].Id = d.node.Generate().Int64()
+			msgs[i].Ctime = now
+			msgs[i].Utime = now
+		}
+		msgs[i].Status = 0
+		tab := d.TableOf(msgs[i])
+		groups[tab] = append(groups[tab], msgs[i])
+	}
+	var eg errgroup.Group
+	for tab, ms := range groups {
+		eg.Go(func() error {
+			return d.db.WithContext(ctx).Table(tab).
+				Clauses(clause.OnConflict{DoNothing: true}).
+				CreateInBatches(ms, 500).Error
+		})
+	}
+	return eg.Wait()
+}
+
+// TableOf 消息所在的表
+// 唯一索引只在单表内生效，所以有 Key 的消息按照 Key 哈希，保证同一个 Key 总是落在同一张表
+// 没有 Key 的消息按照 ID 哈希，这样只要拿着消息，不管是重试插入还是更新都能找到同一张表
+func (d *DelayMsgDAO) TableOf(msg DelayMsg) string {
+	data := []byte(msg.Key.String)
+	if !msg.Key.Valid {
+		data = strconv.AppendInt(nil, msg.Id, 10)
+	}
+	return d.tables[crc32.ChecksumIEEE(data)%uint32(len(d.tables))]
 }
 
 func (d *DelayMsgDAO) Complete(ctx context.Context, tab string, ids ...int64) error {
@@ -72,6 +152,36 @@ func (d *DelayMsgDAO) Complete(ctx context.Context, tab string, ids ...int64) er
 	return err
 }
 
+// Claim 抢占 tab 里面到期的消息
+// 抢占的做法是把 deadline 往后推 visibility，同时用原本的 deadline 做乐观锁，
+// 这样多个发送者抢同一条消息的时候只有一个能成功，而抢到的发送者如果没有 Complete，
+// 那么过了 visibility 之后这条消息又会被找出来
+func (d *DelayMsgDAO) Claim(ctx context.Context, tab string, limit int, visibility time.Duration) ([]DelayMsg, error) {
+	ms, err := d.FindDelayMsg(ctx, tab, limit)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	claimed := make([]DelayMsg, 0, len(ms))
+	for _, msg := range ms {
+		res := d.db.WithContext(ctx).Table(tab).
+			Where("id = ? AND status = ? AND deadline = ?", msg.Id, 0, msg.Deadline).
+			Updates(map[string]any{
+				"deadline": now + visibility.Milliseconds(),
+				"utime":    now,
+			})
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		// 被别的发送者抢先了
+		if res.RowsAffected == 0 {
+			continue
+		}
+		claimed = append(claimed, msg)
+	}
+	return claimed, nil
+}
+
 // FindDelayMsg 广播每次每个库最多拿10个
 func (d *DelayMsgDAO) FindDelayMsg(ctx context.Context, tab string, limit int) ([]DelayMsg, error) {
 	// 找到到时间的延迟消息,每个库拿10个
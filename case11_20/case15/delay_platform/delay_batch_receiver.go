@@ -0,0 +1,183 @@
+package delay_platform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"log/slog"
+	"time"
+)
+
+// BatchInserter 批量写入延迟消息，*dao.DelayMsgDAO 实现了这个接口
+type BatchInserter interface {
+	BatchInsert(ctx context.Context, msgs []dao.DelayMsg) error
+}
+
+// Backoff 第 attempt 次（从 1 开始）失败之后等多久再重试，返回 false 就不再重试了
+type Backoff func(attempt int) (time.Duration, bool)
+
+// ExponentialBackoff 第一次等 initial，之后每次翻倍，最多等 maxInterval，一共重试 maxRetries 次
+func ExponentialBackoff(initial, maxInterval time.Duration, maxRetries int) Backoff {
+	return func(attempt int) (time.Duration, bool) {
+		if attempt > maxRetries {
+			return 0, false
+		}
+		d := initial
+		for i := 1; i < attempt && d < maxInterval; i++ {
+			d *= 2
+		}
+		return min(d, maxInterval), true
+	}
+}
+
+// BatchDelayMsgReceiver 批量接收延迟消息
+// DelayMsgReceiver 每条消息都要插入一次、提交一次，吞吐量受限于数据库的往返时间。
+// 这里攒够 batchSize 条或者等够 interval 之后，按照目标表分组批量插入，
+// 所有的表都写成功之后，再提交每个分区最大的偏移量
+type BatchDelayMsgReceiver struct {
+	consumer  MsgConsumer
+	dao       BatchInserter
+	batchSize int
+	interval  time.Duration
+	backoff   Backoff
+}
+
+// NewBatchDelayMsgReceiver 写入失败的时候从 100ms 开始指数退避，最多等 5s，重试 10 次
+func NewBatchDelayMsgReceiver(consumer MsgConsumer, dao BatchInserter,
+	batchSize int, interval time.Duration) *BatchDelayMsgReceiver {
+	return NewBatchDelayMsgReceiverWithBackoff(consumer, dao, batchSize, interval,
+		ExponentialBackoff(100*time.Millisecond, 5*time.Second, 10))
+}
+
+// NewBatchDelayMsgReceiverWithBackoff 写入失败之后按照 backoff 重试
+func NewBatchDelayMsgReceiverWithBackoff(consumer MsgConsumer, dao BatchInserter,
+	batchSize int, interval time.Duration, backoff Backoff) *BatchDelayMsgReceiver {
+	return &BatchDelayMsgReceiver{
+		consumer:  consumer,
+		dao:       dao,
+		batchSize: batchSize,
+		interval:  interval,
+		backoff:   backoff,
+	}
+}
+
+// ReceiveMsg 接收消息进行转发，ctx 结束或者一批消息重试之后还是写不进去就返回
+// 返回之前这一批消息的偏移量没有提交，调用者应该关闭 consumer 重新消费，从上一次提交的偏移量开始
+func (receiver *BatchDelayMsgReceiver) ReceiveMsg(ctx context.Context) error {
+	for ctx.Err() == nil {
+		if _, err := receiver.receiveBatch(ctx); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// receiveBatch 处理一批消息，返回这一批的消息数量
+// 写入失败的时候不会提交偏移量，返回 error
+func (receiver *BatchDelayMsgReceiver) receiveBatch(ctx context.Context) (int, error) {
+	msgs := receiver.readBatch()
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	delayMsgs := make([]dao.DelayMsg, 0, len(msgs))
+	for _, msg := range msgs {
+		delayMsg, err := decodeDelayMsg(msg)
+		if err != nil {
+			// 格式不对的消息重试也没用，记录一下就跳过
+			slog.Error("解析延迟消息失败", slog.Any("err", err), slog.Any("offset", msg.TopicPartition))
+			continue
+		}
+		delayMsgs = append(delayMsgs, delayMsg)
+	}
+	// 全部写成功之后才能提交偏移量，不然这一批消息就丢了
+	if err := receiver.insert(ctx, delayMsgs); err != nil {
+		return 0, err
+	}
+	_, err := receiver.consumer.CommitOffsets(receiver.offsets(msgs))
+	if err != nil {
+		// 提交失败的话这一批会被重复消费，有 Key 的消息会被去重
+		slog.Error("提交延迟消息偏移量失败", slog.Any("err", err))
+	}
+	slog.Info("成功转储一批延迟消息", slog.Int("cnt", len(delayMsgs)))
+	return len(msgs), nil
+}
+
+// insert 按照 backoff 重试，等待的时候 ctx 结束了就直接返回
+// BatchInsert 会把分配好的 ID 写回 msgs，所以重试不会插入重复的数据
+func (receiver *BatchDelayMsgReceiver) insert(ctx context.Context, msgs []dao.DelayMsg) error {
+	for attempt := 1; ; attempt++ {
+		insertCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		err := receiver.dao.BatchInsert(insertCtx, msgs)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		d, ok := receiver.backoff(attempt)
+		if !ok {
+			return fmt.Errorf("批量转储延迟消息失败，重试了 %d 次: %w", attempt-1, err)
+		}
+		slog.Error("批量转储延迟消息失败", slog.Any("err", err), slog.Int("attempt", attempt))
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(ctx.Err(), err)
+		}
+	}
+}
+
+// readBatch 攒一批消息，数量达到 batchSize 或者超过 interval 都会返回
+func (receiver *BatchDelayMsgReceiver) readBatch() []*kafka.Message {
+	msgs := make([]*kafka.Message, 0, receiver.batchSize)
+	deadline := time.Now().Add(receiver.interval)
+	for len(msgs) < receiver.batchSize {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		msg, err := receiver.consumer.ReadMessage(remaining)
+		if err != nil {
+			var kerr kafka.Error
+			if !errors.As(err, &kerr) || kerr.Code() != kafka.ErrTimedOut {
+				slog.Error("获取延迟消息失败", slog.Any("err", err))
+			}
+			// 取出来多少就处理多少
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// offsets 每个分区提交最大的偏移量
+// 提交的偏移量是下一条要消费的消息，所以要加一
+func (receiver *BatchDelayMsgReceiver) offsets(msgs []*kafka.Message) []kafka.TopicPartition {
+	type partition struct {
+		topic string
+		id    int32
+	}
+	maxOffsets := make(map[partition]kafka.Offset)
+	for _, msg := range msgs {
+		tp := msg.TopicPartition
+		p := partition{id: tp.Partition}
+		if tp.Topic != nil {
+			p.topic = *tp.Topic
+		}
+		if offset, ok := maxOffsets[p]; !ok || tp.Offset > offset {
+			maxOffsets[p] = tp.Offset
+		}
+	}
+	res := make([]kafka.TopicPartition, 0, len(maxOffsets))
+	for p, offset := range maxOffsets {
+		topic := p.topic
+		res = append(res, kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: p.id,
+			Offset:    offset + 1,
+		})
+	}
+	return res
+}
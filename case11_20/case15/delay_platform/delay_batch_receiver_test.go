@@ -0,0 +1,190 @@
+package delay_platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/test"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockConsumer 源源不断地返回延迟消息，记录提交的偏移量
+type mockConsumer struct {
+	mu        sync.Mutex
+	topic     string
+	offset    kafka.Offset
+	committed []kafka.TopicPartition
+}
+
+func (m *mockConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offset++
+	val, _ := json.Marshal(map[string]any{
+		"Value":    []byte("delayMsg"),
+		"Topic":    "biz_topic",
+		"Deadline": time.Now().Add(time.Hour).UnixMilli(),
+		"Key":      fmt.Sprintf("bench_%d_%d", time.Now().UnixNano(), m.offset),
+	})
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &m.topic, Partition: int32(m.offset % 2), Offset: m.offset},
+		Value:          val,
+	}, nil
+}
+
+func (m *mockConsumer) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	return m.CommitOffsets([]kafka.TopicPartition{msg.TopicPartition})
+}
+
+func (m *mockConsumer) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.committed = append(m.committed, offsets...)
+	return offsets, nil
+}
+
+func TestBatchDelayMsgReceiver_offsets(t *testing.T) {
+	topic := "delay_topic"
+	receiver := NewBatchDelayMsgReceiver(&mockConsumer{}, nil, 10, time.Second)
+	msgs := []*kafka.Message{
+		{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 5}},
+		{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: 3}},
+		{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 7}},
+		{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 6}},
+	}
+	offsets := receiver.offsets(msgs)
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	assert.Len(t, offsets, 2)
+	// 提交的是下一条要消费的偏移量
+	assert.Equal(t, kafka.Offset(8), offsets[0].Offset)
+	assert.Equal(t, kafka.Offset(4), offsets[1].Offset)
+}
+
+// failingInserter 前 failures 次写入失败
+type failingInserter struct {
+	failures int
+	calls    int
+}
+
+func (f *failingInserter) BatchInsert(ctx context.Context, msgs []dao.DelayMsg) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("mock db error")
+	}
+	return nil
+}
+
+func TestBatchDelayMsgReceiver_receiveBatch(t *testing.T) {
+	testCases := []struct {
+		name       string
+		failures   int
+		ctx        func() context.Context
+		wantErr    bool
+		wantCalls  int
+		wantCommit bool
+	}{
+		{
+			name:       "重试之后成功，提交偏移量",
+			failures:   2,
+			ctx:        context.Background,
+			wantCalls:  3,
+			wantCommit: true,
+		},
+		{
+			name:      "重试次数用完了，不提交偏移量",
+			failures:  100,
+			ctx:       context.Background,
+			wantErr:   true,
+			wantCalls: 4,
+		},
+		{
+			name:     "ctx 结束了，不再重试也不提交偏移量",
+			failures: 100,
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			consumer := &mockConsumer{topic: "delay_topic"}
+			inserter := &failingInserter{failures: tc.failures}
+			receiver := NewBatchDelayMsgReceiverWithBackoff(consumer, inserter, 10, time.Second,
+				ExponentialBackoff(time.Millisecond, time.Millisecond, 3))
+			n, err := receiver.receiveBatch(tc.ctx())
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCalls, inserter.calls)
+			if !tc.wantCommit {
+				assert.Empty(t, consumer.committed)
+				return
+			}
+			assert.Equal(t, 10, n)
+			assert.Len(t, consumer.committed, 2)
+		})
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second, 5)
+	var got []time.Duration
+	for attempt := 1; ; attempt++ {
+		d, ok := backoff(attempt)
+		if !ok {
+			break
+		}
+		got = append(got, d)
+	}
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second,
+	}, got)
+}
+
+// 下面两个基准测试对比逐条转储和批量转储的吞吐量，需要启动 MySQL
+// go test -run=^$ -bench=Receiver ./case11_20/case15/delay_platform/
+
+func BenchmarkDelayMsgReceiver(b *testing.B) {
+	consumer := &mockConsumer{topic: "delay_topic"}
+	msgDAO, err := dao.NewDelayMsgDAO(test.InitDB(), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	receiver := NewDelayMsgReceiver(consumer, msgDAO)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, _ := consumer.ReadMessage(-1)
+		if err := receiver.sendToDb(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBatchDelayMsgReceiver(b *testing.B) {
+	consumer := &mockConsumer{topic: "delay_topic"}
+	msgDAO, err := dao.NewDelayMsgDAO(test.InitDB(), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	receiver := NewBatchDelayMsgReceiver(consumer, msgDAO, 200, time.Second)
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; {
+		n, err := receiver.receiveBatch(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		cnt += n
+	}
+}
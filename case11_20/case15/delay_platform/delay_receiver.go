@@ -11,13 +11,20 @@ import (
 	"time"
 )
 
+// MsgConsumer 接收者用到的 kafka.Consumer 的方法
+type MsgConsumer interface {
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+}
+
 // DelayMsgReceiver 延迟消息接收者
 type DelayMsgReceiver struct {
-	consumer *kafka.Consumer
+	consumer MsgConsumer
 	dao      *dao.DelayMsgDAO
 }
 
-func NewDelayMsgReceiver(consumer *kafka.Consumer, dao *dao.DelayMsgDAO) *DelayMsgReceiver {
+func NewDelayMsgReceiver(consumer MsgConsumer, dao *dao.DelayMsgDAO) *DelayMsgReceiver {
 	return &DelayMsgReceiver{consumer: consumer, dao: dao}
 }
 
@@ -43,6 +50,24 @@ func (receiver *DelayMsgReceiver) ReceiveMsg() {
 }
 
 func (receiver *DelayMsgReceiver) sendToDb(msg *kafka.Message) error {
+	delayMsg, err := decodeDelayMsg(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = receiver.dao.Insert(ctx, delayMsg)
+	if err != nil {
+		return fmt.Errorf("转储消息失败 %w", err)
+	}
+	_, err = receiver.consumer.CommitMessage(msg)
+	if err != nil {
+		return fmt.Errorf("转储消息失败 %w", err)
+	}
+	return nil
+}
+
+func decodeDelayMsg(msg *kafka.Message) (dao.DelayMsg, error) {
 	type DelayMsg struct {
 		// 转发内容
 		Value []byte
@@ -55,12 +80,10 @@ func (receiver *DelayMsgReceiver) sendToDb(msg *kafka.Message) error {
 	var delayMsg DelayMsg
 	err := json.Unmarshal(msg.Value, &delayMsg)
 	if err != nil {
-		return fmt.Errorf("序列化延迟消息失败  %w", err)
+		return dao.DelayMsg{}, fmt.Errorf("序列化延迟消息失败  %w", err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 	now := time.Now().UnixMilli()
-	err = receiver.dao.Insert(ctx, dao.DelayMsg{
+	return dao.DelayMsg{
 		Topic:    delayMsg.Topic,
 		Value:    delayMsg.Value,
 		Deadline: delayMsg.Deadline,
@@ -68,13 +91,5 @@ func (receiver *DelayMsgReceiver) sendToDb(msg *kafka.Message) error {
 		Status:   DelayMsgStatusWaiting.ToUint8(),
 		Ctime:    now,
 		Utime:    now,
-	})
-	if err != nil {
-		return fmt.Errorf("转储消息失败 %w", err)
-	}
-	_, err = receiver.consumer.CommitMessage(msg)
-	if err != nil {
-		return fmt.Errorf("转储消息失败 %w", err)
-	}
-	return nil
+	}, nil
 }
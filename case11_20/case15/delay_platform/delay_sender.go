@@ -6,6 +6,7 @@ import (
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
 	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store"
 	"log/slog"
 	"sync"
 	"time"
@@ -14,16 +15,15 @@ import (
 // DelayMsgSender 延迟消息发送者
 type DelayMsgSender struct {
 	topicConn *syncx.Map[string, *kafka.Producer]
-	dao       *dao.DelayMsgDAO
-	// 轮询的目标表
-	dst string
+	store     store.DelayStore
+	// 抢占到的消息多久之内没有完成，就会被别的发送者再次拿到
+	visibility time.Duration
 }
 
 func NewDelayMsgSender(topicConn *syncx.Map[string, *kafka.Producer],
-	dao *dao.DelayMsgDAO,
-	dst string,
+	store store.DelayStore,
 ) *DelayMsgSender {
-	return &DelayMsgSender{topicConn: topicConn, dao: dao, dst: dst}
+	return &DelayMsgSender{topicConn: topicConn, store: store, visibility: 30 * time.Second}
 }
 
 func (sender *DelayMsgSender) SendMsg() {
@@ -47,7 +47,7 @@ func (sender *DelayMsgSender) oneLoop() int {
 func (sender *DelayMsgSender) sendMsgs(ctx context.Context) int {
 	// 获取需要发送的消息
 	// 10 个一批
-	msgs, err := sender.dao.FindDelayMsg(ctx, sender.dst, 10)
+	msgs, err := sender.store.Claim(ctx, 10, sender.visibility)
 	if err != nil {
 		slog.Error("获取延迟消息失败", slog.Any("err", err))
 	}
@@ -90,7 +90,7 @@ func (sender *DelayMsgSender) sendMsg(ctx context.Context, msg dao.DelayMsg) err
 	if err != nil {
 		return fmt.Errorf("消息 %v 转发失败 %w", msg, err)
 	}
-	err = sender.dao.Complete(ctx, sender.dst, msg.Id)
+	err = sender.store.Complete(ctx, msg)
 	if err != nil {
 		return fmt.Errorf("更新消息%v 失败 %w", msg, err)
 	}
@@ -0,0 +1,86 @@
+package delay_platform
+
+import (
+	"context"
+	"github.com/ecodeclub/ekit/sqlx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store"
+	"interview-cases/case11_20/case15/pb"
+	"log/slog"
+)
+
+// DelayService 延迟消息的同步写入入口
+// 和 DelayMsgReceiver 不同，业务方直接调用 gRPC 接口写入数据库，
+// 返回成功就意味着消息已经落库，并且拿到了消息 ID
+type DelayService struct {
+	pb.UnimplementedDelayServiceServer
+	store store.DelayStore
+}
+
+func NewDelayService(store store.DelayStore) *DelayService {
+	return &DelayService{store: store}
+}
+
+func (s *DelayService) Schedule(ctx context.Context, req *pb.ScheduleRequest) (*pb.ScheduleResponse, error) {
+	if err := s.validate(req.GetMsg()); err != nil {
+		return nil, err
+	}
+	id, duplicated, err := s.schedule(ctx, req.GetMsg())
+	if err != nil {
+		slog.Error("写入延迟消息失败", slog.Any("err", err))
+		return nil, status.Errorf(codes.Internal, "写入延迟消息失败 %v", err)
+	}
+	return &pb.ScheduleResponse{Id: id, Duplicated: duplicated}, nil
+}
+
+// ScheduleBatch 批量写入
+// 每条消息独立写入，某一条失败不影响其他消息，失败原因放在对应的结果里面
+func (s *DelayService) ScheduleBatch(ctx context.Context, req *pb.ScheduleBatchRequest) (*pb.ScheduleBatchResponse, error) {
+	results := make([]*pb.ScheduleResult, 0, len(req.GetMsgs()))
+	for _, msg := range req.GetMsgs() {
+		if err := s.validate(msg); err != nil {
+			results = append(results, &pb.ScheduleResult{Err: err.Error()})
+			continue
+		}
+		id, duplicated, err := s.schedule(ctx, msg)
+		if err != nil {
+			slog.Error("写入延迟消息失败", slog.Any("err", err))
+			results = append(results, &pb.ScheduleResult{Err: err.Error()})
+			continue
+		}
+		results = append(results, &pb.ScheduleResult{Id: id, Duplicated: duplicated})
+	}
+	return &pb.ScheduleBatchResponse{Results: results}, nil
+}
+
+func (s *DelayService) validate(msg *pb.DelayMsg) error {
+	if msg == nil {
+		return status.Error(codes.InvalidArgument, "延迟消息不能为空")
+	}
+	if msg.GetTopic() == "" {
+		return status.Error(codes.InvalidArgument, "业务 topic 不能为空")
+	}
+	return nil
+}
+
+func (s *DelayService) schedule(ctx context.Context, msg *pb.DelayMsg) (int64, bool, error) {
+	entity := dao.DelayMsg{
+		Topic:    msg.GetTopic(),
+		Value:    msg.GetValue(),
+		Deadline: msg.GetDeadline(),
+		Key:      sqlx.NewNullString(msg.GetKey()),
+		Status:   DelayMsgStatusWaiting.ToUint8(),
+	}
+	res, duplicated, err := s.store.Insert(ctx, entity)
+	if err != nil {
+		return 0, false, err
+	}
+	return res.Id, duplicated, nil
+}
+
+func RegisterDelayServiceServer(s *grpc.Server, svc *DelayService) {
+	pb.RegisterDelayServiceServer(s, svc)
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"context"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"sync/atomic"
+	"time"
+)
+
+// Store 基于 MySQL 分库分表的 DelayStore 实现
+type Store struct {
+	dao *dao.DelayMsgDAO
+	// Claim 的时候从哪些表里面拿消息
+	// 在实践中一般是一个发送者负责一张表
+	tables []string
+	// 每次 Claim 从哪张表开始，轮换起点，免得后面的表一直拿不到配额
+	start atomic.Uint64
+}
+
+// NewStore tables 是这个 Store 负责转发的表，不传就是所有的表
+// Insert 不受它影响，依旧是写到所有的表
+func NewStore(dao *dao.DelayMsgDAO, tables ...string) *Store {
+	if len(tables) == 0 {
+		tables = dao.Tables()
+	}
+	return &Store{dao: dao, tables: tables}
+}
+
+func (s *Store) Insert(ctx context.Context, msg dao.DelayMsg) (dao.DelayMsg, bool, error) {
+	return s.dao.InsertIdempotent(ctx, msg)
+}
+
+func (s *Store) Claim(ctx context.Context, limit int, visibility time.Duration) ([]dao.DelayMsg, error) {
+	res := make([]dao.DelayMsg, 0, limit)
+	start := int(s.start.Add(1) % uint64(len(s.tables)))
+	for i := range s.tables {
+		if len(res) >= limit {
+			break
+		}
+		tab := s.tables[(start+i)%len(s.tables)]
+		ms, err := s.dao.Claim(ctx, tab, limit-len(res), visibility)
+		res = append(res, ms...)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Complete 按照 Key 或者 ID 算出每条消息所在的表，每张表只更新一次
+func (s *Store) Complete(ctx context.Context, msgs ...dao.DelayMsg) error {
+	groups := make(map[string][]int64)
+	for _, msg := range msgs {
+		tab := s.dao.TableOf(msg)
+		groups[tab] = append(groups[tab], msg.Id)
+	}
+	for tab, ids := range groups {
+		if err := s.dao.Complete(ctx, tab, ids...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package mysql
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store/storetest"
+	"interview-cases/test"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	msgDAO, err := dao.NewDelayMsgDAO(test.InitDB(), 1)
+	require.NoError(t, err)
+	suite.Run(t, storetest.NewSuite(NewStore(msgDAO)))
+}
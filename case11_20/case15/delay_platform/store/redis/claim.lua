@@ -0,0 +1,27 @@
+local queue = KEYS[1]
+local payloads = KEYS[2]
+
+-- 当前时间戳要求服务端传过来，毫秒
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+-- 可见性超时，毫秒
+local visibility = tonumber(ARGV[3])
+
+local ids = redis.call('ZRANGEBYSCORE', queue, '-inf', now, 'LIMIT', 0, limit)
+if #ids == 0 then
+    return {}
+end
+local values = redis.call('HMGET', payloads, unpack(ids))
+local res = {}
+for i, id in ipairs(ids) do
+    if values[i] then
+        -- 把 score 往后推，在 visibility 之内其他发送者就拿不到了
+        -- 整个脚本是原子的，所以同一条消息不会被两个发送者同时抢到
+        redis.call('ZADD', queue, now + visibility, id)
+        table.insert(res, values[i])
+    else
+        -- 内容已经没有了，留在有序集合里面只会被一次又一次地抢占
+        redis.call('ZREM', queue, id)
+    end
+end
+return res
@@ -0,0 +1,28 @@
+-- 有序集合，score 是 deadline，member 是消息 ID
+local queue = KEYS[1]
+-- 消息 ID 到消息内容的哈希
+local payloads = KEYS[2]
+-- 幂等键，值是消息 ID
+local idempotency = KEYS[3]
+
+local id = ARGV[1]
+local key = ARGV[2]
+local payload = ARGV[3]
+local deadline = tonumber(ARGV[4])
+-- 当前时间戳要求服务端传过来，毫秒
+local now = tonumber(ARGV[5])
+-- 消息到期之后幂等键还要保留多久，毫秒
+local retention = tonumber(ARGV[6])
+
+if key ~= '' then
+    local existing = redis.call('GET', idempotency)
+    if existing then
+        -- 重复提交，返回已有的消息
+        -- 如果消息已经转发完了，那么内容已经被删了，这里就是 false
+        return {0, existing, redis.call('HGET', payloads, existing)}
+    end
+    redis.call('SET', idempotency, id, 'PX', math.max(deadline - now, 0) + retention)
+end
+redis.call('HSET', payloads, id, payload)
+redis.call('ZADD', queue, deadline, id)
+return {1, id, payload}
@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"github.com/redis/go-redis/v9"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"strconv"
+	"time"
+)
+
+var (
+	//go:embed insert.lua
+	insertScript string
+	//go:embed claim.lua
+	claimScript string
+)
+
+// DefaultKeyRetention 消息到期之后幂等键默认保留一天，这段时间内重复提交都能去重
+const DefaultKeyRetention = 24 * time.Hour
+
+// Store 基于 Redis 有序集合的 DelayStore 实现
+// score 是 deadline，member 是消息 ID，消息内容放在一个哈希里面
+// 每个幂等键是一个单独的 key，带着过期时间，不会无限增长
+type Store struct {
+	client redis.Cmdable
+	// 用 hash tag 包起来，保证在 Redis Cluster 下所有的 key 都落在同一个槽
+	prefix    string
+	node      *snowflake.Node
+	retention time.Duration
+}
+
+// NewStore nodeID 是雪花算法的节点 ID，每个平台实例必须不一样，不然生成的 ID 会冲突
+func NewStore(client redis.Cmdable, prefix string, nodeID int64) (*Store, error) {
+	return NewStoreWithRetention(client, prefix, nodeID, DefaultKeyRetention)
+}
+
+// NewStoreWithRetention 幂等键在消息到期之后再保留 retention，过期之后同一个 Key 可以再次提交
+func NewStoreWithRetention(client redis.Cmdable, prefix string, nodeID int64, retention time.Duration) (*Store, error) {
+	node, err := snowflake.NewNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		client:    client,
+		prefix:    prefix,
+		node:      node,
+		retention: retention,
+	}, nil
+}
+
+func (s *Store) queueKey() string {
+	return fmt.Sprintf("{%s}:queue", s.prefix)
+}
+
+func (s *Store) payloadKey() string {
+	return fmt.Sprintf("{%s}:payload", s.prefix)
+}
+
+func (s *Store) idempotencyKey(key string) string {
+	return fmt.Sprintf("{%s}:key:%s", s.prefix, key)
+}
+
+func (s *Store) Insert(ctx context.Context, msg dao.DelayMsg) (dao.DelayMsg, bool, error) {
+	now := time.Now().UnixMilli()
+	msg.Id = s.node.Generate().Int64()
+	msg.Ctime = now
+	msg.Utime = now
+	msg.Status = 0
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return dao.DelayMsg{}, false, err
+	}
+	res, err := s.client.Eval(ctx, insertScript,
+		[]string{s.queueKey(), s.payloadKey(), s.idempotencyKey(msg.Key.String)},
+		msg.Id, msg.Key.String, payload, msg.Deadline, now, s.retention.Milliseconds()).Slice()
+	if err != nil {
+		return dao.DelayMsg{}, false, err
+	}
+	if res[0].(int64) == 1 {
+		return msg, false, nil
+	}
+	// 重复提交
+	existingId, err := strconv.ParseInt(res[1].(string), 10, 64)
+	if err != nil {
+		return dao.DelayMsg{}, false, err
+	}
+	if len(res) < 3 || res[2] == nil {
+		// 内容已经被删了，说明这条消息已经转发完了
+		return dao.DelayMsg{Id: existingId, Key: msg.Key, Status: 1}, true, nil
+	}
+	var existing dao.DelayMsg
+	err = json.Unmarshal([]byte(res[2].(string)), &existing)
+	return existing, true, err
+}
+
+func (s *Store) Claim(ctx context.Context, limit int, visibility time.Duration) ([]dao.DelayMsg, error) {
+	res, err := s.client.Eval(ctx, claimScript,
+		[]string{s.queueKey(), s.payloadKey()},
+		time.Now().UnixMilli(), limit, visibility.Milliseconds()).Slice()
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]dao.DelayMsg, 0, len(res))
+	for _, val := range res {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var msg dao.DelayMsg
+		if err = json.Unmarshal([]byte(str), &msg); err != nil {
+			return ms, err
+		}
+		ms = append(ms, msg)
+	}
+	return ms, nil
+}
+
+// Complete 把消息从有序集合和哈希里面删掉
+// 幂等键会保留到过期，这样已经转发过的消息再次提交也能被去重
+func (s *Store) Complete(ctx context.Context, msgs ...dao.DelayMsg) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	members := make([]any, 0, len(msgs))
+	fields := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		str := strconv.FormatInt(msg.Id, 10)
+		members = append(members, str)
+		fields = append(fields, str)
+	}
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, s.queueKey(), members...)
+		pipe.HDel(ctx, s.payloadKey(), fields...)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"context"
+	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store/storetest"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+// cleanup 删掉 s 写入的所有 key
+func cleanup(t *testing.T, rdb redis.Cmdable, s *Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	keys, err := rdb.Keys(ctx, s.idempotencyKey("*")).Result()
+	if err != nil {
+		t.Log("清理数据失败", err)
+	}
+	keys = append(keys, s.queueKey(), s.payloadKey())
+	if err = rdb.Del(ctx, keys...).Err(); err != nil {
+		t.Log("清理数据失败", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	rdb := test.InitRedis()
+	s, err := NewStore(rdb, "case15/delay_msg", 1)
+	require.NoError(t, err)
+	defer cleanup(t, rdb, s)
+	suite.Run(t, storetest.NewSuite(s))
+}
+
+// 幂等键在消息到期之后再保留 retention
+func TestStore_KeyRetention(t *testing.T) {
+	rdb := test.InitRedis()
+	s, err := NewStoreWithRetention(rdb, "case15/delay_msg_retention", 1, time.Minute)
+	require.NoError(t, err)
+	defer cleanup(t, rdb, s)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	testCases := []struct {
+		name     string
+		key      string
+		deadline time.Time
+		wantTTL  time.Duration
+	}{
+		{name: "已经到期", key: "due", deadline: time.Now().Add(-time.Hour), wantTTL: time.Minute},
+		{name: "一小时之后到期", key: "later", deadline: time.Now().Add(time.Hour), wantTTL: time.Hour + time.Minute},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, duplicated, err := s.Insert(ctx, dao.DelayMsg{
+				Topic:    "retention",
+				Key:      sqlx.NewNullString(tc.key),
+				Deadline: tc.deadline.UnixMilli(),
+			})
+			require.NoError(t, err)
+			require.False(t, duplicated)
+			ttl, err := rdb.PTTL(ctx, s.idempotencyKey(tc.key)).Result()
+			require.NoError(t, err)
+			assert.LessOrEqual(t, ttl, tc.wantTTL)
+			assert.Greater(t, ttl, tc.wantTTL-time.Second)
+		})
+	}
+}
+
+// 有序集合里面有 ID 但是内容已经没有了，Claim 的时候顺便删掉
+func TestStore_ClaimMissingPayload(t *testing.T) {
+	rdb := test.InitRedis()
+	s, err := NewStore(rdb, "case15/delay_msg_missing", 1)
+	require.NoError(t, err)
+	defer cleanup(t, rdb, s)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, _, err := s.Insert(ctx, dao.DelayMsg{Topic: "missing", Deadline: time.Now().Add(-time.Second).UnixMilli()})
+	require.NoError(t, err)
+	require.NoError(t, rdb.ZAdd(ctx, s.queueKey(), redis.Z{Score: 0, Member: "123"}).Err())
+
+	ms, err := s.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, ms, 1)
+	assert.Equal(t, msg.Id, ms[0].Id)
+	_, err = rdb.ZScore(ctx, s.queueKey(), "123").Result()
+	assert.Equal(t, redis.Nil, err)
+	cnt, err := rdb.ZCard(ctx, s.queueKey()).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cnt)
+}
@@ -0,0 +1,194 @@
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/ecodeclub/ekit/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store"
+	"sync"
+	"time"
+)
+
+// Suite 所有 DelayStore 实现都要通过的测试
+// 存储里面可能残留了别的测试写入的消息，所以每个测试都用一个独立的 topic 来识别自己的消息
+type Suite struct {
+	suite.Suite
+	store store.DelayStore
+}
+
+func NewSuite(store store.DelayStore) *Suite {
+	return &Suite{store: store}
+}
+
+func (s *Suite) topic() string {
+	return fmt.Sprintf("store_test_%d", time.Now().UnixNano())
+}
+
+func (s *Suite) insert(ctx context.Context, topic, key string, deadline time.Time) dao.DelayMsg {
+	msg, duplicated, err := s.store.Insert(ctx, dao.DelayMsg{
+		Topic:    topic,
+		Value:    []byte("delayMsg"),
+		Key:      sqlx.NewNullString(key),
+		Deadline: deadline.UnixMilli(),
+	})
+	require.NoError(s.T(), err)
+	require.False(s.T(), duplicated)
+	return msg
+}
+
+// claimOwn 拿到所有到期的消息，只保留这个测试自己写入的
+func (s *Suite) claimOwn(ctx context.Context, topic string, visibility time.Duration) []dao.DelayMsg {
+	var res []dao.DelayMsg
+	for {
+		ms, err := s.store.Claim(ctx, 100, visibility)
+		require.NoError(s.T(), err)
+		for _, msg := range ms {
+			if msg.Topic == topic {
+				res = append(res, msg)
+			}
+		}
+		if len(ms) < 100 {
+			return res
+		}
+	}
+}
+
+func (s *Suite) ids(ms []dao.DelayMsg) []int64 {
+	res := make([]int64, 0, len(ms))
+	for _, msg := range ms {
+		res = append(res, msg.Id)
+	}
+	return res
+}
+
+func (s *Suite) TestInsert() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	topic := s.topic()
+	key := topic + "_key"
+	first := s.insert(ctx, topic, key, time.Now().Add(time.Minute))
+	assert.True(s.T(), first.Id > 0)
+
+	// 同一个 Key 再次写入，拿到的是已有的那条
+	second, duplicated, err := s.store.Insert(ctx, dao.DelayMsg{
+		Topic:    topic,
+		Value:    []byte("another"),
+		Key:      sqlx.NewNullString(key),
+		Deadline: time.Now().UnixMilli(),
+	})
+	require.NoError(s.T(), err)
+	assert.True(s.T(), duplicated)
+	assert.Equal(s.T(), first.Id, second.Id)
+	assert.Equal(s.T(), []byte("delayMsg"), second.Value)
+
+	// 没有 Key 就不去重
+	third := s.insert(ctx, topic, "", time.Now().Add(time.Minute))
+	fourth := s.insert(ctx, topic, "", time.Now().Add(time.Minute))
+	assert.NotEqual(s.T(), third.Id, fourth.Id)
+}
+
+func (s *Suite) TestClaim() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	topic := s.topic()
+	due := s.insert(ctx, topic, "", time.Now().Add(-time.Second))
+	s.insert(ctx, topic, "", time.Now().Add(time.Hour))
+
+	// 只有到期的消息会被拿到
+	claimed := s.claimOwn(ctx, topic, time.Minute)
+	assert.Equal(s.T(), []int64{due.Id}, s.ids(claimed))
+
+	// 在可见性超时之内，别的发送者拿不到
+	claimed = s.claimOwn(ctx, topic, time.Minute)
+	assert.Empty(s.T(), claimed)
+}
+
+func (s *Suite) TestClaimVisibilityTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	topic := s.topic()
+	msg := s.insert(ctx, topic, "", time.Now().Add(-time.Second))
+
+	claimed := s.claimOwn(ctx, topic, 500*time.Millisecond)
+	assert.Equal(s.T(), []int64{msg.Id}, s.ids(claimed))
+
+	// 发送者没有 Complete，过了可见性超时之后消息重新可见
+	time.Sleep(time.Second)
+	claimed = s.claimOwn(ctx, topic, time.Minute)
+	assert.Equal(s.T(), []int64{msg.Id}, s.ids(claimed))
+}
+
+func (s *Suite) TestComplete() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	topic := s.topic()
+	key := topic + "_key"
+	msg := s.insert(ctx, topic, key, time.Now().Add(-time.Second))
+
+	claimed := s.claimOwn(ctx, topic, 500*time.Millisecond)
+	require.Equal(s.T(), []int64{msg.Id}, s.ids(claimed))
+	err := s.store.Complete(ctx, claimed...)
+	require.NoError(s.T(), err)
+
+	// 完成之后，过了可见性超时也不会再被拿到
+	time.Sleep(time.Second)
+	claimed = s.claimOwn(ctx, topic, time.Minute)
+	assert.Empty(s.T(), claimed)
+
+	// 已经完成的消息依旧参与去重
+	res, duplicated, err := s.store.Insert(ctx, dao.DelayMsg{
+		Topic: topic,
+		Key:   sqlx.NewNullString(key),
+	})
+	require.NoError(s.T(), err)
+	assert.True(s.T(), duplicated)
+	assert.Equal(s.T(), msg.Id, res.Id)
+}
+
+// TestConcurrentClaim 多个发送者同时抢占，每条消息只会被一个发送者拿到
+func (s *Suite) TestConcurrentClaim() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	topic := s.topic()
+	const cnt = 50
+	want := make(map[int64]struct{}, cnt)
+	for i := 0; i < cnt; i++ {
+		msg := s.insert(ctx, topic, "", time.Now().Add(-time.Second))
+		want[msg.Id] = struct{}{}
+	}
+
+	var (
+		mu  sync.Mutex
+		got = make(map[int64]int, cnt)
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ms, err := s.store.Claim(ctx, 7, time.Minute)
+				if !assert.NoError(s.T(), err) || len(ms) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, msg := range ms {
+					if msg.Topic == topic {
+						got[msg.Id]++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(s.T(), got, cnt)
+	for id, times := range got {
+		assert.Contains(s.T(), want, id)
+		assert.Equal(s.T(), 1, times, "消息 %d 被抢占了 %d 次", id, times)
+	}
+}
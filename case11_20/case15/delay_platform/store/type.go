@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"time"
+)
+
+// DelayStore 延迟消息的存储抽象
+// 发送者通过 Claim 抢占到期的消息，被抢占的消息在 visibility 时间内对其他发送者不可见，
+// 如果发送者在这段时间内没有调用 Complete（比如说崩溃了），消息会重新变得可见。
+// 所以多个发送者可以安全地从同一个存储里面拉取消息，代价是消息可能会被重复发送
+type DelayStore interface {
+	// Insert 写入延迟消息，Key 作为幂等键
+	// 如果 Key 已经存在，返回已有的那条消息，并且第二个返回值为 true
+	Insert(ctx context.Context, msg dao.DelayMsg) (dao.DelayMsg, bool, error)
+	// Claim 抢占最多 limit 条已经到期的消息
+	Claim(ctx context.Context, limit int, visibility time.Duration) ([]dao.DelayMsg, error)
+	// Complete 标记消息已经转发成功，之后不会再被 Claim 到
+	// 传入 Claim 拿到的消息，实现可以根据消息的内容找到它存在哪里
+	Complete(ctx context.Context, msgs ...dao.DelayMsg) error
+}
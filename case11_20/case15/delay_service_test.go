@@ -0,0 +1,106 @@
+package case15
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"interview-cases/case11_20/case15/biz/producer"
+	"interview-cases/case11_20/case15/delay_platform"
+	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/case11_20/case15/delay_platform/store/mysql"
+	"interview-cases/test"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+type DelayServiceTestSuite struct {
+	suite.Suite
+	addr   string
+	client *producer.Client
+}
+
+func (s *DelayServiceTestSuite) SetupSuite() {
+	db := test.InitDB()
+	msgDAO, err := dao.NewDelayMsgDAO(db, 1)
+	require.NoError(s.T(), err)
+	svc := delay_platform.NewDelayService(mysql.NewStore(msgDAO))
+	grpcServer := grpc.NewServer()
+	delay_platform.RegisterDelayServiceServer(grpcServer, svc)
+	lis, err := net.Listen("tcp", s.addr)
+	require.NoError(s.T(), err)
+	go func() {
+		log.Println("开始启动延迟平台 grpc 服务端...")
+		if err := grpcServer.Serve(lis); err != nil {
+			panic(err)
+		}
+	}()
+	conn, err := grpc.Dial(s.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(s.T(), err)
+	s.client = producer.NewClient(conn)
+}
+
+func (s *DelayServiceTestSuite) TestSchedule() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("order_%d", time.Now().UnixNano())
+	msg := producer.DelayMsg{
+		Value:    []byte("delayMsg"),
+		Key:      key,
+		Topic:    bizTopic,
+		Deadline: time.Now().Add(time.Minute).UnixMilli(),
+	}
+	first, err := s.client.Schedule(ctx, msg)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), first.Id > 0)
+	assert.False(s.T(), first.Duplicated)
+
+	// 同一个 Key 重复提交，拿到的是同一条消息
+	second, err := s.client.Schedule(ctx, msg)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), first.Id, second.Id)
+	assert.True(s.T(), second.Duplicated)
+
+	// 没有 Key 就不去重
+	msg.Key = ""
+	third, err := s.client.Schedule(ctx, msg)
+	require.NoError(s.T(), err)
+	assert.False(s.T(), third.Duplicated)
+	assert.NotEqual(s.T(), first.Id, third.Id)
+
+	// 缺少业务 topic
+	msg.Topic = ""
+	_, err = s.client.Schedule(ctx, msg)
+	assert.Error(s.T(), err)
+}
+
+func (s *DelayServiceTestSuite) TestScheduleBatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("order_%d", time.Now().UnixNano())
+	deadline := time.Now().Add(time.Minute).UnixMilli()
+	results, err := s.client.ScheduleBatch(ctx, []producer.DelayMsg{
+		{Value: []byte("msg1"), Key: key, Topic: bizTopic, Deadline: deadline},
+		{Value: []byte("msg2"), Key: key, Topic: bizTopic, Deadline: deadline},
+		{Value: []byte("msg3"), Deadline: deadline},
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), results, 3)
+	assert.NoError(s.T(), results[0].Err)
+	assert.False(s.T(), results[0].Duplicated)
+	assert.NoError(s.T(), results[1].Err)
+	assert.True(s.T(), results[1].Duplicated)
+	assert.Equal(s.T(), results[0].Id, results[1].Id)
+	assert.Error(s.T(), results[2].Err)
+}
+
+func TestDelayService(t *testing.T) {
+	suite.Run(t, &DelayServiceTestSuite{
+		addr: "127.0.0.1:9998",
+	})
+}
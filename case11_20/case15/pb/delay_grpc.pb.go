@@ -0,0 +1,150 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v5.27.1
+// source: delay.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	DelayService_Schedule_FullMethodName      = "/proto.DelayService/Schedule"
+	DelayService_ScheduleBatch_FullMethodName = "/proto.DelayService/ScheduleBatch"
+)
+
+// DelayServiceClient is the client API for DelayService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DelayServiceClient interface {
+	// Schedule 提交一条延迟消息
+	Schedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*ScheduleResponse, error)
+	// ScheduleBatch 批量提交延迟消息，每条消息的结果单独返回
+	ScheduleBatch(ctx context.Context, in *ScheduleBatchRequest, opts ...grpc.CallOption) (*ScheduleBatchResponse, error)
+}
+
+type delayServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDelayServiceClient(cc grpc.ClientConnInterface) DelayServiceClient {
+	return &delayServiceClient{cc}
+}
+
+func (c *delayServiceClient) Schedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*ScheduleResponse, error) {
+	out := new(ScheduleResponse)
+	err := c.cc.Invoke(ctx, DelayService_Schedule_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *delayServiceClient) ScheduleBatch(ctx context.Context, in *ScheduleBatchRequest, opts ...grpc.CallOption) (*ScheduleBatchResponse, error) {
+	out := new(ScheduleBatchResponse)
+	err := c.cc.Invoke(ctx, DelayService_ScheduleBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DelayServiceServer is the server API for DelayService service.
+// All implementations must embed UnimplementedDelayServiceServer
+// for forward compatibility
+type DelayServiceServer interface {
+	// Schedule 提交一条延迟消息
+	Schedule(context.Context, *ScheduleRequest) (*ScheduleResponse, error)
+	// ScheduleBatch 批量提交延迟消息，每条消息的结果单独返回
+	ScheduleBatch(context.Context, *ScheduleBatchRequest) (*ScheduleBatchResponse, error)
+	mustEmbedUnimplementedDelayServiceServer()
+}
+
+// UnimplementedDelayServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDelayServiceServer struct {
+}
+
+func (UnimplementedDelayServiceServer) Schedule(context.Context, *ScheduleRequest) (*ScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Schedule not implemented")
+}
+func (UnimplementedDelayServiceServer) ScheduleBatch(context.Context, *ScheduleBatchRequest) (*ScheduleBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScheduleBatch not implemented")
+}
+func (UnimplementedDelayServiceServer) mustEmbedUnimplementedDelayServiceServer() {}
+
+// UnsafeDelayServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DelayServiceServer will
+// result in compilation errors.
+type UnsafeDelayServiceServer interface {
+	mustEmbedUnimplementedDelayServiceServer()
+}
+
+func RegisterDelayServiceServer(s grpc.ServiceRegistrar, srv DelayServiceServer) {
+	s.RegisterService(&DelayService_ServiceDesc, srv)
+}
+
+func _DelayService_Schedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DelayServiceServer).Schedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DelayService_Schedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DelayServiceServer).Schedule(ctx, req.(*ScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DelayService_ScheduleBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DelayServiceServer).ScheduleBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DelayService_ScheduleBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DelayServiceServer).ScheduleBatch(ctx, req.(*ScheduleBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DelayService_ServiceDesc is the grpc.ServiceDesc for DelayService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DelayService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.DelayService",
+	HandlerType: (*DelayServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Schedule",
+			Handler:    _DelayService_Schedule_Handler,
+		},
+		{
+			MethodName: "ScheduleBatch",
+			Handler:    _DelayService_ScheduleBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "delay.proto",
+}
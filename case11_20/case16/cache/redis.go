@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"interview-cases/clock"
 	"math/rand"
 	"sync"
 	"time"
@@ -43,11 +44,21 @@ type RedisManager struct {
 
 	trafficWeight int // 目前节点流量权重
 
-	lock sync.Mutex
+	lock  sync.Mutex
+	clock clock.Clock
+	// 灰度的时候用来决定请求落到哪个节点
+	random func(n int) int
 }
 
 // NewRedisManager 创建 RedisManager 并初始化两个节点
 func NewRedisManager(mainClient, backupClient *redis.Client, mainBreakNum, mainRecoverNum int) *RedisManager {
+	return NewRedisManagerWithClock(mainClient, backupClient, mainBreakNum, mainRecoverNum, clock.New(), rand.Intn)
+}
+
+// NewRedisManagerWithClock 心跳的间隔由 clk 控制，测试的时候不需要真的等
+// random 决定灰度的时候请求落到哪个节点，测试的时候可以让请求固定落到某个节点上
+func NewRedisManagerWithClock(mainClient, backupClient *redis.Client, mainBreakNum, mainRecoverNum int,
+	clk clock.Clock, random func(n int) int) *RedisManager {
 	mainNode := &RedisNode{
 		client:   mainClient,
 		isActive: true,
@@ -63,6 +74,8 @@ func NewRedisManager(mainClient, backupClient *redis.Client, mainBreakNum, mainR
 		trafficWeight:  MaxTrafficWeight,
 		mainBreakNum:   mainBreakNum,
 		mainRecoverNum: mainRecoverNum,
+		clock:          clk,
+		random:         random,
 	}
 }
 
@@ -127,7 +140,7 @@ func (rm *RedisManager) getNode() (node *RedisNode, isGrayNode bool) {
 			return
 		}
 	} else if rm.grayMode == Main2BackupGrayMode {
-		if rand.Intn(100) < rm.trafficWeight {
+		if rm.random(100) < rm.trafficWeight {
 			node = rm.backupNode
 			isGrayNode = true
 			return
@@ -135,7 +148,7 @@ func (rm *RedisManager) getNode() (node *RedisNode, isGrayNode bool) {
 			return // 拒绝访问
 		}
 	} else {
-		if rand.Intn(100) < rm.trafficWeight {
+		if rm.random(100) < rm.trafficWeight {
 			node = rm.mainNode
 			isGrayNode = true
 			return
@@ -165,8 +178,12 @@ func (rm *RedisManager) adjustWeight() {
 // main切换到backup的灰度需要拒绝main上的所有请求
 // backup切换到main的灰度需要
 func (rm *RedisManager) HeartbeatChecker(ctx context.Context) {
-	ticker := time.NewTicker(time.Second)
-	for _ = range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rm.clock.After(time.Second):
+		}
 		err := rm.pingMainNode(ctx)
 		if err == nil {
 			if rm.mainNode.isActive == false {
@@ -6,18 +6,42 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"interview-cases/case11_20/case16/cache"
+	"interview-cases/clock"
 	"testing"
 	"time"
 )
 
 func TestRedisManager(t *testing.T) {
-	// 模拟 Redis 节点 a 和 b
-	rdbA, mockA := redismock.NewClientMock()
-	rdbB, mockB := redismock.NewClientMock()
-
 	key := "test_key"
 	val := "test_value"
 
+	// 模拟 Redis 节点 a 和 b，每个用例都用新的 mock 和时钟，避免上一个用例的心跳协程干扰
+	var (
+		rdbA, rdbB   *redis.Client
+		mockA, mockB redismock.ClientMock
+		clk          *clock.Fake
+	)
+	// 灰度的时候请求总是落到灰度节点上
+	alwaysFirst := func(n int) int {
+		return 0
+	}
+	// 等待 n 次心跳
+	heartbeat := func(n int) {
+		for i := 0; i < n; i++ {
+			clk.BlockUntil(1)
+			clk.Advance(time.Second)
+		}
+		// 心跳协程处理完，重新开始等待
+		clk.BlockUntil(1)
+	}
+	setAndGet := func(ctx context.Context, manager *cache.RedisManager) (string, error) {
+		err := manager.SetValue(ctx, key, val, 0)
+		if err != nil {
+			return "", err
+		}
+		return manager.GetValue(ctx, key)
+	}
+
 	testCases := []struct {
 		name string
 
@@ -37,16 +61,11 @@ func TestRedisManager(t *testing.T) {
 				mockA.ExpectSet(key, val, 0).SetVal("OK")
 				mockA.ExpectGet(key).SetVal(val)
 
-				return cache.NewRedisManager(rdbA, rdbB, 2, 2)
+				return cache.NewRedisManagerWithClock(rdbA, rdbB, 2, 2, clk, alwaysFirst)
 			},
 			after: func(ctx context.Context, manager *cache.RedisManager) (string, error) {
-				time.Sleep(time.Second)
-				err := manager.SetValue(ctx, key, val, 0)
-				if err != nil {
-					return "", err
-				}
-				res, err := manager.GetValue(ctx, key)
-				return res, err
+				heartbeat(1)
+				return setAndGet(ctx, manager)
 			},
 			wantRes: val,
 		},
@@ -60,17 +79,11 @@ func TestRedisManager(t *testing.T) {
 				mockB.ExpectSet(key, val, 0).SetVal("OK")
 				mockB.ExpectGet(key).SetVal(val)
 
-				return cache.NewRedisManager(rdbA, rdbB, 2, 2)
+				return cache.NewRedisManagerWithClock(rdbA, rdbB, 2, 2, clk, alwaysFirst)
 			},
 			after: func(ctx context.Context, manager *cache.RedisManager) (string, error) {
-				time.Sleep(2 * time.Second)
-
-				err := manager.SetValue(ctx, key, val, 0)
-				if err != nil {
-					return "", err
-				}
-				res, err := manager.GetValue(ctx, key)
-				return res, err
+				heartbeat(2)
+				return setAndGet(ctx, manager)
 			},
 			wantRes: val,
 		},
@@ -89,17 +102,11 @@ func TestRedisManager(t *testing.T) {
 				mockB.ExpectSet(key, val, 0).SetVal("OK")
 				mockB.ExpectGet(key).SetVal(val)
 
-				return cache.NewRedisManager(rdbA, rdbB, 2, 2)
+				return cache.NewRedisManagerWithClock(rdbA, rdbB, 2, 2, clk, alwaysFirst)
 			},
 			after: func(ctx context.Context, manager *cache.RedisManager) (string, error) {
-				time.Sleep(4 * time.Second)
-
-				err := manager.SetValue(ctx, key, val, 0)
-				if err != nil {
-					return "", err
-				}
-				res, err := manager.GetValue(ctx, key)
-				return res, err
+				heartbeat(4)
+				return setAndGet(ctx, manager)
 			},
 			wantRes: val,
 		},
@@ -107,9 +114,14 @@ func TestRedisManager(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			clk = clock.NewFake(time.Unix(1700000000, 0))
+			rdbA, mockA = redismock.NewClientMock()
+			rdbB, mockB = redismock.NewClientMock()
 			rdbManager := tc.before()
-			go rdbManager.HeartbeatChecker(tc.ctx)
-			res, err := tc.after(tc.ctx, rdbManager)
+			ctx, cancel := context.WithCancel(tc.ctx)
+			defer cancel()
+			go rdbManager.HeartbeatChecker(ctx)
+			res, err := tc.after(ctx, rdbManager)
 			assert.Equal(t, tc.wantErr, err)
 
 			if err != nil {
@@ -0,0 +1,211 @@
+package interceptor
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"interview-cases/case11_20/case17/monitor"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BBRConfig BBR 限流器的配置
+type BBRConfig struct {
+	// CPU 使用率超过这个值才会开始限流，百分比
+	CPUThreshold float64
+	// 统计窗口的大小，以及分成多少个桶
+	Window  time.Duration
+	Buckets int
+	// 多久采集一次 CPU 使用率
+	Interval time.Duration
+	// 触发限流之后，即便 CPU 降下来了，也要在这段时间内继续按照并发数限流
+	// 避免 CPU 一降下来就放开，紧接着又被打满
+	CoolDown time.Duration
+}
+
+// BBRLimiter 参考 TCP BBR 拥塞控制的自适应限流
+// 系统能够承载的并发数约等于 最大吞吐量 × 最小响应时间，
+// 在 CPU 过载的时候，正在处理的请求数超过这个值就拒绝新的请求。
+// 和 MemoryLimiter 一刀切地拒绝所有请求不同，它会尽量让系统维持在最大吞吐量附近
+type BBRLimiter struct {
+	mon    monitor.Monitor
+	cfg    BBRConfig
+	window *rollingWindow
+	clock  clock.Clock
+	stop   chan struct{}
+
+	inFlight atomic.Int64
+	// 最近一次 CPU 使用率
+	cpu atomic.Uint64
+	// 上一次拒绝请求的时间，纳秒，0 代表冷却期已经结束
+	prevDrop atomic.Int64
+}
+
+func NewBBRLimiter(mon monitor.Monitor, cfg BBRConfig) *BBRLimiter {
+	return NewBBRLimiterWithClock(mon, cfg, clock.New())
+}
+
+// NewBBRLimiterWithClock 采集的周期、滑动窗口和冷却时间都由 clk 决定
+func NewBBRLimiterWithClock(mon monitor.Monitor, cfg BBRConfig, clk clock.Clock) *BBRLimiter {
+	b := newBBRLimiter(mon, cfg, clk)
+	go b.monitor()
+	return b
+}
+
+func newBBRLimiter(mon monitor.Monitor, cfg BBRConfig, clk clock.Clock) *BBRLimiter {
+	return &BBRLimiter{
+		mon:    mon,
+		cfg:    cfg,
+		window: newRollingWindow(cfg.Window, cfg.Buckets, clk),
+		clock:  clk,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (b *BBRLimiter) monitor() {
+	ticker := b.clock.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			b.check()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Close 停止采集 CPU 使用率
+func (b *BBRLimiter) Close() {
+	close(b.stop)
+}
+
+// check 采集一次 CPU 使用率
+func (b *BBRLimiter) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	usage, err := b.mon.GetCPUUsage(ctx)
+	if err != nil {
+		slog.Error("获取监控信息失败", slog.Any("err", err))
+		return
+	}
+	b.cpu.Store(math.Float64bits(usage))
+}
+
+// maxInFlight 系统能够承载的最大并发数
+func (b *BBRLimiter) maxInFlight() int64 {
+	maxPass, minRT := b.window.stats()
+	// 每个桶的最大吞吐量换算成每秒
+	bucketsPerSecond := float64(time.Second) / float64(b.window.bucketDuration)
+	return int64(math.Ceil(float64(maxPass) * bucketsPerSecond * minRT.Seconds()))
+}
+
+func (b *BBRLimiter) shouldDrop() bool {
+	now := b.clock.Now().UnixNano()
+	inFlight := b.inFlight.Load()
+	if math.Float64frombits(b.cpu.Load()) < b.cfg.CPUThreshold {
+		prevDrop := b.prevDrop.Load()
+		if prevDrop == 0 {
+			return false
+		}
+		if time.Duration(now-prevDrop) <= b.cfg.CoolDown {
+			return inFlight > 1 && inFlight > b.maxInFlight()
+		}
+		b.prevDrop.Store(0)
+		return false
+	}
+	drop := inFlight > 1 && inFlight > b.maxInFlight()
+	if drop {
+		b.prevDrop.Store(now)
+	}
+	return drop
+}
+
+func (b *BBRLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if b.shouldDrop() {
+			// 冷却时间内会继续丢弃请求，建议调用方等冷却结束再重试
+			return nil, reject.Error(reject.Info{RetryAfter: b.cfg.CoolDown})
+		}
+		b.inFlight.Add(1)
+		start := b.clock.Now()
+		defer func() {
+			b.inFlight.Add(-1)
+			b.window.add(b.clock.Since(start))
+		}()
+		resp, err = handler(ctx, req)
+		return
+	}
+}
+
+// rollingWindow 滑动窗口，每个桶记录完成的请求数和响应时间
+type rollingWindow struct {
+	mu             sync.Mutex
+	buckets        []bucket
+	bucketDuration time.Duration
+	clock          clock.Clock
+	// 当前桶的下标，以及当前桶的开始时间
+	cur      int
+	curStart time.Time
+}
+
+type bucket struct {
+	pass  int64
+	rtSum time.Duration
+}
+
+func newRollingWindow(window time.Duration, size int, clk clock.Clock) *rollingWindow {
+	return &rollingWindow{
+		buckets:        make([]bucket, size),
+		bucketDuration: window / time.Duration(size),
+		clock:          clk,
+		curStart:       clk.Now(),
+	}
+}
+
+// advance 把窗口滑动到当前时间，过期的桶清零，调用者要持有锁
+func (w *rollingWindow) advance(now time.Time) {
+	elapsed := int(now.Sub(w.curStart) / w.bucketDuration)
+	if elapsed <= 0 {
+		return
+	}
+	for i := 0; i < min(elapsed, len(w.buckets)); i++ {
+		w.cur = (w.cur + 1) % len(w.buckets)
+		w.buckets[w.cur] = bucket{}
+	}
+	w.curStart = w.curStart.Add(time.Duration(elapsed) * w.bucketDuration)
+}
+
+func (w *rollingWindow) add(rt time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(w.clock.Now())
+	w.buckets[w.cur].pass++
+	w.buckets[w.cur].rtSum += rt
+}
+
+// stats 返回单个桶的最大通过数，以及桶的最小平均响应时间
+// 当前桶还没结束，数据不完整，所以不参与统计
+func (w *rollingWindow) stats() (int64, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(w.clock.Now())
+	var maxPass int64
+	minRT := time.Duration(math.MaxInt64)
+	for i, b := range w.buckets {
+		if i == w.cur || b.pass == 0 {
+			continue
+		}
+		maxPass = max(maxPass, b.pass)
+		minRT = min(minRT, b.rtSum/time.Duration(b.pass))
+	}
+	// 没有数据的时候保守一点
+	if maxPass == 0 {
+		return 1, time.Millisecond
+	}
+	return maxPass, minRT
+}
@@ -0,0 +1,124 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case17/monitor"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"testing"
+	"time"
+)
+
+// 测试场景
+// MockMon 在启动 2s 之后 CPU 过载，5s 之后恢复。
+// 每个请求处理 20ms，窗口里面一个桶最多通过 1000 个请求，系统能够承载的并发数是 1000 × 20ms = 20
+func TestBBRLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	mon := monitor.NewMockMonitorWithClock(clk)
+	limiter := newBBRLimiter(mon, BBRConfig{
+		CPUThreshold: 80,
+		Window:       10 * time.Second,
+		Buckets:      10,
+		Interval:     100 * time.Millisecond,
+		CoolDown:     5 * time.Second,
+	}, clk)
+	interceptor := limiter.BuildServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.TestService/Test"}
+
+	// 依次发送 n 个请求，放行的请求会一直处理到调用 finish，
+	// 所以第 k 个请求到达的时候正在处理的请求数就是前面放行的数量
+	burst := func(n int) (passed, dropped int, finish func(rt time.Duration)) {
+		release := make(chan struct{})
+		entered := make(chan struct{})
+		results := make(chan error, n)
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			entered <- struct{}{}
+			<-release
+			return "ok", nil
+		}
+		for i := 0; i < n; i++ {
+			go func() {
+				_, err := interceptor(context.Background(), nil, info, handler)
+				results <- err
+			}()
+			select {
+			case <-entered:
+				passed++
+			case err := <-results:
+				assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+				retryAfter, ok := reject.RetryAfter(err)
+				assert.True(t, ok)
+				assert.Equal(t, 5*time.Second, retryAfter)
+				dropped++
+			}
+		}
+		return passed, dropped, func(rt time.Duration) {
+			clk.Advance(rt)
+			close(release)
+			for i := 0; i < passed; i++ {
+				require.NoError(t, <-results)
+			}
+		}
+	}
+
+	// CPU 没有过载，不管多少并发都不会拒绝
+	limiter.check()
+	passed, dropped, finish := burst(60)
+	assert.Equal(t, 60, passed)
+	assert.Equal(t, 0, dropped)
+	finish(20 * time.Millisecond)
+
+	// 预热，1s 到 2s 之间每次 25 个并发，一共通过 1000 个请求
+	clk.Advance(980 * time.Millisecond)
+	for i := 0; i < 40; i++ {
+		passed, _, finish = burst(25)
+		require.Equal(t, 25, passed)
+		finish(20 * time.Millisecond)
+	}
+
+	// CPU 过载，前 21 个请求到达的时候并发数都没有超过 20
+	clk.Advance(200 * time.Millisecond)
+	limiter.check()
+	assert.Equal(t, int64(20), limiter.maxInFlight())
+	passed, dropped, finish = burst(30)
+	assert.Equal(t, 21, passed)
+	assert.Equal(t, 9, dropped)
+	finish(20 * time.Millisecond)
+
+	// CPU 降下来了，但是还在冷却期，依旧按照并发数限流
+	clk.Advance(3080 * time.Millisecond)
+	limiter.check()
+	passed, dropped, finish = burst(30)
+	assert.Equal(t, 21, passed)
+	assert.Equal(t, 9, dropped)
+	finish(20 * time.Millisecond)
+
+	// 冷却期结束
+	clk.Advance(1980 * time.Millisecond)
+	limiter.check()
+	passed, dropped, finish = burst(30)
+	assert.Equal(t, 30, passed)
+	assert.Equal(t, 0, dropped)
+	finish(20 * time.Millisecond)
+}
+
+func TestBBRLimiter_Close(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	limiter := NewBBRLimiterWithClock(monitor.NewMockMonitorWithClock(clk), BBRConfig{
+		CPUThreshold: 80,
+		Window:       time.Second,
+		Buckets:      10,
+		Interval:     100 * time.Millisecond,
+		CoolDown:     time.Second,
+	}, clk)
+	clk.BlockUntil(1)
+	limiter.Close()
+	assert.Eventually(t, func() bool {
+		return clk.Waiters() == 0
+	}, time.Second, time.Millisecond)
+}
@@ -2,10 +2,11 @@ package interceptor
 
 import (
 	"context"
-	"errors"
 	"google.golang.org/grpc"
 	"interview-cases/case11_20/case17/monitor"
+	"interview-cases/reject"
 	"log/slog"
+	"math"
 	"sync/atomic"
 	"time"
 )
@@ -13,6 +14,8 @@ import (
 type MemoryLimiter struct {
 	// 状态 0-正常 1-限流状态
 	state int32
+	// 最近一次获取到的内存使用率
+	usage atomic.Uint64
 	// 获取监控数据的抽象
 	mon monitor.Monitor
 	// 间隔多久获取监控数据
@@ -41,6 +44,7 @@ func (m *MemoryLimiter) monitor() {
 				slog.Error("获取监控信息失败", slog.Any("err", err))
 				continue
 			}
+			m.usage.Store(math.Float64bits(usage))
 			// 超内存了
 			if usage >= 80 {
 				atomic.StoreInt32(&m.state, 1)
@@ -56,7 +60,7 @@ func (m *MemoryLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		if atomic.LoadInt32(&m.state) == 1 {
 			// 当前处于限流状态
-			return nil, errors.New("触发了限流")
+			return nil, reject.Error(reject.Info{RetryAfter: m.interval})
 		}
 		resp, err = handler(ctx, req)
 		return
@@ -0,0 +1,114 @@
+package interceptor
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"interview-cases/reject"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Priority 请求的优先级，通过 gRPC metadata 传递
+type Priority string
+
+const (
+	// PriorityCritical 核心请求，永远不会被丢弃
+	PriorityCritical Priority = "critical"
+	// PriorityDefault 没有设置优先级的请求都是这个
+	PriorityDefault Priority = "default"
+	// PrioritySheddable 可以被优先丢弃的请求，比如说预加载、统计上报
+	PrioritySheddable Priority = "sheddable"
+
+	PriorityKey = "x-request-priority"
+	// healthCheckMethod gRPC 标准的健康检查，丢弃它会导致实例被摘掉，反而加剧过载
+	healthCheckMethod = "/grpc.health.v1.Health/Check"
+)
+
+// WithPriority 客户端用来设置请求的优先级
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PriorityKey, string(priority))
+}
+
+func priorityFromCtx(ctx context.Context) Priority {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get(PriorityKey)
+	if len(vals) == 0 {
+		return PriorityDefault
+	}
+	switch p := Priority(vals[0]); p {
+	case PriorityCritical, PrioritySheddable:
+		return p
+	default:
+		return PriorityDefault
+	}
+}
+
+// DropRatio 丢弃的概率是 过载程度 × 系数，系数越大越早被丢弃
+type DropRatio struct {
+	Default   float64
+	Sheddable float64
+}
+
+// SheddingConfig 按比例丢弃的配置
+type SheddingConfig struct {
+	// 总是放行的方法，比如说下单这种核心方法
+	// 健康检查默认就会放行
+	CriticalMethods []string
+	// 没有单独配置的方法使用这个系数
+	Ratio DropRatio
+	// 每个方法单独的系数，key 是 FullMethod
+	MethodRatios map[string]DropRatio
+}
+
+// overload 过载程度，0 到 1 之间
+// 没有触发限流的时候是 0，触发之后按照内存使用率从恢复阈值到 100% 线性增长
+func (m *MemoryLimiter) overload() float64 {
+	if atomic.LoadInt32(&m.state) == 0 {
+		return 0
+	}
+	usage := math.Float64frombits(m.usage.Load())
+	return math.Max(0, math.Min(1, (usage-60)/(100-60)))
+}
+
+// BuildSheddingInterceptor 和 BuildServerInterceptor 一刀切地拒绝所有请求不同，
+// 它按照过载程度以一定的概率丢弃请求，并且优先丢弃低优先级的请求
+func (m *MemoryLimiter) BuildSheddingInterceptor(cfg SheddingConfig) grpc.UnaryServerInterceptor {
+	critical := make(map[string]struct{}, len(cfg.CriticalMethods)+1)
+	critical[healthCheckMethod] = struct{}{}
+	for _, method := range cfg.CriticalMethods {
+		critical[method] = struct{}{}
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		overload := m.overload()
+		if overload == 0 {
+			return handler(ctx, req)
+		}
+		if _, ok := critical[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
+		ratio, ok := cfg.MethodRatios[info.FullMethod]
+		if !ok {
+			ratio = cfg.Ratio
+		}
+		var dropRate float64
+		switch priorityFromCtx(ctx) {
+		case PriorityCritical:
+			return handler(ctx, req)
+		case PrioritySheddable:
+			dropRate = overload * ratio.Sheddable
+		default:
+			dropRate = overload * ratio.Default
+		}
+		if rand.Float64() < dropRate {
+			return nil, m.shedErr()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// shedErr 告诉客户端至少等一个监控周期再重试，在此之前过载状态不会有变化
+func (m *MemoryLimiter) shedErr() error {
+	return reject.Error(reject.Info{RetryAfter: m.interval, Description: "系统过载，请求被丢弃"})
+}
@@ -0,0 +1,78 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case17/monitor"
+	"interview-cases/reject"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter_BuildSheddingInterceptor(t *testing.T) {
+	mon := monitor.NewManualMonitor()
+	mon.SetMemoryUsage(50)
+	limiter := NewMemoryLimiter(mon, 10*time.Millisecond)
+	interceptor := limiter.BuildSheddingInterceptor(SheddingConfig{
+		CriticalMethods: []string{"/proto.OrderService/Pay"},
+		Ratio:           DropRatio{Default: 1, Sheddable: 2},
+		MethodRatios: map[string]DropRatio{
+			"/proto.TestService/Cheap": {Default: 0.5, Sheddable: 1},
+		},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	// 发送 1000 个请求，返回被丢弃的数量
+	dropped := func(method string, priority Priority) int {
+		ctx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(PriorityKey, string(priority)))
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		cnt := 0
+		for i := 0; i < 1000; i++ {
+			_, err := interceptor(ctx, nil, info, handler)
+			if err != nil {
+				cnt++
+			}
+		}
+		return cnt
+	}
+
+	// 没有过载
+	assert.Equal(t, 0, dropped("/proto.TestService/Test", PrioritySheddable))
+
+	// 内存使用率 90%，过载程度是 (90-60)/(100-60)=0.75
+	mon.SetMemoryUsage(90)
+	time.Sleep(50 * time.Millisecond)
+
+	// 普通请求丢弃 75% 左右，低优先级的全部丢弃，核心请求全部放行
+	assert.InDelta(t, 750, dropped("/proto.TestService/Test", PriorityDefault), 60)
+	assert.Equal(t, 1000, dropped("/proto.TestService/Test", PrioritySheddable))
+	assert.Equal(t, 0, dropped("/proto.TestService/Test", PriorityCritical))
+	// 健康检查和核心方法不管优先级都放行
+	assert.Equal(t, 0, dropped("/grpc.health.v1.Health/Check", PrioritySheddable))
+	assert.Equal(t, 0, dropped("/proto.OrderService/Pay", PrioritySheddable))
+	// 单独配置了系数的方法
+	assert.InDelta(t, 375, dropped("/proto.TestService/Cheap", PriorityDefault), 60)
+	assert.InDelta(t, 750, dropped("/proto.TestService/Cheap", PrioritySheddable), 60)
+
+	// 被丢弃的请求带上了重试的建议
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(PriorityKey, string(PrioritySheddable)))
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/proto.TestService/Test"}, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	retryAfter, ok := reject.RetryAfter(err)
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, retryAfter)
+
+	// 恢复
+	mon.SetMemoryUsage(50)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, dropped("/proto.TestService/Test", PrioritySheddable))
+}
@@ -2,21 +2,31 @@ package monitor
 
 import (
 	"context"
+	"interview-cases/clock"
+	"runtime"
+	"sync"
 	"time"
 )
 
 type MockMon struct {
 	startTime int64
+	clock     clock.Clock
 }
 
 func NewMockMonitor() *MockMon {
+	return NewMockMonitorWithClock(clock.New())
+}
+
+// NewMockMonitorWithClock 过载的时间段按照 clk 计算
+func NewMockMonitorWithClock(clk clock.Clock) *MockMon {
 	return &MockMon{
-		startTime: time.Now().UnixMilli(),
+		startTime: clk.Now().UnixMilli(),
+		clock:     clk,
 	}
 }
 
 func (m *MockMon) GetMemoryUsage(ctx context.Context) (float64, error) {
-	nowTime := time.Now().UnixMilli()
+	nowTime := m.clock.Now().UnixMilli()
 	// 2秒后超过80%
 	intervalTime := nowTime - m.startTime
 	if intervalTime >= 2000 && intervalTime <= 5000 {
@@ -24,3 +34,80 @@ func (m *MockMon) GetMemoryUsage(ctx context.Context) (float64, error) {
 	}
 	return 50, nil
 }
+
+func (m *MockMon) GetCPUUsage(ctx context.Context) (float64, error) {
+	// 和内存保持一致，2秒后超过80%
+	intervalTime := m.clock.Now().UnixMilli() - m.startTime
+	if intervalTime >= 2000 && intervalTime <= 5000 {
+		return 95, nil
+	}
+	return 30, nil
+}
+
+func (m *MockMon) GetGoroutineCount(ctx context.Context) (int, error) {
+	return runtime.NumGoroutine(), nil
+}
+
+func (m *MockMon) GetGCPause(ctx context.Context) (time.Duration, error) {
+	return time.Millisecond, nil
+}
+
+func (m *MockMon) GetInFlight(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// ManualMon 手动设置监控数据，方便测试
+type ManualMon struct {
+	mu         sync.RWMutex
+	memory     float64
+	cpu        float64
+	goroutines int
+	gcPause    time.Duration
+	inFlight   int64
+}
+
+func NewManualMonitor() *ManualMon {
+	return &ManualMon{}
+}
+
+func (m *ManualMon) SetMemoryUsage(usage float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memory = usage
+}
+
+func (m *ManualMon) SetCPUUsage(usage float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cpu = usage
+}
+
+func (m *ManualMon) GetMemoryUsage(ctx context.Context) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.memory, nil
+}
+
+func (m *ManualMon) GetCPUUsage(ctx context.Context) (float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cpu, nil
+}
+
+func (m *ManualMon) GetGoroutineCount(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.goroutines, nil
+}
+
+func (m *ManualMon) GetGCPause(ctx context.Context) (time.Duration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.gcPause, nil
+}
+
+func (m *ManualMon) GetInFlight(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.inFlight, nil
+}
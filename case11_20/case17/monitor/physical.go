@@ -2,11 +2,17 @@ package monitor
 
 import (
 	"context"
+	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
+	"google.golang.org/grpc"
+	"runtime"
+	"sync/atomic"
+	"time"
 )
 
 // PhysicalMonitor 从物理机直接获取监控信息
 type PhysicalMonitor struct {
+	inFlight atomic.Int64
 }
 
 func NewPhysicalMonitor() *PhysicalMonitor {
@@ -20,3 +26,43 @@ func (p *PhysicalMonitor) GetMemoryUsage(ctx context.Context) (float64, error) {
 	}
 	return v.UsedPercent, nil
 }
+
+// GetCPUUsage 返回的是距离上一次调用这段时间内的 CPU 使用率
+// 第一次调用的时候没有基准，返回的是开机以来的平均值
+func (p *PhysicalMonitor) GetCPUUsage(ctx context.Context) (float64, error) {
+	vals, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(vals) == 0 {
+		return 0, nil
+	}
+	return vals[0], nil
+}
+
+func (p *PhysicalMonitor) GetGoroutineCount(ctx context.Context) (int, error) {
+	return runtime.NumGoroutine(), nil
+}
+
+func (p *PhysicalMonitor) GetGCPause(ctx context.Context) (time.Duration, error) {
+	var stats runtime.MemStats
+	// ReadMemStats 本身会 STW，所以不要调用得太频繁
+	runtime.ReadMemStats(&stats)
+	if stats.NumGC == 0 {
+		return 0, nil
+	}
+	return time.Duration(stats.PauseNs[(stats.NumGC+255)%256]), nil
+}
+
+func (p *PhysicalMonitor) GetInFlight(ctx context.Context) (int64, error) {
+	return p.inFlight.Load(), nil
+}
+
+// BuildServerInterceptor 统计正在处理的请求数，要注册到 gRPC 服务端才能拿到 GetInFlight
+func (p *PhysicalMonitor) BuildServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p.inFlight.Add(1)
+		defer p.inFlight.Add(-1)
+		return handler(ctx, req)
+	}
+}
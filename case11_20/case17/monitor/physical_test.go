@@ -13,3 +13,19 @@ func TestPhysicalMonitor_GetMemoryUsage(t *testing.T) {
 	require.NoError(t, err)
 	fmt.Printf("当前物理机的内存使用率为 %.2f%%\n", usage)
 }
+
+func TestPhysicalMonitor_Runtime(t *testing.T) {
+	monitor := NewPhysicalMonitor()
+	ctx := context.Background()
+	cpuUsage, err := monitor.GetCPUUsage(ctx)
+	require.NoError(t, err)
+	goroutines, err := monitor.GetGoroutineCount(ctx)
+	require.NoError(t, err)
+	require.True(t, goroutines > 0)
+	pause, err := monitor.GetGCPause(ctx)
+	require.NoError(t, err)
+	inFlight, err := monitor.GetInFlight(ctx)
+	require.NoError(t, err)
+	fmt.Printf("CPU 使用率 %.2f%%，goroutine 数量 %d，最近一次 GC 暂停 %v，正在处理的请求 %d\n",
+		cpuUsage, goroutines, pause, inFlight)
+}
@@ -1,8 +1,19 @@
 package monitor
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // 用于监控内存的接口
 type Monitor interface {
 	GetMemoryUsage(ctx context.Context) (float64, error)
+	// GetCPUUsage CPU 使用率，百分比
+	GetCPUUsage(ctx context.Context) (float64, error)
+	// GetGoroutineCount 当前的 goroutine 数量
+	GetGoroutineCount(ctx context.Context) (int, error)
+	// GetGCPause 最近一次 GC 的暂停时间
+	GetGCPause(ctx context.Context) (time.Duration, error)
+	// GetInFlight 正在处理的请求数
+	GetInFlight(ctx context.Context) (int64, error)
 }
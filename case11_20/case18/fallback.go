@@ -0,0 +1,232 @@
+package case18
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"interview-cases/clock"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InstanceCounter 获取集群里面有多少个实例
+// 降级之后每个实例只能分到 limit / 实例数 的额度
+type InstanceCounter interface {
+	Count(ctx context.Context) (int, error)
+}
+
+// StaticInstanceCounter 配置好的实例数量
+type StaticInstanceCounter int
+
+func (s StaticInstanceCounter) Count(ctx context.Context) (int, error) {
+	return int(s), nil
+}
+
+// RedisInstanceCounter 每个实例定时往 Redis 的有序集合里面上报心跳，
+// 最近三个心跳周期内上报过的实例就认为是存活的。
+// Redis 不可用的时候返回最后一次拿到的数量，这恰好就是降级的时候需要的
+type RedisInstanceCounter struct {
+	client   redis.Cmdable
+	key      string
+	instance string
+	interval time.Duration
+	count    atomic.Int64
+	stop     chan struct{}
+	closeErr error
+	once     sync.Once
+}
+
+func NewRedisInstanceCounter(client redis.Cmdable, key, instance string, interval time.Duration) *RedisInstanceCounter {
+	c := &RedisInstanceCounter{
+		client:   client,
+		key:      key,
+		instance: instance,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	c.count.Store(1)
+	c.heartbeat()
+	go c.loop()
+	return c
+}
+
+func (c *RedisInstanceCounter) loop() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.heartbeat()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close 停止上报心跳，并且把自己从 Redis 里面删掉，其它实例下一次心跳的时候就能看到
+// 多次调用返回第一次的结果
+func (c *RedisInstanceCounter) Close() error {
+	c.once.Do(func() {
+		close(c.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c.closeErr = c.client.ZRem(ctx, c.key, c.instance).Err()
+	})
+	return c.closeErr
+}
+
+func (c *RedisInstanceCounter) heartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	now := time.Now().UnixMilli()
+	expired := now - 3*c.interval.Milliseconds()
+	pipe := c.client.TxPipeline()
+	pipe.ZAdd(ctx, c.key, redis.Z{Score: float64(now), Member: c.instance})
+	pipe.ZRemRangeByScore(ctx, c.key, "-inf", strconv.FormatInt(expired, 10))
+	card := pipe.ZCard(ctx, c.key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("上报实例心跳失败", slog.Any("err", err))
+		return
+	}
+	c.count.Store(max(card.Val(), 1))
+}
+
+func (c *RedisInstanceCounter) Count(ctx context.Context) (int, error) {
+	return int(c.count.Load()), nil
+}
+
+// AllowResult 限流的结果
+type AllowResult struct {
+	Allowed bool
+	// 为 true 说明 Redis 不可用，这个结果是本地限流器给出的
+	Degraded bool
+}
+
+// FallbackLimiter Redis 不可用的时候降级为本地限流
+// 降级之后后台会定时探活，连续 recoverThreshold 次成功才切回 Redis，避免 Redis 抖动的时候反复切换
+type FallbackLimiter struct {
+	limiter *Limiter
+	counter InstanceCounter
+	local   *localLimiter
+	clock   clock.Clock
+
+	degraded         atomic.Bool
+	checkInterval    time.Duration
+	recoverThreshold int
+}
+
+func NewFallbackLimiter(limiter *Limiter, counter InstanceCounter,
+	checkInterval time.Duration, recoverThreshold int) *FallbackLimiter {
+	return NewFallbackLimiterWithClock(limiter, counter, checkInterval, recoverThreshold, clock.New())
+}
+
+// NewFallbackLimiterWithClock 本地限流的窗口和探活的间隔由 clk 决定
+func NewFallbackLimiterWithClock(limiter *Limiter, counter InstanceCounter,
+	checkInterval time.Duration, recoverThreshold int, clk clock.Clock) *FallbackLimiter {
+	return &FallbackLimiter{
+		limiter:          limiter,
+		counter:          counter,
+		local:            newLocalLimiter(limiter.window, clk),
+		clock:            clk,
+		checkInterval:    checkInterval,
+		recoverThreshold: recoverThreshold,
+	}
+}
+
+// Allow Redis 出错的时候不会返回 error，而是用本地限流器给出结果
+// 调用者的 ctx 被取消或者超时了不是 Redis 的问题，直接返回 ctx 的错误，不降级
+func (f *FallbackLimiter) Allow(ctx context.Context) (AllowResult, error) {
+	if !f.degraded.Load() {
+		ok, err := f.limiter.Allow(ctx)
+		if err == nil {
+			return AllowResult{Allowed: ok}, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return AllowResult{}, ctxErr
+		}
+		slog.Error("Redis 限流失败，降级为本地限流", slog.Any("err", err))
+		f.degrade()
+	}
+	return AllowResult{Allowed: f.local.allow(f.localLimit(ctx)), Degraded: true}, nil
+}
+
+// Degraded 当前是否处于降级状态
+func (f *FallbackLimiter) Degraded() bool {
+	return f.degraded.Load()
+}
+
+func (f *FallbackLimiter) localLimit(ctx context.Context) int {
+	cnt, err := f.counter.Count(ctx)
+	if err != nil || cnt <= 0 {
+		// 不知道有多少个实例，那就保守一点，按照一个实例来算
+		cnt = 1
+	}
+	return max(f.limiter.limit/cnt, 1)
+}
+
+func (f *FallbackLimiter) degrade() {
+	// 只有第一个发现 Redis 不可用的请求负责启动探活
+	if f.degraded.CompareAndSwap(false, true) {
+		go f.healthCheck()
+	}
+}
+
+func (f *FallbackLimiter) healthCheck() {
+	ticker := f.clock.NewTicker(f.checkInterval)
+	defer ticker.Stop()
+	successCnt := 0
+	for range ticker.C() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := f.limiter.client.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			successCnt = 0
+			continue
+		}
+		successCnt++
+		if successCnt >= f.recoverThreshold {
+			slog.Info("Redis 恢复，切回 Redis 限流")
+			f.local.reset()
+			f.degraded.Store(false)
+			return
+		}
+	}
+}
+
+// localLimiter 本地的固定窗口计数器，只在降级的时候用
+// 只记一个计数，不管阈值多大都不会占用更多的内存，窗口边界上可能放过两倍的流量，对于降级来说可以接受
+type localLimiter struct {
+	mu     sync.Mutex
+	window time.Duration
+	clock  clock.Clock
+	start  time.Time
+	cnt    int
+}
+
+func newLocalLimiter(window time.Duration, clk clock.Clock) *localLimiter {
+	return &localLimiter{window: window, clock: clk}
+}
+
+func (l *localLimiter) allow(limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.cnt = 0
+	}
+	if l.cnt >= limit {
+		return false
+	}
+	l.cnt++
+	return true
+}
+
+func (l *localLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start = time.Time{}
+	l.cnt = 0
+}
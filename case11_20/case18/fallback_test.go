@@ -0,0 +1,152 @@
+package case18
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/clock"
+	"interview-cases/test"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// brokenRedis 模拟 Redis 崩溃
+type brokenRedis struct {
+	redis.Cmdable
+	broken atomic.Bool
+}
+
+func (b *brokenRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	if b.broken.Load() {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(errors.New("Redis 崩溃了"))
+		return cmd
+	}
+	return b.Cmdable.Eval(ctx, script, keys, args...)
+}
+
+func (b *brokenRedis) Ping(ctx context.Context) *redis.StatusCmd {
+	if b.broken.Load() {
+		cmd := redis.NewStatusCmd(ctx)
+		cmd.SetErr(errors.New("Redis 崩溃了"))
+		return cmd
+	}
+	return b.Cmdable.Ping(ctx)
+}
+
+func TestFallbackLimiter_Allow(t *testing.T) {
+	rdb := &brokenRedis{Cmdable: test.InitRedis()}
+	key := "case18/fallback"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer rdb.Del(ctx, key)
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	// 集群阈值 6，三个实例，降级之后每个实例只能通过 2 个
+	limiter := NewFallbackLimiterWithClock(
+		NewLimiterWithAlgorithm(rdb, AlgorithmSlidingLog, time.Minute, 6, key),
+		StaticInstanceCounter(3), 50*time.Millisecond, 3, clk)
+
+	res, err := limiter.Allow(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, AllowResult{Allowed: true}, res)
+
+	rdb.broken.Store(true)
+	for i := 0; i < 2; i++ {
+		res, err = limiter.Allow(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, AllowResult{Allowed: true, Degraded: true}, res)
+	}
+	res, err = limiter.Allow(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, AllowResult{Allowed: false, Degraded: true}, res)
+
+	// Redis 没恢复之前一直是降级的
+	clk.BlockUntil(1)
+	for i := 0; i < 5; i++ {
+		clk.Advance(50 * time.Millisecond)
+	}
+	assert.True(t, limiter.Degraded())
+
+	// Redis 恢复之后，要连续三次探活成功才会切回去
+	rdb.broken.Store(false)
+	assert.True(t, limiter.Degraded())
+	assert.Eventually(t, func() bool {
+		clk.Advance(50 * time.Millisecond)
+		return !limiter.Degraded()
+	}, time.Second, 10*time.Millisecond)
+	// 探活的 goroutine 退出了
+	assert.Eventually(t, func() bool {
+		return clk.Waiters() == 0
+	}, time.Second, time.Millisecond)
+	res, err = limiter.Allow(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, AllowResult{Allowed: true}, res)
+}
+
+func TestFallbackLimiter_ContextError(t *testing.T) {
+	rdb := &brokenRedis{Cmdable: test.InitRedis()}
+	key := "case18/fallback_ctx"
+	limiter := NewFallbackLimiter(
+		NewLimiterWithAlgorithm(rdb, AlgorithmSlidingLog, time.Minute, 6, key),
+		StaticInstanceCounter(3), 50*time.Millisecond, 3)
+
+	// 调用者自己取消了，不是 Redis 的问题，不能降级
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := limiter.Allow(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, limiter.Degraded())
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = limiter.Allow(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, limiter.Degraded())
+}
+
+func TestRedisInstanceCounter(t *testing.T) {
+	rdb := test.InitRedis()
+	key := "case18/instances"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer rdb.Del(ctx, key)
+	c1 := NewRedisInstanceCounter(rdb, key, "instance-1", time.Minute)
+	defer c1.Close()
+	c2 := NewRedisInstanceCounter(rdb, key, "instance-2", time.Minute)
+	// c1 下一次心跳之后才能看到 instance-2
+	c1.heartbeat()
+	cnt, err := c1.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cnt)
+
+	// instance-2 下线之后，c1 下一次心跳就看不到它了
+	require.NoError(t, c2.Close())
+	// 重复关闭不会 panic
+	require.NoError(t, c2.Close())
+	c1.heartbeat()
+	cnt, err = c1.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+}
+
+func TestLocalLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	l := newLocalLimiter(time.Second, clk)
+	for i := 0; i < 2; i++ {
+		assert.True(t, l.allow(2))
+	}
+	assert.False(t, l.allow(2))
+	clk.Advance(999 * time.Millisecond)
+	assert.False(t, l.allow(2))
+	// 新的窗口
+	clk.Advance(time.Millisecond)
+	assert.True(t, l.allow(2))
+	assert.True(t, l.allow(2))
+	assert.False(t, l.allow(2))
+
+	l.reset()
+	assert.True(t, l.allow(2))
+}
@@ -13,24 +13,42 @@ var (
 )
 
 type Limiter struct {
-	client redis.Cmdable
-	window time.Duration
-	limit  int
-	key    string
+	client    redis.Cmdable
+	window    time.Duration
+	limit     int
+	key       string
+	algorithm Algorithm
 }
 
 func NewLimiter(client redis.Cmdable,
+	window time.Duration,
+	limit int, key string) *Limiter {
+	return NewLimiterWithAlgorithm(client, AlgorithmSlidingList, window, limit, key)
+}
+
+// NewLimiterWithAlgorithm 指定限流算法
+func NewLimiterWithAlgorithm(client redis.Cmdable,
+	algorithm Algorithm,
 	window time.Duration,
 	limit int, key string) *Limiter {
 	return &Limiter{
-		client: client,
-		window: window,
-		limit:  limit,
-		key:    key,
+		client:    client,
+		window:    window,
+		limit:     limit,
+		key:       key,
+		algorithm: algorithm,
 	}
 }
 
 func (l Limiter) Allow(ctx context.Context) (bool, error) {
+	if l.algorithm != AlgorithmSlidingList {
+		// 其他算法都和 MultiLimiter 共用一个脚本，这里只有一条规则
+		return evalRules(ctx, l.client, []Rule{{
+			Algorithm: l.algorithm,
+			Window:    l.window,
+			Limit:     l.limit,
+		}}, []string{l.key})
+	}
 	now := time.Now().UnixMilli()
 	val, err := l.client.Eval(ctx, script, []string{l.key},
 		l.limit, l.window.Milliseconds(), now).Int()
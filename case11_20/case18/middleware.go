@@ -0,0 +1,121 @@
+package case18
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"interview-cases/reject"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// GinKeyFunc 从 HTTP 请求中提取限流的维度
+type GinKeyFunc func(c *gin.Context) string
+
+// GinByIP 按照 IP 限流
+func GinByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// GinByRoute 按照接口限流
+func GinByRoute(c *gin.Context) string {
+	return "route:" + c.Request.Method + ":" + c.FullPath()
+}
+
+// GinByHeader 按照请求头限流，比如说请求头里面的用户 ID
+func GinByHeader(header string) GinKeyFunc {
+	return func(c *gin.Context) string {
+		return "user:" + c.GetHeader(header)
+	}
+}
+
+// BuildGinMiddleware 每个请求拿一个令牌，拿不到就返回 429
+// 通过的请求也会带上 RateLimit-* 头部，让调用方知道还剩多少令牌
+// Redis 出错的时候放行，不能因为限流器出问题而影响业务
+func (l *TokenBucketLimiter) BuildGinMiddleware(keyFunc GinKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		res, err := l.AllowN(c.Request.Context(), key, 1)
+		if err != nil {
+			slog.Error("限流器出错", slog.Any("err", err))
+			c.Next()
+			return
+		}
+		info := l.rejectInfo(key, res)
+		if !res.Allowed {
+			reject.AbortGin(c, info)
+			return
+		}
+		reject.SetHeaders(c.Writer.Header(), info)
+		c.Next()
+	}
+}
+
+// GrpcKeyFunc 从 gRPC 请求中提取限流的维度
+type GrpcKeyFunc func(ctx context.Context, info *grpc.UnaryServerInfo) string
+
+// GrpcByPeer 按照调用者的 IP 限流
+func GrpcByPeer(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "ip:"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+// GrpcByMethod 按照方法限流
+func GrpcByMethod(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	return "method:" + info.FullMethod
+}
+
+// GrpcByMetadata 按照 metadata 限流，比如说 metadata 里面的用户 ID
+func GrpcByMetadata(key string) GrpcKeyFunc {
+	return func(ctx context.Context, info *grpc.UnaryServerInfo) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get(key)
+		if len(vals) == 0 {
+			return "user:"
+		}
+		return "user:" + vals[0]
+	}
+}
+
+// BuildServerInterceptor 每个请求拿一个令牌，拿不到就返回 codes.ResourceExhausted
+// Redis 出错的时候放行
+func (l *TokenBucketLimiter) BuildServerInterceptor(keyFunc GrpcKeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := keyFunc(ctx, info)
+		res, err := l.AllowN(ctx, key, 1)
+		if err != nil {
+			slog.Error("限流器出错", slog.Any("err", err))
+			return handler(ctx, req)
+		}
+		if !res.Allowed {
+			return nil, reject.Error(l.rejectInfo(key, res))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// rejectInfo 令牌桶没有固定的窗口，桶重新装满的时间就是配额重置的时间
+func (l *TokenBucketLimiter) rejectInfo(key string, res TokenBucketResult) reject.Info {
+	info := reject.Info{
+		Limit:     l.capacity,
+		Remaining: res.Remaining,
+		Subject:   key,
+	}
+	if l.rate > 0 {
+		info.Reset = time.Duration(l.capacity-res.Remaining) * time.Second / time.Duration(l.rate)
+	}
+	if res.RetryAfter > 0 {
+		info.RetryAfter = res.RetryAfter
+	}
+	return info
+}
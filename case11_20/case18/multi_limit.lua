@@ -0,0 +1,127 @@
+-- 一次检查多条限流规则，只有全部通过才会计数
+-- KEYS 是每条规则对应的 key
+-- ARGV[1] 当前时间戳，毫秒，要求服务端传过来，理由见 slide_window.lua
+-- ARGV[2] 本次请求的唯一标识，用作有序集合的 member
+-- 之后每条规则四个参数：算法，阈值，窗口大小（毫秒），子窗口数量
+local now = tonumber(ARGV[1])
+local member = ARGV[2]
+
+local SLIDING_LOG = 1
+local SLIDING_WINDOW_COUNTER = 2
+local GCRA = 3
+local FIXED_WINDOW = 4
+
+-- 滑动日志：有序集合里面每个请求一条记录，score 是时间戳，精确但是内存和阈值成正比
+local function checkSlidingLog(key, limit, window)
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+    return redis.call('ZCARD', key) < limit
+end
+
+local function commitSlidingLog(key, limit, window)
+    redis.call('ZADD', key, now, member)
+    redis.call('PEXPIRE', key, window)
+end
+
+-- 滑动窗口计数器：把窗口分成 buckets 个子窗口，每个子窗口一个计数，放在哈希里面
+-- 内存只和子窗口数量有关，代价是精度只到子窗口级别
+local function checkSlidingWindowCounter(key, limit, window, buckets)
+    local width = window / buckets
+    local current = math.floor(now / width)
+    local fields = redis.call('HGETALL', key)
+    local total = 0
+    for i = 1, #fields, 2 do
+        local idx = tonumber(fields[i])
+        if idx <= current - buckets then
+            -- 子窗口已经滑出去了
+            redis.call('HDEL', key, fields[i])
+        else
+            total = total + tonumber(fields[i + 1])
+        end
+    end
+    return total < limit
+end
+
+local function commitSlidingWindowCounter(key, limit, window, buckets)
+    local width = window / buckets
+    local current = math.floor(now / width)
+    redis.call('HINCRBY', key, current, 1)
+    redis.call('PEXPIRE', key, math.ceil(window + width))
+end
+
+-- GCRA：只记录一个理论到达时间 tat，每个请求把 tat 往后推一个发射间隔
+-- tat 超前当前时间不超过一个窗口，就说明窗口内的请求没有超过阈值
+local function checkGCRA(key, limit, window)
+    local tat = tonumber(redis.call('GET', key))
+    if tat == nil or tat < now then
+        tat = now
+    end
+    return tat + window / limit - now <= window
+end
+
+local function commitGCRA(key, limit, window)
+    local tat = tonumber(redis.call('GET', key))
+    if tat == nil or tat < now then
+        tat = now
+    end
+    local newTat = tat + window / limit
+    redis.call('SET', key, tostring(newTat), 'PX', math.ceil(newTat - now))
+end
+
+-- 固定窗口：记录当前窗口的编号和计数，窗口变了就从零开始
+local function checkFixedWindow(key, limit, window)
+    local current = math.floor(now / window)
+    local vals = redis.call('HMGET', key, 'win', 'cnt')
+    if tonumber(vals[1]) ~= current then
+        return true
+    end
+    return tonumber(vals[2]) < limit
+end
+
+local function commitFixedWindow(key, limit, window)
+    local current = math.floor(now / window)
+    local vals = redis.call('HMGET', key, 'win', 'cnt')
+    local cnt = 0
+    if tonumber(vals[1]) == current then
+        cnt = tonumber(vals[2])
+    end
+    redis.call('HSET', key, 'win', current, 'cnt', cnt + 1)
+    redis.call('PEXPIRE', key, window)
+end
+
+local checks = {
+    [SLIDING_LOG] = checkSlidingLog,
+    [SLIDING_WINDOW_COUNTER] = checkSlidingWindowCounter,
+    [GCRA] = checkGCRA,
+    [FIXED_WINDOW] = checkFixedWindow,
+}
+local commits = {
+    [SLIDING_LOG] = commitSlidingLog,
+    [SLIDING_WINDOW_COUNTER] = commitSlidingWindowCounter,
+    [GCRA] = commitGCRA,
+    [FIXED_WINDOW] = commitFixedWindow,
+}
+
+local function rule(i)
+    local base = 2 + (i - 1) * 4
+    return tonumber(ARGV[base + 1]), tonumber(ARGV[base + 2]),
+    tonumber(ARGV[base + 3]), tonumber(ARGV[base + 4])
+end
+
+for i = 1, #KEYS do
+    local algorithm, limit, window, buckets = rule(i)
+    local check = checks[algorithm]
+    if check == nil then
+        return redis.error_reply('未知的限流算法 ' .. tostring(algorithm))
+    end
+    if not check(KEYS[i], limit, window, buckets) then
+        -- 返回第几条规则触发了限流
+        return i
+    end
+end
+
+for i = 1, #KEYS do
+    local algorithm, limit, window, buckets = rule(i)
+    commits[algorithm](KEYS[i], limit, window, buckets)
+end
+-- 全部通过
+return 0
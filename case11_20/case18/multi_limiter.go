@@ -0,0 +1,119 @@
+package case18
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	//go:embed multi_limit.lua
+	multiLimitScript string
+
+	ErrMissingDimension = errors.New("缺少限流维度")
+
+	// 不用 {name}，花括号在 Redis Cluster 里面是 hash tag
+	dimensionPattern = regexp.MustCompile(`<(\w+)>`)
+)
+
+// Algorithm 限流算法
+type Algorithm uint8
+
+const (
+	// AlgorithmSlidingList 最早的实现，list 里面每个请求一条记录，淘汰的时候循环 LPOP
+	// 内存和 CPU 都和阈值成正比，只在 Limiter 里面使用
+	AlgorithmSlidingList Algorithm = iota
+	// AlgorithmSlidingLog 有序集合实现的滑动日志，精确，但是内存依旧和阈值成正比
+	AlgorithmSlidingLog
+	// AlgorithmSlidingWindowCounter 带子窗口的滑动窗口计数器，近似，内存只和子窗口数量有关
+	AlgorithmSlidingWindowCounter
+	// AlgorithmGCRA 通用信元速率算法，每个 key 只存一个时间戳
+	AlgorithmGCRA
+	// AlgorithmFixedWindow 固定窗口，最省资源，但是窗口边界上可能放过两倍的流量
+	AlgorithmFixedWindow
+)
+
+// defaultBuckets 滑动窗口计数器默认的子窗口数量
+const defaultBuckets = 10
+
+// Rule 一条限流规则
+type Rule struct {
+	Algorithm Algorithm
+	// Key 的模板，用 <name> 表示维度，比如说 user:<uid>:api:<api>
+	// 所有规则的 key 是在一个 Lua 脚本里面操作的，在 Redis Cluster 下必须落在同一个槽，
+	// 不然会返回 CROSSSLOT 错误。所以集群部署的时候，每条规则的模板都要带上同一个 hash tag，
+	// 比如说 {<uid>}:user 和 {<uid>}:api:<api>，或者是固定的 {limiter}:user:<uid>，
+	// 后者所有的限流都会落在同一个节点上，要注意热点
+	Key    string
+	Window time.Duration
+	Limit  int
+	// Buckets 滑动窗口计数器的子窗口数量，不设置就是 10
+	Buckets int
+}
+
+// key 用维度填充 key 模板
+func (r Rule) key(dims map[string]string) (string, error) {
+	var err error
+	key := dimensionPattern.ReplaceAllStringFunc(r.Key, func(s string) string {
+		name := s[1 : len(s)-1]
+		val, ok := dims[name]
+		if !ok {
+			err = fmt.Errorf("%w %s, key 模板 %s", ErrMissingDimension, name, r.Key)
+		}
+		return val
+	})
+	return key, err
+}
+
+// MultiLimiter 多条规则组合的限流器
+// 比如说同时限制单个用户每秒 10 次，单个接口每分钟 10 万次
+type MultiLimiter struct {
+	client redis.Cmdable
+	rules  []Rule
+}
+
+func NewMultiLimiter(client redis.Cmdable, rules ...Rule) *MultiLimiter {
+	return &MultiLimiter{
+		client: client,
+		rules:  rules,
+	}
+}
+
+// Allow 一次 Lua 调用检查所有的规则，只有全部通过才会计数
+// 这样被某条规则拒绝的请求，不会占用其他规则的额度
+func (m *MultiLimiter) Allow(ctx context.Context, dims map[string]string) (bool, error) {
+	keys := make([]string, 0, len(m.rules))
+	for _, r := range m.rules {
+		key, err := r.key(dims)
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, key)
+	}
+	return evalRules(ctx, m.client, m.rules, keys)
+}
+
+// evalRules keys 是已经填充好维度的 key，和 rules 一一对应
+func evalRules(ctx context.Context, client redis.Cmdable, rules []Rule, keys []string) (bool, error) {
+	now := time.Now().UnixMilli()
+	args := make([]any, 0, 2+len(rules)*4)
+	args = append(args, now, strconv.FormatInt(now, 10)+"-"+strconv.FormatUint(rand.Uint64(), 36))
+	for _, r := range rules {
+		buckets := r.Buckets
+		if buckets <= 0 {
+			buckets = defaultBuckets
+		}
+		args = append(args, int(r.Algorithm), r.Limit, r.Window.Milliseconds(), buckets)
+	}
+	val, err := client.Eval(ctx, multiLimitScript, keys, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return val == 0, nil
+}
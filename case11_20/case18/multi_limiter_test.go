@@ -0,0 +1,95 @@
+package case18
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Algorithms(t *testing.T) {
+	rdb := test.InitRedis()
+	testCases := []struct {
+		name      string
+		algorithm Algorithm
+	}{
+		{name: "滑动日志", algorithm: AlgorithmSlidingLog},
+		{name: "滑动窗口计数器", algorithm: AlgorithmSlidingWindowCounter},
+		{name: "GCRA", algorithm: AlgorithmGCRA},
+		{name: "固定窗口", algorithm: AlgorithmFixedWindow},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := fmt.Sprintf("case18/limiter_algorithm_%d", tc.algorithm)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			defer rdb.Del(ctx, key)
+			window := 500 * time.Millisecond
+			limiter := NewLimiterWithAlgorithm(rdb, tc.algorithm, window, 3, key)
+			for i := 0; i < 3; i++ {
+				ok, err := limiter.Allow(ctx)
+				require.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, err := limiter.Allow(ctx)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			// 过了一个窗口之后，又可以通过了
+			time.Sleep(window + 100*time.Millisecond)
+			ok, err = limiter.Allow(ctx)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestMultiLimiter_Allow(t *testing.T) {
+	rdb := test.InitRedis()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer rdb.Del(ctx, "{case18/multi}/user:1", "{case18/multi}/user:2", "{case18/multi}/api:/hello")
+	// 用同一个 hash tag，在 Redis Cluster 下所有的 key 也在同一个槽
+	limiter := NewMultiLimiter(rdb,
+		// 单个用户一分钟 2 次
+		Rule{Algorithm: AlgorithmSlidingWindowCounter, Key: "{case18/multi}/user:<uid>", Window: time.Minute, Limit: 2},
+		// 单个接口一分钟 3 次
+		Rule{Algorithm: AlgorithmGCRA, Key: "{case18/multi}/api:<api>", Window: time.Minute, Limit: 3},
+	)
+	user1 := map[string]string{"uid": "1", "api": "/hello"}
+	user2 := map[string]string{"uid": "2", "api": "/hello"}
+
+	for i := 0; i < 2; i++ {
+		ok, err := limiter.Allow(ctx, user1)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	// 用户 1 被用户维度的规则拒绝，而且不会占用接口维度的额度
+	ok, err := limiter.Allow(ctx, user1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = limiter.Allow(ctx, user2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	// 接口维度用完了，用户 2 虽然还有额度，也会被拒绝
+	ok, err = limiter.Allow(ctx, user2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = limiter.Allow(ctx, map[string]string{"uid": "1"})
+	assert.ErrorIs(t, err, ErrMissingDimension)
+}
+
+func TestRule_Key(t *testing.T) {
+	// 花括号原样保留，作为 hash tag
+	key, err := Rule{Key: "{<uid>}:api:<api>"}.key(map[string]string{"uid": "1", "api": "/hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "{1}:api:/hello", key)
+
+	_, err = Rule{Key: "{<uid>}:api:<api>"}.key(map[string]string{"uid": "1"})
+	assert.ErrorIs(t, err, ErrMissingDimension)
+}
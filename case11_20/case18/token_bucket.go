@@ -0,0 +1,78 @@
+package case18
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	//go:embed token_bucket.lua
+	tokenBucketScript string
+)
+
+// TokenBucketLimiter 基于 Redis 的分布式令牌桶
+// 和 Limiter 不同，它允许突发流量，并且一次可以拿多个令牌
+type TokenBucketLimiter struct {
+	client redis.Cmdable
+	// key 的前缀，实际的 key 是前缀加上维度，比如说用户 ID
+	prefix string
+	// 桶的容量，也就是允许的突发流量
+	capacity int
+	// 每秒生成多少个令牌
+	rate int
+	// 获取当前时间，为 nil 的时候使用 Redis 的时间
+	now func() time.Time
+}
+
+// NewTokenBucketLimiter 使用 Redis 的时间
+func NewTokenBucketLimiter(client redis.Cmdable, prefix string, capacity, rate int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		client:   client,
+		prefix:   prefix,
+		capacity: capacity,
+		rate:     rate,
+	}
+}
+
+// NewTokenBucketLimiterWithClock 使用服务端传过来的时间
+func NewTokenBucketLimiterWithClock(client redis.Cmdable, prefix string, capacity, rate int,
+	now func() time.Time) *TokenBucketLimiter {
+	l := NewTokenBucketLimiter(client, prefix, capacity, rate)
+	l.now = now
+	return l
+}
+
+// TokenBucketResult 一次拿令牌的结果
+type TokenBucketResult struct {
+	Allowed bool
+	// 剩余的令牌数
+	Remaining int
+	// 被拒绝的时候，还要等多久才有足够的令牌
+	// 为负数说明永远等不到，比如说要的令牌比容量还多
+	RetryAfter time.Duration
+}
+
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	res, err := l.AllowN(ctx, key, 1)
+	return res.Allowed, err
+}
+
+// AllowN 一次拿 n 个令牌，要么全部拿到，要么一个都不拿
+func (l *TokenBucketLimiter) AllowN(ctx context.Context, key string, n int) (TokenBucketResult, error) {
+	var now int64
+	if l.now != nil {
+		now = l.now().UnixMilli()
+	}
+	vals, err := l.client.Eval(ctx, tokenBucketScript, []string{l.prefix + key},
+		l.capacity, l.rate, n, now).Int64Slice()
+	if err != nil {
+		return TokenBucketResult{}, err
+	}
+	return TokenBucketResult{
+		Allowed:    vals[0] == 1,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+	}, nil
+}
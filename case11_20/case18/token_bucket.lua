@@ -0,0 +1,50 @@
+local key = KEYS[1]
+-- 桶的容量，也就是允许的突发流量
+local capacity = tonumber(ARGV[1])
+-- 每秒生成多少个令牌
+local rate = tonumber(ARGV[2])
+-- 这一次要拿多少个令牌
+local requested = tonumber(ARGV[3])
+-- 当前时间戳，毫秒
+-- 和 slide_window.lua 不同，这里允许服务端不传，不传就用 Redis 的时间
+-- 用 Redis 的时间可以避免各个服务端的时钟不一致，代价是多了一次 TIME 调用
+local now = tonumber(ARGV[4])
+if now == nil or now <= 0 then
+    local time = redis.call('TIME')
+    now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+end
+
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+    -- 第一次请求，满桶
+    tokens = capacity
+    ts = now
+end
+
+-- 按照流逝的时间补充令牌
+-- 时钟回拨的时候 now 比 ts 小，这时候就不补充
+if now > ts then
+    tokens = math.min(capacity, tokens + (now - ts) * rate / 1000)
+    ts = now
+end
+
+local allowed = 0
+-- 还要等多久才有足够的令牌，毫秒，-1 代表永远等不到
+local retryAfter = 0
+if requested <= tokens then
+    tokens = tokens - requested
+    allowed = 1
+elseif requested <= capacity and rate > 0 then
+    retryAfter = math.ceil((requested - tokens) * 1000 / rate)
+else
+    retryAfter = -1
+end
+
+redis.call('HSET', key, 'tokens', tokens, 'ts', ts)
+if rate > 0 then
+    -- 过了从空桶到满桶的时间，这个桶和新建的没有区别，可以直接过期
+    redis.call('PEXPIRE', key, math.ceil(capacity * 1000 / rate) + 1000)
+end
+return {allowed, math.floor(tokens), retryAfter}
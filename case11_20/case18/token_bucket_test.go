@@ -0,0 +1,137 @@
+package case18
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"interview-cases/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter_AllowN(t *testing.T) {
+	rdb := test.InitRedis()
+	prefix := "case18/token_bucket/"
+	now := time.UnixMilli(1_700_000_000_000)
+	// 容量 10，每秒 5 个令牌
+	limiter := NewTokenBucketLimiterWithClock(rdb, prefix, 10, 5, func() time.Time {
+		return now
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer func() {
+		err := rdb.Del(ctx, prefix+"user1").Err()
+		if err != nil {
+			t.Log("清理数据失败", err)
+		}
+	}()
+
+	// 满桶，允许一次性拿走全部令牌
+	res, err := limiter.AllowN(ctx, "user1", 10)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// 没有令牌了，两个令牌要等 400ms
+	res, err = limiter.AllowN(ctx, "user1", 2)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 400*time.Millisecond, res.RetryAfter)
+
+	// 过了 300ms，生成了 1.5 个令牌，够一个不够两个
+	now = now.Add(300 * time.Millisecond)
+	res, err = limiter.AllowN(ctx, "user1", 2)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	ok, err := limiter.Allow(ctx, "user1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 很久之后也不会超过容量
+	now = now.Add(time.Hour)
+	res, err = limiter.AllowN(ctx, "user1", 1)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 9, res.Remaining)
+
+	// 超过容量的请求永远不会被允许
+	res, err = limiter.AllowN(ctx, "user1", 11)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.True(t, res.RetryAfter < 0)
+}
+
+func TestTokenBucketLimiter_ServerTime(t *testing.T) {
+	rdb := test.InitRedis()
+	prefix := "case18/token_bucket_server_time/"
+	limiter := NewTokenBucketLimiter(rdb, prefix, 2, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer rdb.Del(ctx, prefix+"user1")
+
+	for i := 0; i < 2; i++ {
+		ok, err := limiter.Allow(ctx, "user1")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, err := limiter.Allow(ctx, "user1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestTokenBucketLimiter_BuildGinMiddleware(t *testing.T) {
+	rdb := test.InitRedis()
+	prefix := "case18/token_bucket_gin/"
+	limiter := NewTokenBucketLimiter(rdb, prefix, 1, 0)
+	defer rdb.Del(context.Background(), prefix+"user:123", prefix+"user:456")
+
+	gin.SetMode(gin.ReleaseMode)
+	server := gin.New()
+	server.Use(limiter.BuildGinMiddleware(GinByHeader("uid")))
+	server.GET("/hello", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	doReq := func(uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		req.Header.Set("uid", uid)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+	recorder := doReq("123")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	recorder = doReq("123")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	// 不同的用户互不影响
+	assert.Equal(t, http.StatusOK, doReq("456").Code)
+}
+
+func TestTokenBucketLimiter_BuildServerInterceptor(t *testing.T) {
+	rdb := test.InitRedis()
+	prefix := "case18/token_bucket_grpc/"
+	limiter := NewTokenBucketLimiter(rdb, prefix, 1, 0)
+	defer rdb.Del(context.Background(), prefix+"user:123")
+
+	interceptor := limiter.BuildServerInterceptor(GrpcByMetadata("uid"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("uid", "123"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.TestService/Test"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	resp, err := interceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -13,6 +13,7 @@ import (
 	"interview-cases/case21_30/case24/repository/cache/redis"
 	"interview-cases/case21_30/case24/repository/dao"
 	"interview-cases/case21_30/case24/service"
+	"interview-cases/clock"
 	"interview-cases/test"
 	"testing"
 	"time"
@@ -23,6 +24,7 @@ type TestSuite struct {
 	orderSvc   service.OrderService
 	localCache *local.Cache
 	redisCache *redis.Cache
+	clk        *clock.Fake
 }
 
 func (t *TestSuite) SetupSuite() {
@@ -30,10 +32,11 @@ func (t *TestSuite) SetupSuite() {
 	err := dao.InitTables(db)
 	require.NoError(t.T(), err)
 	client := test.InitRedis()
-	mockClient := NewRedisMock(client)
+	t.clk = clock.NewFake(time.Unix(1700000000, 0))
+	mockClient := NewRedisMock(client, t.clk)
 	localCache := local.NewCache()
 	redisCache := redis.NewCache(mockClient)
-	ca := mix.NewCache(localCache, redisCache, 0)
+	ca := mix.NewCacheWithClock(localCache, redisCache, 0, t.clk)
 	orderDao := dao.NewOrderDAO(db)
 	orderRepo := repository.NewOrderRepo(orderDao, ca)
 	orderSvc := service.NewOrderService(orderRepo)
@@ -61,8 +64,8 @@ func (t *TestSuite) TestRedis() {
 		BuyerID: 123,
 		Price:   777,
 	}, order)
-	// 等待11s，redis崩溃
-	time.Sleep(11 * time.Second)
+	// 第 10 秒之后 redis 崩溃，第 11 秒的探活失败之后还要隔 10ms 确认两次
+	t.sleep(11*time.Second + 500*time.Millisecond)
 	err = t.orderSvc.Save(context.Background(), domain.Order{
 		ID:      1,
 		Name:    fmt.Sprintf("order_new_%d", 1),
@@ -92,7 +95,7 @@ func (t *TestSuite) TestRedis() {
 	}, order)
 
 	// 又过10s检测到恢复
-	time.Sleep(10 * time.Second)
+	t.sleep(10 * time.Second)
 	err = t.orderSvc.Save(context.Background(), domain.Order{
 		ID:      2,
 		Name:    fmt.Sprintf("order_new_%d", 2),
@@ -121,6 +124,15 @@ func (t *TestSuite) TestRedis() {
 
 }
 
+// sleep 推进时钟，每次推进 10ms，保证探活协程跟得上
+func (t *TestSuite) sleep(d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += 10 * time.Millisecond {
+		t.clk.BlockUntil(1)
+		t.clk.Advance(10 * time.Millisecond)
+	}
+	t.clk.BlockUntil(1)
+}
+
 func (t *TestSuite) initOrders() {
 	for i := 1; i <= 10; i++ {
 		err := t.orderSvc.Save(context.Background(), domain.Order{
@@ -3,24 +3,27 @@ package case24
 import (
 	"context"
 	"github.com/redis/go-redis/v9"
+	"interview-cases/clock"
 	"time"
 )
 
 type RedisMock struct {
 	startTime time.Time
+	clock     clock.Clock
 	redis.Cmdable
 }
 
-func NewRedisMock(client redis.Cmdable) *RedisMock {
+func NewRedisMock(client redis.Cmdable, clk clock.Clock) *RedisMock {
 	return &RedisMock{
-		startTime: time.Now(),
-		Cmdable: client,
+		startTime: clk.Now(),
+		clock:     clk,
+		Cmdable:   client,
 	}
 }
 
 func (r *RedisMock) Ping(ctx context.Context) *redis.StatusCmd {
 	// 过了十秒钟模拟崩溃
-	now := time.Now()
+	now := r.clock.Now()
 	if now.Sub(r.startTime) > 10*time.Second && now.Sub(r.startTime) < 15*time.Second {
 		cmd := redis.NewStatusCmd(ctx)
 		cmd.SetVal("ping不通了")
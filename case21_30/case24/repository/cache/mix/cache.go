@@ -6,6 +6,7 @@ import (
 	"interview-cases/case21_30/case24/domain"
 	"interview-cases/case21_30/case24/repository/cache/local"
 	"interview-cases/case21_30/case24/repository/cache/redis"
+	"interview-cases/clock"
 	"log/slog"
 	"sync/atomic"
 	"time"
@@ -16,13 +17,20 @@ type Cache struct {
 	redisCache     *redis.Cache
 	cachesStrategy int32 // 缓存策略 0-只有在redis崩溃的时候才会写入本地缓存，1-redis正常的时候也会写入本地缓存
 	useLocalCache  int32 // 0-使用redis 1-使用本地缓存
+	clock          clock.Clock
 }
 
 func NewCache(localCache *local.Cache, redisCache *redis.Cache, cachesStrategy int32) *Cache {
+	return NewCacheWithClock(localCache, redisCache, cachesStrategy, clock.New())
+}
+
+// NewCacheWithClock 探活的间隔由 clk 控制，测试的时候不需要真的等
+func NewCacheWithClock(localCache *local.Cache, redisCache *redis.Cache, cachesStrategy int32, clk clock.Clock) *Cache {
 	c := &Cache{
 		localCache:     localCache,
 		redisCache:     redisCache,
 		cachesStrategy: cachesStrategy,
+		clock:          clk,
 	}
 	go c.redisFailOverLoop()
 	return c
@@ -96,7 +104,7 @@ func (c *Cache) redisFailOverLoop() {
 			c.handleFailOver()
 			successCount = 0
 		}
-		time.Sleep(1 * time.Second)
+		c.clock.Sleep(1 * time.Second)
 	}
 }
 
@@ -115,7 +123,7 @@ func (c *Cache) isRedisAvailable() bool {
 	}
 	// 如果检测到故障，隔 10ms 发送两次 ping 请求进行确认
 	for i := 0; i < 2; i++ {
-		time.Sleep(10 * time.Millisecond)
+		c.clock.Sleep(10 * time.Millisecond)
 		if c.checkRedis() == nil {
 			return true
 		}
@@ -0,0 +1,201 @@
+package case32
+
+import (
+	"context"
+	"interview-cases/clock"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Resetter 有状态的重试策略，同一个实例用于下一次操作之前要调用 Reset
+type Resetter interface {
+	Reset()
+}
+
+// HintLimiter 服务端建议的重试间隔也要受策略的限制
+type HintLimiter interface {
+	// LimitHint 在 Next 之后调用，d 是服务端建议的间隔，返回不超过 MaxInterval 的间隔，
+	// 等完之后超过了 MaxElapsed 就返回 false，不再重试
+	LimitHint(d time.Duration) (time.Duration, bool)
+}
+
+// LimitHint s 实现了 HintLimiter 就按照它的限制，否则原样返回
+func LimitHint(s Strategy, d time.Duration) (time.Duration, bool) {
+	if l, ok := s.(HintLimiter); ok {
+		return l.LimitHint(d)
+	}
+	return d, true
+}
+
+// Jitter 在退避间隔上加随机扰动，避免大量客户端在同一时刻重试
+// 参考 https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type Jitter int
+
+const (
+	// JitterNone 不加扰动
+	JitterNone Jitter = iota
+	// JitterFull 在 [0, d) 里面随机
+	JitterFull
+	// JitterEqual 一半固定，另一半在 [d/2, d) 里面随机
+	JitterEqual
+	// JitterDecorrelated 在 [初始间隔, 上一次间隔*3) 里面随机，不看重试次数
+	JitterDecorrelated
+)
+
+// BackoffConfig 退避策略共用的配置，为 0 的字段代表不限制
+type BackoffConfig struct {
+	Jitter Jitter
+	// 最多重试几次
+	MaxAttempts int
+	// 单次重试间隔的上限
+	MaxInterval time.Duration
+	// 从第一次失败开始，最多重试多久
+	MaxElapsed time.Duration
+}
+
+// Backoff 退避策略
+// 每次操作的状态是独立的，并发的操作各自用一个实例，或者在操作开始之前调用 Reset
+type Backoff struct {
+	mu       sync.Mutex
+	initial  time.Duration
+	interval func(attempt int) float64
+	cfg      BackoffConfig
+	clock    clock.Clock
+	random   func(n int64) int64
+
+	attempts int
+	start    time.Time
+	prev     time.Duration
+}
+
+func newBackoff(initial time.Duration, interval func(attempt int) float64, cfg BackoffConfig, clk clock.Clock) *Backoff {
+	return &Backoff{
+		initial:  initial,
+		interval: interval,
+		cfg:      cfg,
+		clock:    clk,
+		random:   rand.Int64N,
+	}
+}
+
+// NewFixedBackoff 每次间隔都一样
+func NewFixedBackoff(interval time.Duration, cfg BackoffConfig) *Backoff {
+	return NewFixedBackoffWithClock(interval, cfg, clock.New())
+}
+
+// NewFixedBackoffWithClock MaxElapsed 按照 clk 计算
+func NewFixedBackoffWithClock(interval time.Duration, cfg BackoffConfig, clk clock.Clock) *Backoff {
+	return newBackoff(interval, func(attempt int) float64 {
+		return float64(interval)
+	}, cfg, clk)
+}
+
+// NewLinearBackoff 第 n 次重试的间隔是 initial + (n-1)*step
+func NewLinearBackoff(initial, step time.Duration, cfg BackoffConfig) *Backoff {
+	return NewLinearBackoffWithClock(initial, step, cfg, clock.New())
+}
+
+// NewLinearBackoffWithClock MaxElapsed 按照 clk 计算
+func NewLinearBackoffWithClock(initial, step time.Duration, cfg BackoffConfig, clk clock.Clock) *Backoff {
+	return newBackoff(initial, func(attempt int) float64 {
+		return float64(initial) + float64(attempt-1)*float64(step)
+	}, cfg, clk)
+}
+
+// NewExponentialBackoff 第 n 次重试的间隔是 initial * multiplier^(n-1)
+func NewExponentialBackoff(initial time.Duration, multiplier float64, cfg BackoffConfig) *Backoff {
+	return NewExponentialBackoffWithClock(initial, multiplier, cfg, clock.New())
+}
+
+// NewExponentialBackoffWithClock MaxElapsed 按照 clk 计算
+func NewExponentialBackoffWithClock(initial time.Duration, multiplier float64, cfg BackoffConfig, clk clock.Clock) *Backoff {
+	return newBackoff(initial, func(attempt int) float64 {
+		return float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	}, cfg, clk)
+}
+
+// Next 请求成功的时候不需要重试
+// 超过了重试次数、总时长，或者等待之后 ctx 已经过期了，都不再重试
+func (b *Backoff) Next(ctx context.Context, err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if b.attempts == 0 {
+		b.start = now
+	}
+	b.attempts++
+	if b.cfg.MaxAttempts > 0 && b.attempts > b.cfg.MaxAttempts {
+		return 0, false
+	}
+	d := b.next()
+	if b.cfg.MaxElapsed > 0 && now.Sub(b.start)+d > b.cfg.MaxElapsed {
+		return 0, false
+	}
+	// ctx 的超时时间总是真实的时间，所以比较的是剩余的时长
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return 0, false
+	}
+	return d, true
+}
+
+// LimitHint 服务端建议的间隔和退避的间隔一样受 MaxInterval 和 MaxElapsed 的限制
+func (b *Backoff) LimitHint(d time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d = b.limit(float64(d))
+	if b.cfg.MaxElapsed > 0 && b.clock.Now().Sub(b.start)+d > b.cfg.MaxElapsed {
+		return 0, false
+	}
+	return d, true
+}
+
+// next 计算下一次的间隔，调用者要持有锁
+func (b *Backoff) next() time.Duration {
+	if b.cfg.Jitter == JitterDecorrelated {
+		upper := b.limit(math.Max(float64(b.initial), float64(b.prev)*3))
+		d := b.initial + b.rand(upper-b.initial)
+		b.prev = b.limit(float64(d))
+		return b.prev
+	}
+	d := b.limit(b.interval(b.attempts))
+	switch b.cfg.Jitter {
+	case JitterFull:
+		d = b.rand(d)
+	case JitterEqual:
+		d = d/2 + b.rand(d-d/2)
+	}
+	return d
+}
+
+// limit 限制在 MaxInterval 以内，同时防止溢出
+func (b *Backoff) limit(d float64) time.Duration {
+	upper := time.Duration(math.MaxInt64)
+	if b.cfg.MaxInterval > 0 {
+		upper = b.cfg.MaxInterval
+	}
+	if d >= float64(upper) {
+		return upper
+	}
+	return time.Duration(max(0, d))
+}
+
+func (b *Backoff) rand(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(b.random(int64(n)))
+}
+
+// Reset 清空当前操作的状态
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts = 0
+	b.start = time.Time{}
+	b.prev = 0
+}
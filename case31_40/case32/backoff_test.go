@@ -0,0 +1,138 @@
+package case32
+
+import (
+	"context"
+	"errors"
+	"interview-cases/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// half 随机数固定取一半，方便断言
+func half(n int64) int64 {
+	return n / 2
+}
+
+func TestBackoff(t *testing.T) {
+	mockErr := errors.New("mock error")
+	testCases := []struct {
+		name    string
+		backoff *Backoff
+		want    []time.Duration
+	}{
+		{
+			name:    "fixed",
+			backoff: NewFixedBackoff(100*time.Millisecond, BackoffConfig{MaxAttempts: 3}),
+			want:    []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:    "linear",
+			backoff: NewLinearBackoff(100*time.Millisecond, 100*time.Millisecond, BackoffConfig{MaxAttempts: 4, MaxInterval: 250 * time.Millisecond}),
+			want:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond},
+		},
+		{
+			name:    "exponential",
+			backoff: NewExponentialBackoff(100*time.Millisecond, 2, BackoffConfig{MaxAttempts: 4}),
+			want:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+		},
+		{
+			name:    "full jitter",
+			backoff: NewExponentialBackoff(100*time.Millisecond, 2, BackoffConfig{MaxAttempts: 3, Jitter: JitterFull}),
+			want:    []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:    "equal jitter",
+			backoff: NewExponentialBackoff(100*time.Millisecond, 2, BackoffConfig{MaxAttempts: 3, Jitter: JitterEqual}),
+			want:    []time.Duration{75 * time.Millisecond, 150 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			// 100，100 + (300-100)/2，100 + (600-100)/2，第四次的上限 1050 超过了 1s，取 100 + (1000-100)/2
+			name: "decorrelated jitter",
+			backoff: NewExponentialBackoff(100*time.Millisecond, 2, BackoffConfig{MaxAttempts: 4,
+				Jitter: JitterDecorrelated, MaxInterval: time.Second}),
+			want: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 550 * time.Millisecond},
+		},
+		{
+			// 溢出之后取上限
+			name:    "overflow",
+			backoff: NewExponentialBackoff(time.Second, 1e10, BackoffConfig{MaxAttempts: 3, MaxInterval: time.Minute}),
+			want:    []time.Duration{time.Second, time.Minute, time.Minute},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.backoff.random = half
+			// 执行两轮，验证 Reset 之后从头开始
+			for round := 0; round < 2; round++ {
+				var got []time.Duration
+				for {
+					d, ok := tc.backoff.Next(context.Background(), mockErr)
+					if !ok {
+						break
+					}
+					got = append(got, d)
+				}
+				assert.Equal(t, tc.want, got)
+				tc.backoff.Reset()
+			}
+		})
+	}
+}
+
+func TestBackoff_Stop(t *testing.T) {
+	mockErr := errors.New("mock error")
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	b := NewFixedBackoffWithClock(time.Second, BackoffConfig{MaxElapsed: 2500 * time.Millisecond}, clk)
+	// 成功了不需要重试
+	_, ok := b.Next(context.Background(), nil)
+	assert.False(t, ok)
+
+	// 第三次重试要等到 3s 之后，超过了 MaxElapsed
+	for i := 0; i < 2; i++ {
+		_, ok = b.Next(context.Background(), mockErr)
+		assert.True(t, ok)
+		clk.Advance(time.Second)
+	}
+	_, ok = b.Next(context.Background(), mockErr)
+	assert.False(t, ok)
+
+	// 等不到下一次重试 ctx 就过期了
+	b.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, ok = b.Next(ctx, mockErr)
+	assert.False(t, ok)
+}
+
+func TestBackoff_Compose(t *testing.T) {
+	mockErr := errors.New("mock error")
+	newBackoff := func() Strategy {
+		return NewFixedBackoff(time.Millisecond, BackoffConfig{MaxAttempts: 2})
+	}
+	for _, s := range []interface {
+		Operation() Strategy
+	}{
+		NewNormalAdaptiveStrategy(newBackoff, time.Second, 100),
+		NewUpgradeAdaptiveStrategy(newBackoff, 10, 100*time.Millisecond, 0.1, 100),
+	} {
+		// 交替执行的两个操作各自有一个退避策略，自适应的统计是共享的
+		ops := []Strategy{s.Operation(), s.Operation()}
+		for i := 0; i < 2; i++ {
+			for _, op := range ops {
+				d, ok := op.Next(context.Background(), mockErr)
+				assert.True(t, ok)
+				assert.Equal(t, time.Millisecond, d)
+			}
+		}
+		for _, op := range ops {
+			_, ok := op.Next(context.Background(), mockErr)
+			assert.False(t, ok)
+		}
+		// 新的操作重新开始退避
+		d, ok := s.Operation().Next(context.Background(), mockErr)
+		assert.True(t, ok)
+		assert.Equal(t, time.Millisecond, d)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"interview-cases/case31_40/case32/limiter"
 	"interview-cases/case31_40/case32/monitor"
+	"interview-cases/reject"
 	"io/ioutil"
 	"math/rand/v2"
 	"net/http"
@@ -13,11 +14,11 @@ import (
 
 // RateLimitBuilder 限流中间件
 type RateLimitBuilder struct {
-	mon   *monitor.RateLimitMon
+	mon   *monitor.WindowMon
 	limit limiter.Limiter
 }
 
-func NewRateLimitBuilder(mon *monitor.RateLimitMon, limit limiter.Limiter) *RateLimitBuilder {
+func NewRateLimitBuilder(mon *monitor.WindowMon, limit limiter.Limiter) *RateLimitBuilder {
 	return &RateLimitBuilder{
 		mon:   mon,
 		limit: limit,
@@ -28,7 +29,7 @@ func CtxWithVip(ctx context.Context) context.Context {
 }
 func (r *RateLimitBuilder) Build() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 记录活跃的请求数
+		// 记录到达的请求数和活跃的请求数
 		r.mon.InCr()
 		defer r.mon.Decr()
 		// 简单一点，如果请求头中携带vip说明是vip用户
@@ -42,10 +43,13 @@ func (r *RateLimitBuilder) Build() gin.HandlerFunc {
 			return
 		}
 		if ok {
-			c.String(http.StatusInternalServerError, "你被限流了")
+			// 限流器按秒统计 QPS，一秒之后再试
+			reject.AbortGin(c, reject.Info{RetryAfter: time.Second, Description: "你被限流了"})
 			return
 		}
+		start := time.Now()
 		c.Next()
+		r.mon.Observe(time.Since(start), c.Writer.Status() >= http.StatusInternalServerError)
 	}
 }
 
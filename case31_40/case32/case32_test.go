@@ -4,10 +4,11 @@ import (
 	"interview-cases/case31_40/case32/limiter"
 	"interview-cases/case31_40/case32/monitor"
 	"testing"
+	"time"
 )
 
 func TestCase32(t *testing.T) {
-	mon := monitor.NewRateLimitMon()
+	mon := monitor.NewWindowMon(10*time.Second, 10)
 	// 初始化限流中间件
 	limit := limiter.NewVipLimiter(1000, mon)
 	limitMiddleware := NewRateLimitBuilder(mon, limit)
@@ -0,0 +1,189 @@
+package case32
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	//go:embed cluster_budget.lua
+	clusterBudgetScript string
+)
+
+// ClusterAdaptiveStrategy 整个集群共享的重试预算
+// 每个实例定时把自己的请求数和重试次数累加到 Redis 里面按时间分的桶，同时读回整个集群的数据，
+// 这样下游出问题的时候，重试的总量是整个集群的请求数的一定比例，而不是每个实例各自一份。
+// Redis 出问题的时候降级为本地的重试预算，也就是 local 自己的判断
+type ClusterAdaptiveStrategy struct {
+	client redis.Cmdable
+	key    string
+	// 窗口大小、桶的大小、预算的比例都和 local 保持一致
+	local    *UpgradeAdaptiveStrategy
+	interval time.Duration
+
+	// 上一次同步的时候整个集群的数据
+	requests atomic.Int64
+	retries  atomic.Int64
+	// 还没有同步到 Redis 的数据
+	pendingRequests atomic.Int64
+	pendingRetries  atomic.Int64
+	degraded        atomic.Bool
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewClusterAdaptiveStrategy 每隔 interval 同步一次，同步之前按照本地的预算判断
+// 时间由 local 的时钟决定
+func NewClusterAdaptiveStrategy(client redis.Cmdable, key string, local *UpgradeAdaptiveStrategy, interval time.Duration) *ClusterAdaptiveStrategy {
+	c := newClusterAdaptiveStrategy(client, key, local, interval)
+	go c.loop()
+	return c
+}
+
+func newClusterAdaptiveStrategy(client redis.Cmdable, key string, local *UpgradeAdaptiveStrategy, interval time.Duration) *ClusterAdaptiveStrategy {
+	c := &ClusterAdaptiveStrategy{
+		client:   client,
+		key:      key,
+		local:    local,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	// 还没有同步过，先按照本地的预算来
+	c.degraded.Store(true)
+	return c
+}
+
+func (c *ClusterAdaptiveStrategy) loop() {
+	ticker := c.local.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+			c.flush(ctx)
+			cancel()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close 停止同步，可以重复调用
+func (c *ClusterAdaptiveStrategy) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// Degraded 是否降级为本地的重试预算
+func (c *ClusterAdaptiveStrategy) Degraded() bool {
+	return c.degraded.Load()
+}
+
+// Next 所有调用共用 local 的底层策略，底层策略有状态的时候，每次操作要用 Operation 创建的策略
+func (c *ClusterAdaptiveStrategy) Next(ctx context.Context, err error) (time.Duration, bool) {
+	return c.next(ctx, err, c.local.s)
+}
+
+// Operation 为一次操作创建一个策略，预算是共享的，底层策略由 local 新创建
+func (c *ClusterAdaptiveStrategy) Operation() Strategy {
+	return &clusterOperation{c: c, s: c.local.newS()}
+}
+
+type clusterOperation struct {
+	c *ClusterAdaptiveStrategy
+	s Strategy
+}
+
+func (o *clusterOperation) Next(ctx context.Context, err error) (time.Duration, bool) {
+	return o.c.next(ctx, err, o.s)
+}
+
+// LimitHint 服务端建议的间隔受 local 的底层策略的限制
+func (c *ClusterAdaptiveStrategy) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(c.local.s, d)
+}
+
+func (o *clusterOperation) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(o.s, d)
+}
+
+func (c *ClusterAdaptiveStrategy) next(ctx context.Context, err error, s Strategy) (time.Duration, bool) {
+	if c.degraded.Load() {
+		c.pendingRequests.Add(1)
+		d, ok := c.local.next(ctx, err, s)
+		if ok && err != nil {
+			c.pendingRetries.Add(1)
+		}
+		return d, ok
+	}
+	// 本地的窗口也要一直记录，降级的时候才有数据可用
+	cur := c.local.current()
+	c.pendingRequests.Add(1)
+	if err == nil {
+		cur.success.Add(1)
+		return s.Next(ctx, err)
+	}
+	cur.failure.Add(1)
+	// 和 UpgradeAdaptiveStrategy 一样，先占用预算再检查
+	c.pendingRetries.Add(1)
+	if !c.withinBudget() {
+		c.pendingRetries.Add(-1)
+		return 0, false
+	}
+	d, ok := s.Next(ctx, err)
+	if !ok {
+		c.pendingRetries.Add(-1)
+		return d, ok
+	}
+	cur.retries.Add(1)
+	return d, ok
+}
+
+func (c *ClusterAdaptiveStrategy) withinBudget() bool {
+	requests := c.requests.Load() + c.pendingRequests.Load()
+	retries := c.retries.Load() + c.pendingRetries.Load()
+	window := c.local.width * time.Duration(len(c.local.buckets))
+	return float64(retries) <= c.local.ratio*float64(requests)+c.local.minPerSecond*window.Seconds()
+}
+
+// flush 把还没有同步的数据累加到 Redis，并且读回整个集群的数据
+// 失败的时候降级为本地的重试预算，这一轮的数据直接丢掉，
+// 不然故障期间的数据都会在恢复的时候写到同一个桶里面，整个窗口内集群的请求数都会偏大
+func (c *ClusterAdaptiveStrategy) flush(ctx context.Context) {
+	requests := c.pendingRequests.Swap(0)
+	retries := c.pendingRetries.Swap(0)
+	window := c.local.width * time.Duration(len(c.local.buckets))
+	vals, err := c.client.Eval(ctx, clusterBudgetScript, c.bucketKeys(),
+		requests, retries, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		if c.degraded.CompareAndSwap(false, true) {
+			slog.Error("同步重试预算失败，降级为本地的重试预算", slog.Any("err", err))
+		}
+		return
+	}
+	c.requests.Store(vals[0])
+	c.retries.Store(vals[1])
+	if c.degraded.CompareAndSwap(true, false) {
+		slog.Info("同步重试预算成功，使用集群的重试预算")
+	}
+}
+
+// bucketKeys 窗口内所有桶的 key，第一个是当前的桶
+// 使用 hash tag 保证在 Redis Cluster 里面落在同一个节点
+func (c *ClusterAdaptiveStrategy) bucketKeys() []string {
+	width := int64(c.local.width)
+	cur := c.local.clock.Now().UnixNano() / width
+	keys := make([]string, 0, len(c.local.buckets))
+	for i := 0; i < len(c.local.buckets); i++ {
+		keys = append(keys, "{"+c.key+"}:"+strconv.FormatInt(cur-int64(i), 10))
+	}
+	return keys
+}
@@ -0,0 +1,17 @@
+-- 把本实例的计数累加到当前的桶，再汇总整个窗口
+-- KEYS 窗口内的所有桶，KEYS[1] 是当前的桶
+-- ARGV[1] 本实例这段时间的请求数
+-- ARGV[2] 本实例这段时间的重试次数
+-- ARGV[3] 桶的过期时间，毫秒，也就是整个窗口的长度
+redis.call('HINCRBY', KEYS[1], 'requests', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'retries', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+
+local requests = 0
+local retries = 0
+for i = 1, #KEYS do
+    local vals = redis.call('HMGET', KEYS[i], 'requests', 'retries')
+    requests = requests + (tonumber(vals[1]) or 0)
+    retries = retries + (tonumber(vals[2]) or 0)
+end
+return { requests, retries }
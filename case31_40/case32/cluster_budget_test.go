@@ -0,0 +1,159 @@
+package case32
+
+import (
+	"context"
+	"errors"
+	"interview-cases/clock"
+	"interview-cases/test"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试场景
+// 两个实例共享重试预算，预算是请求数的 10%
+// A 处理了 100 个成功的请求，B 的下游出问题了，B 用掉了整个集群的预算之后，A 也不能再重试
+func TestClusterAdaptiveStrategy(t *testing.T) {
+	rdb := test.InitRedis()
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	key := "case32/cluster_budget"
+	newInstance := func() *ClusterAdaptiveStrategy {
+		local := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, 100*time.Millisecond, 0.1, 0, clk)
+		return newClusterAdaptiveStrategy(rdb, key, local, 100*time.Millisecond)
+	}
+	a, b := newInstance(), newInstance()
+	keys := a.bucketKeys()
+	defer func() {
+		// 测试过程中时间往前走了，两个窗口的桶都要删掉
+		rdb.Del(context.Background(), append(keys, a.bucketKeys()...)...)
+	}()
+	ctx := context.Background()
+	mockErr := errors.New("mock error")
+	count := func(s Strategy, err error, n int) int {
+		var allowed int
+		for i := 0; i < n; i++ {
+			if _, ok := s.Next(ctx, err); ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// 同步之后才使用集群的预算
+	assert.True(t, a.Degraded())
+	a.flush(ctx)
+	assert.False(t, a.Degraded())
+	assert.Equal(t, 100, count(a, nil, 100))
+	a.flush(ctx)
+	b.flush(ctx)
+	assert.False(t, b.Degraded())
+
+	// B 自己没有成功的请求，但是可以用集群的预算
+	// 第 k 次失败的时候，一共 100 + k 个请求，最多重试 10 + k/10 次
+	assert.Equal(t, 12, count(b, mockErr, 20))
+	b.flush(ctx)
+	a.flush(ctx)
+	// 集群的预算用完了，A 在本地看是有预算的，但是也不能重试
+	assert.Equal(t, 0, count(a, mockErr, 5))
+	assert.True(t, a.local.withinBudget())
+
+	// 过了窗口，之前的数据全部过期，只剩下 A 刚才没有同步的 5 个请求
+	clk.Advance(time.Second)
+	a.flush(ctx)
+	assert.Equal(t, int64(5), a.requests.Load())
+	assert.Equal(t, int64(0), a.retries.Load())
+	// 第 5 次失败的时候一共 10 个请求，可以重试 1 次
+	assert.Equal(t, 1, count(a, mockErr, 5))
+}
+
+func TestClusterAdaptiveStrategy_Degrade(t *testing.T) {
+	// 连不上的 Redis
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	local := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, 100*time.Millisecond, 0.1, 0, clk)
+	s := newClusterAdaptiveStrategy(rdb, "case32/cluster_budget_degrade", local, 100*time.Millisecond)
+	ctx := context.Background()
+	s.flush(ctx)
+	assert.True(t, s.Degraded())
+
+	// 按照本地的预算判断，和集群的算法一样
+	for i := 0; i < 100; i++ {
+		_, ok := s.Next(ctx, nil)
+		assert.True(t, ok)
+	}
+	var allowed int
+	for i := 0; i < 20; i++ {
+		if _, ok := s.Next(ctx, errors.New("mock error")); ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 12, allowed)
+	// 没有同步出去的数据留到下一次
+	assert.Equal(t, int64(120), s.pendingRequests.Load())
+	assert.Equal(t, int64(12), s.pendingRetries.Load())
+	// 同步还是失败，这一轮的数据丢掉，不会一直累积到 Redis 恢复
+	s.flush(ctx)
+	assert.Equal(t, int64(0), s.pendingRequests.Load())
+	assert.Equal(t, int64(0), s.pendingRetries.Load())
+}
+
+// 测试场景
+// Redis 故障了很久，恢复之后集群的数据里面只有最后一轮同步之后的请求
+func TestClusterAdaptiveStrategy_Recover(t *testing.T) {
+	rdb := &flakyRedis{Cmdable: test.InitRedis()}
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	key := "case32/cluster_budget_recover"
+	local := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, 100*time.Millisecond, 0.1, 0, clk)
+	s := newClusterAdaptiveStrategy(rdb, key, local, 100*time.Millisecond)
+	defer s.Close()
+	defer func() {
+		rdb.Del(context.Background(), s.bucketKeys()...)
+	}()
+	ctx := context.Background()
+
+	rdb.broken.Store(true)
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 100; j++ {
+			s.Next(ctx, nil)
+		}
+		s.flush(ctx)
+		assert.True(t, s.Degraded())
+	}
+	rdb.broken.Store(false)
+	for j := 0; j < 30; j++ {
+		s.Next(ctx, nil)
+	}
+	s.flush(ctx)
+	assert.False(t, s.Degraded())
+	assert.Equal(t, int64(30), s.requests.Load())
+}
+
+func TestClusterAdaptiveStrategy_Close(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	local := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, 100*time.Millisecond, 0.1, 0, clk)
+	s := NewClusterAdaptiveStrategy(test.InitRedis(), "case32/cluster_budget_close", local, 100*time.Millisecond)
+	clk.BlockUntil(1)
+	s.Close()
+	assert.NotPanics(t, s.Close)
+	assert.Eventually(t, func() bool {
+		return clk.Waiters() == 0
+	}, time.Second, time.Millisecond)
+}
+
+// flakyRedis 模拟 Redis 故障
+type flakyRedis struct {
+	redis.Cmdable
+	broken atomic.Bool
+}
+
+func (f *flakyRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	if f.broken.Load() {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(errors.New("Redis 崩溃了"))
+		return cmd
+	}
+	return f.Cmdable.Eval(ctx, script, keys, args...)
+}
@@ -0,0 +1,157 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+// AIMD 加性增、乘性减，和 TCP 的拥塞控制一样
+// 请求被丢弃或者超时就把上限乘以 BackoffRatio，否则上限加一
+type AIMD struct {
+	limit    float64
+	minLimit int
+	maxLimit int
+	// 响应时间超过这个值也当作丢弃
+	timeout      time.Duration
+	backoffRatio float64
+}
+
+func NewAIMD(initial, minLimit, maxLimit int, timeout time.Duration, backoffRatio float64) *AIMD {
+	return &AIMD{
+		limit:        float64(initial),
+		minLimit:     minLimit,
+		maxLimit:     maxLimit,
+		timeout:      timeout,
+		backoffRatio: backoffRatio,
+	}
+}
+
+func (a *AIMD) Limit() int {
+	return int(a.limit)
+}
+
+func (a *AIMD) Update(rtt time.Duration, inFlight int, dropped bool) int {
+	if dropped || rtt > a.timeout {
+		a.limit = a.limit * a.backoffRatio
+	} else if inFlight*2 >= int(a.limit) {
+		// 并发数远远没到上限的时候，说明不是上限在限制吞吐量，这时候不用提高上限
+		a.limit++
+	}
+	a.limit = clamp(a.limit, a.minLimit, a.maxLimit)
+	return int(a.limit)
+}
+
+// Vegas 参考 TCP Vegas
+// 用最小的响应时间估算没有排队时的响应时间 rttNoLoad，
+// 那么排队的请求数大约是 limit × (1 - rttNoLoad / rtt)，
+// 排队的请求少于 alpha 就提高上限，多于 beta 就降低上限
+type Vegas struct {
+	limit     float64
+	minLimit  int
+	maxLimit  int
+	rttNoLoad time.Duration
+	// 每隔多少个样本重新探测一次 rttNoLoad，避免网络变化之后一直用旧的值
+	probeInterval int
+	samples       int
+}
+
+func NewVegas(initial, minLimit, maxLimit, probeInterval int) *Vegas {
+	return &Vegas{
+		limit:         float64(initial),
+		minLimit:      minLimit,
+		maxLimit:      maxLimit,
+		probeInterval: probeInterval,
+	}
+}
+
+func (v *Vegas) Limit() int {
+	return int(v.limit)
+}
+
+func (v *Vegas) Update(rtt time.Duration, inFlight int, dropped bool) int {
+	v.samples++
+	if v.probeInterval > 0 && v.samples >= v.probeInterval {
+		v.samples = 0
+		v.rttNoLoad = 0
+	}
+	if v.rttNoLoad == 0 || rtt < v.rttNoLoad {
+		v.rttNoLoad = rtt
+		return int(v.limit)
+	}
+	// 上限越大，允许排队的请求越多
+	step := math.Max(1, math.Log10(v.limit))
+	alpha, beta := 3*step, 6*step
+	queue := math.Ceil(v.limit * (1 - float64(v.rttNoLoad)/float64(rtt)))
+	switch {
+	case dropped:
+		v.limit -= step
+	case queue <= alpha && inFlight*2 >= int(v.limit):
+		v.limit += step
+	case queue > beta:
+		v.limit -= step
+	}
+	v.limit = clamp(v.limit, v.minLimit, v.maxLimit)
+	return int(v.limit)
+}
+
+// Gradient2 参考 Netflix concurrency-limits 的 Gradient2
+// 用响应时间的长期均值和当前样本的比值作为梯度，梯度小于 1 说明响应时间在变长，
+// 新的上限 = 上限 × 梯度 + 允许排队的请求数，再做一次平滑
+type Gradient2 struct {
+	limit    float64
+	minLimit int
+	maxLimit int
+	// 长期均值，指数移动平均
+	longRTT float64
+	// 长期均值的窗口，也就是多少个样本
+	window int
+	// 容忍响应时间变长多少，比如说 1.5 代表响应时间变长 50% 以内都不降低上限
+	tolerance float64
+	smoothing float64
+}
+
+func NewGradient2(initial, minLimit, maxLimit, window int, tolerance, smoothing float64) *Gradient2 {
+	return &Gradient2{
+		limit:     float64(initial),
+		minLimit:  minLimit,
+		maxLimit:  maxLimit,
+		window:    window,
+		tolerance: tolerance,
+		smoothing: smoothing,
+	}
+}
+
+func (g *Gradient2) Limit() int {
+	return int(g.limit)
+}
+
+func (g *Gradient2) Update(rtt time.Duration, inFlight int, dropped bool) int {
+	short := float64(rtt)
+	if g.longRTT == 0 {
+		g.longRTT = short
+	} else {
+		factor := 2 / float64(g.window+1)
+		g.longRTT = g.longRTT*(1-factor) + short*factor
+	}
+	// 负载降下来之后，长期均值要更快地跟上，不然会一直以为自己很空闲
+	if g.longRTT/short > 2 {
+		g.longRTT *= 0.95
+	}
+	// 并发数不到上限的一半，说明不是上限在限制吞吐量，不用提高上限
+	if float64(inFlight) < g.limit/2 {
+		return int(g.limit)
+	}
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/short))
+	if dropped {
+		gradient = 0.5
+	}
+	queue := math.Sqrt(g.limit)
+	newLimit := g.limit*gradient + queue
+	newLimit = g.limit*(1-g.smoothing) + newLimit*g.smoothing
+	g.limit = clamp(newLimit, g.minLimit, g.maxLimit)
+	return int(g.limit)
+}
+
+func clamp(val float64, minVal, maxVal int) float64 {
+	return math.Max(float64(minVal), math.Min(float64(maxVal), val))
+}
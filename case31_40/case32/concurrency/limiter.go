@@ -0,0 +1,158 @@
+// Package concurrency 自适应的并发限流
+// 和限制 qps 不同，它限制的是同时在处理的请求数，并且根据观察到的响应时间动态调整这个上限：
+// 响应时间变长说明请求开始排队了，就降低上限；响应时间稳定就慢慢提高上限
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"interview-cases/clock"
+	"sync"
+	"time"
+)
+
+var ErrLimitExceeded = errors.New("超过了并发上限")
+
+// Algorithm 根据响应时间的样本计算新的并发上限
+// Limiter 会在持有锁的情况下调用，所以实现不需要考虑并发安全
+type Algorithm interface {
+	// Limit 当前的并发上限
+	Limit() int
+	// Update 一个请求结束了，rtt 是它的响应时间，inFlight 是它开始时的并发数，
+	// dropped 代表请求超时或者被下游拒绝了，返回新的并发上限
+	Update(rtt time.Duration, inFlight int, dropped bool) int
+}
+
+// Limiter 并发限流器
+// 并发数达到上限之后，新的请求会排队等待，队列满了或者等待超时就拒绝
+type Limiter struct {
+	alg      Algorithm
+	maxQueue int
+	timeout  time.Duration
+	clock    clock.Clock
+
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	// 排队的请求，元素是 chan struct{}，轮到它的时候会被关闭
+	queue *list.List
+}
+
+// NewLimiter maxQueue 为 0 代表不排队，超过上限直接拒绝
+func NewLimiter(alg Algorithm, maxQueue int, timeout time.Duration) *Limiter {
+	return NewLimiterWithClock(alg, maxQueue, timeout, clock.New())
+}
+
+func NewLimiterWithClock(alg Algorithm, maxQueue int, timeout time.Duration, clk clock.Clock) *Limiter {
+	return &Limiter{
+		alg:      alg,
+		maxQueue: maxQueue,
+		timeout:  timeout,
+		clock:    clk,
+		limit:    alg.Limit(),
+		queue:    list.New(),
+	}
+}
+
+// Acquire 申请执行一个请求，成功之后一定要调用返回的 Listener 的其中一个方法
+func (l *Limiter) Acquire(ctx context.Context) (*Listener, error) {
+	l.mu.Lock()
+	if l.inFlight < l.limit {
+		l.inFlight++
+		res := l.newListener()
+		l.mu.Unlock()
+		return res, nil
+	}
+	if l.queue.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, ErrLimitExceeded
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := l.clock.NewTimer(l.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+	case <-timer.C():
+		err = ErrLimitExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// 唤醒的时候名额已经占住了，即便同时超时了也继续执行，不然这个名额就浪费了
+		return l.newListener(), nil
+	default:
+		l.queue.Remove(elem)
+		return nil, err
+	}
+}
+
+// newListener 调用者要持有锁，并且已经占住了名额
+func (l *Limiter) newListener() *Listener {
+	return &Listener{l: l, start: l.clock.Now(), inFlight: l.inFlight}
+}
+
+// Limit 当前的并发上限
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// InFlight 正在处理的请求数
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+func (l *Limiter) release(rtt time.Duration, inFlight int, dropped, ignore bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if !ignore {
+		l.limit = max(1, l.alg.Update(rtt, inFlight, dropped))
+	}
+	// 按照先来后到唤醒排队的请求，唤醒的时候就把名额占住了
+	for l.inFlight < l.limit && l.queue.Len() > 0 {
+		ready := l.queue.Remove(l.queue.Front()).(chan struct{})
+		close(ready)
+		l.inFlight++
+	}
+}
+
+// Listener 代表一个已经拿到名额的请求
+type Listener struct {
+	l        *Limiter
+	start    time.Time
+	inFlight int
+	once     sync.Once
+}
+
+// OnSuccess 请求正常结束
+func (r *Listener) OnSuccess() {
+	r.once.Do(func() {
+		r.l.release(r.l.clock.Since(r.start), r.inFlight, false, false)
+	})
+}
+
+// OnDropped 请求超时或者被下游限流，算法会更快地降低上限
+func (r *Listener) OnDropped() {
+	r.once.Do(func() {
+		r.l.release(r.l.clock.Since(r.start), r.inFlight, true, false)
+	})
+}
+
+// OnIgnore 请求不参与统计，比如说参数错误这种很快就返回的请求
+func (r *Listener) OnIgnore() {
+	r.once.Do(func() {
+		r.l.release(0, r.inFlight, false, true)
+	})
+}
@@ -0,0 +1,256 @@
+package concurrency
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fixed 固定上限，用来测试排队
+type fixed int
+
+func (f fixed) Limit() int {
+	return int(f)
+}
+
+func (f fixed) Update(rtt time.Duration, inFlight int, dropped bool) int {
+	return int(f)
+}
+
+// countDropped 上限固定为 1，记录被丢弃的请求数
+type countDropped struct {
+	dropped int
+}
+
+func (c *countDropped) Limit() int {
+	return 1
+}
+
+func (c *countDropped) Update(rtt time.Duration, inFlight int, dropped bool) int {
+	if dropped {
+		c.dropped++
+	}
+	return 1
+}
+
+func TestLimiter_Queue(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	l := NewLimiterWithClock(fixed(2), 1, 100*time.Millisecond, clk)
+	ctx := context.Background()
+	first, err := l.Acquire(ctx)
+	require.NoError(t, err)
+	_, err = l.Acquire(ctx)
+	require.NoError(t, err)
+
+	// 第三个请求排队，第四个请求队列满了直接拒绝
+	queued := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(ctx)
+		queued <- err
+	}()
+	clk.BlockUntil(1)
+	_, err = l.Acquire(ctx)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	// 有请求结束，排队的请求拿到名额
+	first.OnSuccess()
+	require.NoError(t, <-queued)
+	assert.Equal(t, 2, l.InFlight())
+	// 重复调用不会重复释放
+	first.OnSuccess()
+	assert.Equal(t, 2, l.InFlight())
+
+	// 排队超时
+	go func() {
+		_, err := l.Acquire(ctx)
+		queued <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+	assert.ErrorIs(t, <-queued, ErrLimitExceeded)
+
+	// 排队的时候 ctx 被取消
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		_, err := l.Acquire(cctx)
+		queued <- err
+	}()
+	clk.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-queued, context.Canceled)
+	assert.Equal(t, 2, l.InFlight())
+}
+
+func TestAlgorithms(t *testing.T) {
+	// 每个请求结束的时候并发数都打满了上限
+	feed := func(alg Algorithm, rtt time.Duration, dropped bool, n int) int {
+		for i := 0; i < n; i++ {
+			alg.Update(rtt, alg.Limit(), dropped)
+		}
+		return alg.Limit()
+	}
+	testCases := []struct {
+		name string
+		alg  Algorithm
+		// 响应时间变长之后的响应时间，以及是否算作丢弃
+		slowRTT time.Duration
+		dropped bool
+	}{
+		{
+			name:    "aimd",
+			alg:     NewAIMD(10, 1, 200, 30*time.Millisecond, 0.9),
+			slowRTT: 50 * time.Millisecond,
+		},
+		{
+			name:    "aimd dropped",
+			alg:     NewAIMD(10, 1, 200, time.Second, 0.9),
+			slowRTT: 10 * time.Millisecond,
+			dropped: true,
+		},
+		{
+			name:    "vegas",
+			alg:     NewVegas(10, 1, 200, 1000),
+			slowRTT: 30 * time.Millisecond,
+		},
+		{
+			name:    "gradient2",
+			alg:     NewGradient2(10, 1, 200, 100, 1.5, 0.2),
+			slowRTT: 40 * time.Millisecond,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 响应时间稳定，上限逐渐提高
+			grown := feed(tc.alg, 10*time.Millisecond, false, 50)
+			assert.Greater(t, grown, 10)
+			// 响应时间变长，上限降低
+			shrunk := feed(tc.alg, tc.slowRTT, tc.dropped, 20)
+			assert.Less(t, shrunk, grown)
+			assert.GreaterOrEqual(t, shrunk, 1)
+			t.Logf("上限从 10 提高到 %d，又降低到 %d", grown, shrunk)
+		})
+	}
+}
+
+func TestAlgorithms_AppLimited(t *testing.T) {
+	// 并发数远远没有到上限的时候不提高上限
+	for _, alg := range []Algorithm{
+		NewAIMD(10, 1, 200, time.Second, 0.9),
+		NewVegas(10, 1, 200, 1000),
+		NewGradient2(10, 1, 200, 100, 1.5, 0.2),
+	} {
+		for i := 0; i < 50; i++ {
+			alg.Update(10*time.Millisecond, 1, false)
+		}
+		assert.Equal(t, 10, alg.Limit())
+	}
+}
+
+func TestBuildGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := NewLimiter(fixed(1), 0, time.Second)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	server := gin.New()
+	server.Use(BuildGinMiddleware(l))
+	server.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-block
+		c.Status(http.StatusOK)
+	})
+	done := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- recorder.Code
+	}()
+	<-started
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	// 排队超时的时间就是建议的重试时间
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "触发了并发限流", recorder.Body.String())
+	close(block)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, 0, l.InFlight())
+}
+
+func TestBuildServerInterceptor(t *testing.T) {
+	l := NewLimiter(fixed(1), 0, time.Second)
+	interceptor := BuildServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.TestService/Test"}
+	// 在处理请求的过程中再发一个请求，会被拒绝
+	resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		retryAfter, ok := reject.RetryAfter(err)
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, retryAfter)
+		details := status.Convert(err).Details()
+		require.Len(t, details, 2)
+		quota, ok := details[0].(*errdetails.QuotaFailure)
+		require.True(t, ok)
+		assert.Equal(t, "触发了并发限流", quota.GetViolations()[0].GetDescription())
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	assert.Equal(t, 0, l.InFlight())
+}
+
+// handler panic 的时候名额也要释放，并且算作被丢弃
+func TestBuildGinMiddleware_Panic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	alg := &countDropped{}
+	l := NewLimiter(alg, 0, time.Second)
+	server := gin.New()
+	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}), BuildGinMiddleware(l))
+	server.GET("/panic", func(c *gin.Context) {
+		panic("handler panic")
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, 0, l.InFlight())
+	assert.Equal(t, 1, alg.dropped)
+}
+
+func TestBuildServerInterceptor_Panic(t *testing.T) {
+	alg := &countDropped{}
+	l := NewLimiter(alg, 0, time.Second)
+	interceptor := BuildServerInterceptor(l)
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.TestService/Test"}
+	assert.PanicsWithValue(t, "handler panic", func() {
+		_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("handler panic")
+		})
+	})
+	assert.Equal(t, 0, l.InFlight())
+	assert.Equal(t, 1, alg.dropped)
+
+	// 正常返回的请求只释放一次
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, l.InFlight())
+	assert.Equal(t, 1, alg.dropped)
+}
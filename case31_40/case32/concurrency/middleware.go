@@ -0,0 +1,62 @@
+package concurrency
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/reject"
+	"net/http"
+)
+
+// BuildGinMiddleware 可以直接传给 case32.StartServer
+func BuildGinMiddleware(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listener, err := l.Acquire(c.Request.Context())
+		if err != nil {
+			reject.AbortGin(c, l.rejectInfo())
+			return
+		}
+		// 后面的 handler panic 的时候按照被丢弃释放名额，正常返回的时候已经释放过了，这里不会重复释放
+		defer listener.OnDropped()
+		c.Next()
+		switch c.Writer.Status() {
+		case http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+			listener.OnDropped()
+		default:
+			listener.OnSuccess()
+		}
+	}
+}
+
+// BuildServerInterceptor 用于 case11、case17 的 gRPC 服务端
+func BuildServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		listener, err := l.Acquire(ctx)
+		if err != nil {
+			return nil, reject.Error(l.rejectInfo())
+		}
+		defer listener.OnDropped()
+		resp, err := handler(ctx, req)
+		switch status.Code(err) {
+		case codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+			listener.OnDropped()
+		case codes.InvalidArgument:
+			listener.OnIgnore()
+		default:
+			listener.OnSuccess()
+		}
+		return resp, err
+	}
+}
+
+// rejectInfo 并发限流没有时间窗口，排队超时的时间就是建议的重试时间
+func (l *Limiter) rejectInfo() reject.Info {
+	return reject.Info{
+		Limit:       l.Limit(),
+		Remaining:   l.Limit() - l.InFlight(),
+		RetryAfter:  l.timeout,
+		Description: "触发了并发限流",
+	}
+}
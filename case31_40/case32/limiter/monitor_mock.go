@@ -2,15 +2,16 @@ package limiter
 
 import (
 	"context"
-	"time"
+	"interview-cases/clock"
 )
 
 type Mock struct {
 	startTime int64
+	clock     clock.Clock
 }
 
 func (m *Mock) Qps(ctx context.Context) (int, error) {
-	now := time.Now().Unix()
+	now := m.clock.Now().Unix()
 	diff := now - m.startTime
 	if diff <= 7 {
 		return 1200, nil
@@ -0,0 +1,303 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"interview-cases/case31_40/case32/monitor"
+	"interview-cases/clock"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// 默认配置里面用到的状态，自定义配置可以使用任意的状态名
+	StateHealthy    = "healthy"
+	StateLimited    = "limited"
+	StateRecovering = "recovering"
+)
+
+// tierCtxKey 不导出的类型做 key，不会和别的包放进 ctx 里面的值冲突
+type tierCtxKey struct{}
+
+// CtxWithTier 标记请求所属的等级
+func CtxWithTier(ctx context.Context, tier string) context.Context {
+	return context.WithValue(ctx, tierCtxKey{}, tier)
+}
+
+// Transition 状态迁移
+// 当前处于 From 状态，qps 高于（Above 为 true）或者低于 qps 上限 × Ratio，
+// 并且持续了 Hold 这么久，就迁移到 To 状态，同时调整通过率
+type Transition struct {
+	From  string        `yaml:"from"`
+	To    string        `yaml:"to"`
+	Above bool          `yaml:"above"`
+	Ratio float64       `yaml:"ratio"`
+	Hold  time.Duration `yaml:"hold"`
+	// Step 不为 0 的时候在原来的通过率上加减，否则直接把通过率设置为 PassRate
+	PassRate int `yaml:"passRate"`
+	Step     int `yaml:"step"`
+	// 通过率恢复到 100% 之后进入的状态，一般就是健康状态
+	OnFull string `yaml:"onFull"`
+}
+
+// Tier 一个等级的用户，以及它的限流和恢复曲线
+type Tier struct {
+	Name string `yaml:"name"`
+	// 初始状态，默认是 healthy，初始通过率是 100%
+	Initial string `yaml:"initial"`
+	// 没有配置迁移的等级永远不会被限流
+	Transitions []Transition `yaml:"transitions"`
+}
+
+// TierConfig 多等级限流的配置
+type TierConfig struct {
+	QpsUpperLimit int `yaml:"qpsUpperLimit"`
+	// 多久采集一次 qps，默认一秒
+	Interval time.Duration `yaml:"interval"`
+	// 按照优先级从高到低排列
+	Tiers []Tier `yaml:"tiers"`
+	// 没有标记等级的请求归到这个等级，默认是优先级最低的等级
+	DefaultTier string `yaml:"defaultTier"`
+}
+
+// LoadTierConfig 从 yaml 文件里面读取配置
+func LoadTierConfig(path string) (TierConfig, error) {
+	var cfg TierConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = yaml.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+func (c *TierConfig) validate() error {
+	if c.QpsUpperLimit <= 0 {
+		return errors.New("qps 上限必须大于 0")
+	}
+	if len(c.Tiers) == 0 {
+		return errors.New("至少需要一个等级")
+	}
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	names := make(map[string]struct{}, len(c.Tiers))
+	for i := range c.Tiers {
+		tier := &c.Tiers[i]
+		if _, ok := names[tier.Name]; ok {
+			return fmt.Errorf("重复的等级 %s", tier.Name)
+		}
+		names[tier.Name] = struct{}{}
+		if tier.Initial == "" {
+			tier.Initial = StateHealthy
+		}
+	}
+	if c.DefaultTier == "" {
+		c.DefaultTier = c.Tiers[len(c.Tiers)-1].Name
+	}
+	if _, ok := names[c.DefaultTier]; !ok {
+		return fmt.Errorf("默认等级 %s 不存在", c.DefaultTier)
+	}
+	return nil
+}
+
+// VipTierConfig 和 VipLimiter 等价的配置，只有 vip 和普通用户两个等级
+func VipTierConfig(qpsUpperLimit int) TierConfig {
+	return TierConfig{
+		QpsUpperLimit: qpsUpperLimit,
+		Tiers: []Tier{
+			{Name: "vip"},
+			{
+				Name: "regular",
+				Transitions: []Transition{
+					{From: StateHealthy, To: StateLimited, Above: true, Ratio: 1, Hold: 5 * time.Second, PassRate: 0},
+					{From: StateLimited, To: StateRecovering, Ratio: 0.8, Hold: 5 * time.Second, PassRate: 10},
+					{From: StateRecovering, To: StateRecovering, Ratio: 0.85, Hold: 5 * time.Second, Step: 10, OnFull: StateHealthy},
+					{From: StateRecovering, To: StateRecovering, Above: true, Ratio: 1, Hold: 3 * time.Second, Step: -10},
+				},
+			},
+		},
+	}
+}
+
+// TierLimiter 多等级的限流器，是 VipLimiter 的推广
+// 每个等级都有自己的状态机，低等级的用户配置得更早限流、更晚恢复。
+// 并且低等级的通过率不会超过高等级的通过率，所以即便配置得不合理，也是低等级的用户先被限流
+type TierLimiter struct {
+	cfg      TierConfig
+	mon      monitor.Monitor
+	mu       sync.RWMutex
+	machines []*tierMachine
+	index    map[string]int
+	clock    clock.Clock
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func NewTierLimiter(cfg TierConfig, mon monitor.Monitor) (*TierLimiter, error) {
+	return NewTierLimiterWithClock(cfg, mon, clock.New())
+}
+
+// NewTierLimiterWithClock 测试的时候传入 clock.Fake，手动推进监控循环
+func NewTierLimiterWithClock(cfg TierConfig, mon monitor.Monitor, clk clock.Clock) (*TierLimiter, error) {
+	l, err := newTierLimiter(cfg, mon, clk)
+	if err != nil {
+		return nil, err
+	}
+	go l.monitorLoop()
+	return l, nil
+}
+
+func newTierLimiter(cfg TierConfig, mon monitor.Monitor, clk clock.Clock) (*TierLimiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	l := &TierLimiter{
+		cfg:      cfg,
+		mon:      mon,
+		clock:    clk,
+		stop:     make(chan struct{}),
+		machines: make([]*tierMachine, 0, len(cfg.Tiers)),
+		index:    make(map[string]int, len(cfg.Tiers)),
+	}
+	for i, tier := range cfg.Tiers {
+		l.machines = append(l.machines, &tierMachine{
+			tier:     tier,
+			state:    tier.Initial,
+			passRate: 100,
+			holds:    make([]int, len(tier.Transitions)),
+		})
+		l.index[tier.Name] = i
+	}
+	return l, nil
+}
+
+func (l *TierLimiter) Limit(ctx context.Context) (bool, error) {
+	passRate := l.passRate(l.tierOf(ctx))
+	if passRate >= 100 {
+		return false, nil
+	}
+	return rand.IntN(100) >= passRate, nil
+}
+
+func (l *TierLimiter) tierOf(ctx context.Context) int {
+	name, _ := ctx.Value(tierCtxKey{}).(string)
+	idx, ok := l.index[name]
+	if !ok {
+		return l.index[l.cfg.DefaultTier]
+	}
+	return idx
+}
+
+// passRate 实际的通过率，不超过任何一个更高等级的通过率
+func (l *TierLimiter) passRate(idx int) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rate := 100
+	for i := 0; i <= idx; i++ {
+		rate = min(rate, l.machines[i].passRate)
+	}
+	return rate
+}
+
+// Close 停止采集 qps，可以重复调用
+func (l *TierLimiter) Close() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+}
+
+func (l *TierLimiter) monitorLoop() {
+	ticker := l.clock.NewTicker(l.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			l.check()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// check 采集一次 qps 并且驱动状态机
+func (l *TierLimiter) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	qps, err := l.mon.Qps(ctx)
+	cancel()
+	if err != nil {
+		slog.Error("获取系统qps失败", slog.Any("error", err))
+		return
+	}
+	l.sample(qps)
+}
+
+// sample 每采集到一次 qps 就驱动一次所有等级的状态机
+func (l *TierLimiter) sample(qps int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.machines {
+		m.sample(qps, l.cfg.QpsUpperLimit, l.cfg.Interval)
+	}
+}
+
+// getStateAndPassRate 返回某个等级的状态和通过率
+func (l *TierLimiter) getStateAndPassRate(tier string) (string, int) {
+	idx := l.index[tier]
+	rate := l.passRate(idx)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.machines[idx].state, rate
+}
+
+type tierMachine struct {
+	tier     Tier
+	state    string
+	passRate int
+	// 每个迁移的条件已经连续满足了多少次
+	holds []int
+}
+
+func (m *tierMachine) sample(qps, upperLimit int, interval time.Duration) {
+	for i, t := range m.tier.Transitions {
+		if t.From != m.state {
+			m.holds[i] = 0
+			continue
+		}
+		threshold := float64(upperLimit) * t.Ratio
+		if (t.Above && float64(qps) >= threshold) || (!t.Above && float64(qps) < threshold) {
+			m.holds[i]++
+		} else {
+			m.holds[i] = 0
+		}
+	}
+	for i, t := range m.tier.Transitions {
+		if t.From != m.state || m.holds[i] == 0 || time.Duration(m.holds[i])*interval < t.Hold {
+			continue
+		}
+		m.fire(t)
+		return
+	}
+}
+
+func (m *tierMachine) fire(t Transition) {
+	for i := range m.holds {
+		m.holds[i] = 0
+	}
+	if t.Step != 0 {
+		m.passRate += t.Step
+	} else {
+		m.passRate = t.PassRate
+	}
+	m.passRate = max(0, min(100, m.passRate))
+	m.state = t.To
+	if t.OnFull != "" && m.passRate >= 100 {
+		m.state = t.OnFull
+	}
+	slog.Info(fmt.Sprintf("等级 %s 进入 %s 状态，通过率设为 %d%%", m.tier.Name, m.state, m.passRate))
+}
@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case31_40/case32/monitor"
+	"interview-cases/clock"
+	"testing"
+	"time"
+)
+
+func TestLoadTierConfig(t *testing.T) {
+	cfg, err := LoadTierConfig("tier.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, cfg.QpsUpperLimit)
+	assert.Equal(t, time.Second, cfg.Interval)
+	require.Len(t, cfg.Tiers, 3)
+	assert.Equal(t, Transition{
+		From: StateRecovering, To: StateRecovering, Ratio: 0.6,
+		Hold: 3 * time.Second, Step: 20, OnFull: StateHealthy,
+	}, cfg.Tiers[2].Transitions[2])
+}
+
+func TestTierLimiter(t *testing.T) {
+	cfg, err := LoadTierConfig("tier.yaml")
+	require.NoError(t, err)
+	// 不启动监控，直接喂 qps 驱动状态机
+	limiter, err := newTierLimiter(cfg, nil, clock.New())
+	require.NoError(t, err)
+	feed := func(qps, times int) {
+		for i := 0; i < times; i++ {
+			limiter.sample(qps)
+		}
+	}
+	assertTier := func(tier, state string, passRate int) {
+		s, r := limiter.getStateAndPassRate(tier)
+		assert.Equal(t, state, s, tier)
+		assert.Equal(t, passRate, r, tier)
+	}
+
+	// 超过 80%，游客先被限流
+	feed(900, 2)
+	assertTier("guest", StateLimited, 0)
+	assertTier("regular", StateHealthy, 100)
+
+	// 超过上限，普通用户也被限流，vip 不受影响
+	feed(1200, 3)
+	assertTier("guest", StateLimited, 0)
+	assertTier("regular", StateLimited, 0)
+	assertTier("vip", StateHealthy, 100)
+	ok, err := limiter.Limit(CtxWithTier(context.Background(), "vip"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	// 没有标记等级的请求当作游客
+	ok, err = limiter.Limit(context.Background())
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 流量下降，普通用户先开始恢复
+	feed(500, 2)
+	assertTier("regular", StateRecovering, 50)
+	assertTier("guest", StateLimited, 0)
+	feed(500, 1)
+	assertTier("guest", StateRecovering, 20)
+
+	// 普通用户已经恢复健康，游客还在慢慢恢复
+	feed(500, 1)
+	assertTier("regular", StateHealthy, 100)
+	assertTier("guest", StateRecovering, 20)
+
+	// 游客恢复的过程中流量又上来了，通过率下降
+	feed(900, 1)
+	assertTier("guest", StateRecovering, 0)
+	assertTier("regular", StateHealthy, 100)
+
+	samples := 0
+	for s, _ := limiter.getStateAndPassRate("guest"); s != StateHealthy && samples < 100; s, _ = limiter.getStateAndPassRate("guest") {
+		feed(500, 1)
+		samples++
+	}
+	assertTier("guest", StateHealthy, 100)
+	// 每 3 秒恢复 20%
+	assert.Equal(t, 15, samples)
+}
+
+func TestTierLimiter_passRate(t *testing.T) {
+	limiter, err := newTierLimiter(VipTierConfig(1000), nil, clock.New())
+	require.NoError(t, err)
+	limiter.machines[0].passRate = 30
+	// 低等级的通过率不会超过高等级
+	_, rate := limiter.getStateAndPassRate("regular")
+	assert.Equal(t, 30, rate)
+}
+
+// 测试场景
+// 监控循环按照 clk 采集 qps，Mock 前 7 秒的 qps 是 1200，普通用户持续 5 秒超过上限之后被限流
+func TestTierLimiter_Monitor(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	mon := &notifyMon{Monitor: &Mock{startTime: clk.Now().Unix(), clock: clk}, called: make(chan struct{})}
+	limiter, err := NewTierLimiterWithClock(VipTierConfig(1000), mon, clk)
+	require.NoError(t, err)
+	clk.BlockUntil(1)
+	for i := 0; i < 5; i++ {
+		clk.Advance(time.Second)
+		<-mon.called
+	}
+	assert.Eventually(t, func() bool {
+		state, _ := limiter.getStateAndPassRate("regular")
+		return state == StateLimited
+	}, time.Second, time.Millisecond)
+
+	limiter.Close()
+	assert.NotPanics(t, limiter.Close)
+	assert.Eventually(t, func() bool {
+		return clk.Waiters() == 0
+	}, time.Second, time.Millisecond)
+}
+
+// notifyMon 每次采集 qps 都通知一下，测试等到采集完了再推进时间
+type notifyMon struct {
+	monitor.Monitor
+	called chan struct{}
+}
+
+func (m *notifyMon) Qps(ctx context.Context) (int, error) {
+	defer func() {
+		m.called <- struct{}{}
+	}()
+	return m.Monitor.Qps(ctx)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"interview-cases/case31_40/case32/monitor"
+	"interview-cases/clock"
 	"log/slog"
 	"math/rand/v2"
 	"sync"
@@ -26,14 +27,34 @@ type VipLimiter struct {
 	regularUserPassRate int
 	state               int
 	mon                 monitor.Monitor
+	// 依据哪个指标限流，默认是 qps
+	signal monitor.Signal
+	clock  clock.Clock
 }
 
 func NewVipLimiter(qpsUpperLimit int, mon monitor.Monitor) *VipLimiter {
+	return newVipLimiter(qpsUpperLimit, mon, monitor.SignalQps, clock.New())
+}
+
+// NewVipLimiterWithSignal 依据其它指标限流，比如说并发数、错误率或者响应时间
+// 这时候 qpsUpperLimit 就是这个指标的上限，错误率是百分比，响应时间是毫秒
+func NewVipLimiterWithSignal(upperLimit int, mon monitor.SignalMonitor, signal monitor.Signal) *VipLimiter {
+	return newVipLimiter(upperLimit, mon, signal, clock.New())
+}
+
+// NewVipLimiterWithClock 测试的时候传入 clock.Fake，手动推进监控循环
+func NewVipLimiterWithClock(qpsUpperLimit int, mon monitor.Monitor, clk clock.Clock) *VipLimiter {
+	return newVipLimiter(qpsUpperLimit, mon, monitor.SignalQps, clk)
+}
+
+func newVipLimiter(upperLimit int, mon monitor.Monitor, signal monitor.Signal, clk clock.Clock) *VipLimiter {
 	limiter := &VipLimiter{
-		qpsUpperLimit: qpsUpperLimit,
+		qpsUpperLimit: upperLimit,
 		mon:           mon,
 		mu:            &sync.RWMutex{},
 		state:         HealthyState,
+		signal:        signal,
+		clock:         clk,
 	}
 	go limiter.monitorLoop()
 	return limiter
@@ -83,14 +104,24 @@ func (v *VipLimiter) monitorLoop() {
 	var belowThresholdCount, aboveThresholdCount int
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		qps, err := v.mon.Qps(ctx)
+		qps, err := v.read(ctx)
 		cancel()
 		if err != nil {
 			slog.Error("获取系统qps失败", slog.Any("error", err))
 		}
 		belowThresholdCount, aboveThresholdCount = v.rateLimit(qps, belowThresholdCount, aboveThresholdCount)
-		time.Sleep(1 * time.Second)
+		v.clock.Sleep(1 * time.Second)
+	}
+}
+
+// read 读取限流依据的指标
+func (v *VipLimiter) read(ctx context.Context) (int, error) {
+	if v.signal == monitor.SignalQps {
+		return v.mon.Qps(ctx)
 	}
+	// 构造的时候已经保证了是 SignalMonitor
+	val, err := v.mon.(monitor.SignalMonitor).Signal(ctx, v.signal)
+	return int(val), err
 }
 
 func (v *VipLimiter) rateLimit(qps int, belowThresholdCount, aboveThresholdCount int) (int, int) {
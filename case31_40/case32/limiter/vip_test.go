@@ -1,49 +1,86 @@
 package limiter
 
 import (
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case31_40/case32/monitor"
+	"interview-cases/clock"
 	"testing"
 	"time"
 )
 
-
-
 func TestVip(t *testing.T) {
-	limiter := NewVipLimiter(1000, &Mock{
-		startTime: time.Now().Unix(),
-	})
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	limiter := NewVipLimiterWithClock(1000, &Mock{
+		startTime: clk.Now().Unix(),
+		clock:     clk,
+	}, clk)
+	// 监控循环每秒采集一次，推进 n 秒之后等它处理完
+	sleep := func(n int) {
+		for i := 0; i < n; i++ {
+			clk.BlockUntil(1)
+			clk.Advance(time.Second)
+		}
+		clk.BlockUntil(1)
+	}
 	// vip用户能正常访问
 
-	time.Sleep(7 * time.Second)
+	sleep(7)
 	// 触发限流
 	state, passRate := limiter.getStateAndPassRate()
 	assert.Equal(t, RateLimitState, state)
 
 	// 触发限流恢复
-	time.Sleep(6 * time.Second)
+	sleep(6)
 	state, passRate = limiter.getStateAndPassRate()
 	assert.Equal(t, RecoveringState, state)
 	// 普通用户只有10%被放行
 	assert.Equal(t, 10, passRate)
 
 	// 继续扩大普通用户的流量
-	time.Sleep(6 * time.Second)
+	sleep(6)
 	state, passRate = limiter.getStateAndPassRate()
 	assert.Equal(t, RecoveringState, state)
 	// 普通用户20%被放行
 	assert.Equal(t, 20, passRate)
 
 	// 超过阈值开始减少普通用户的流量
-	time.Sleep(4 * time.Second)
+	sleep(4)
 	state, passRate = limiter.getStateAndPassRate()
 	assert.Equal(t, RecoveringState, state)
 	// 普通用户20%被放行
 	assert.Equal(t, 10, passRate)
 
 	// 恢复健康所有用户都可以处理
-	time.Sleep(46 * time.Second)
+	sleep(46)
 	state, passRate = limiter.getStateAndPassRate()
 	assert.Equal(t, HealthyState, state)
 	assert.Equal(t, 100, passRate)
 
 }
+
+type signalMon struct {
+	Mock
+	val float64
+}
+
+func (s *signalMon) Signal(ctx context.Context, signal monitor.Signal) (float64, error) {
+	if signal != monitor.SignalConcurrency {
+		return 0, errors.New("未知的监控指标")
+	}
+	return s.val, nil
+}
+
+func TestVipLimiter_read(t *testing.T) {
+	limiter := NewVipLimiterWithSignal(100, &signalMon{val: 120}, monitor.SignalConcurrency)
+	val, err := limiter.read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 120, val)
+
+	limiter = NewVipLimiter(1000, &Mock{startTime: time.Now().Unix(), clock: clock.New()})
+	val, err = limiter.read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1200, val)
+}
@@ -5,6 +5,9 @@ import (
 	"sync/atomic"
 )
 
+// RateLimitMon 名不副实，Qps 返回的其实是正在处理的请求数
+//
+// Deprecated: 请使用 WindowMon
 type RateLimitMon struct {
 	count int32
 }
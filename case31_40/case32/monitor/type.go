@@ -2,9 +2,11 @@ package monitor
 
 import (
 	"context"
+	"errors"
 )
 
+var errUnknownSignal = errors.New("未知的监控指标")
+
 type Monitor interface {
 	Qps(ctx context.Context) (int, error)
 }
-
@@ -0,0 +1,211 @@
+package monitor
+
+import (
+	"context"
+	"interview-cases/clock"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Signal 限流器可以依据的指标
+type Signal int
+
+const (
+	// SignalQps 每秒到达的请求数，包括被限流的请求
+	SignalQps Signal = iota
+	// SignalConcurrency 正在处理的请求数
+	SignalConcurrency
+	// SignalErrorRate 错误率，百分比
+	SignalErrorRate
+	// SignalP50 SignalP90 SignalP99 响应时间的分位数，毫秒
+	SignalP50
+	SignalP90
+	SignalP99
+)
+
+// SignalMonitor 除了 qps 之外还能提供其它指标的监控
+type SignalMonitor interface {
+	Monitor
+	Signal(ctx context.Context, signal Signal) (float64, error)
+}
+
+// Stats 窗口内的统计数据
+type Stats struct {
+	Qps         float64
+	Concurrency int
+	// 百分比
+	ErrorRate float64
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+}
+
+const (
+	// 响应时间直方图，第 i 个桶的上界是 minLatency × latencyFactor^i
+	// 一共 64 个桶，覆盖 100us 到一分多钟
+	minLatency    = 100 * time.Microsecond
+	latencyFactor = 1.25
+	latencyBins   = 64
+)
+
+type windowBucket struct {
+	// 到达的请求数
+	requests int64
+	// 处理完的请求数，以及其中失败的
+	observed int64
+	errors   int64
+	latency  [latencyBins]int64
+}
+
+// WindowMon 基于滑动窗口的监控
+// 窗口被切分成若干个桶，每个桶记录这段时间内的请求数、错误数和响应时间的直方图
+type WindowMon struct {
+	mu             sync.Mutex
+	buckets        []windowBucket
+	bucketDuration time.Duration
+	cur            int
+	curStart       time.Time
+	// 监控开始的时间，刚启动的时候窗口还没有填满
+	start time.Time
+
+	concurrency atomic.Int64
+	clock       clock.Clock
+}
+
+func NewWindowMon(window time.Duration, buckets int) *WindowMon {
+	return NewWindowMonWithClock(window, buckets, clock.New())
+}
+
+// NewWindowMonWithClock 窗口的滑动由 clk 决定
+func NewWindowMonWithClock(window time.Duration, buckets int, clk clock.Clock) *WindowMon {
+	start := clk.Now()
+	return &WindowMon{
+		buckets:        make([]windowBucket, buckets),
+		bucketDuration: window / time.Duration(buckets),
+		curStart:       start,
+		start:          start,
+		clock:          clk,
+	}
+}
+
+// InCr 请求到达，不管后面有没有被限流都要调用
+func (w *WindowMon) InCr() {
+	w.concurrency.Add(1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(w.clock.Now())
+	w.buckets[w.cur].requests++
+}
+
+// Decr 请求结束
+func (w *WindowMon) Decr() {
+	w.concurrency.Add(-1)
+}
+
+// Observe 记录处理完的请求的响应时间和结果，被限流的请求不要调用
+func (w *WindowMon) Observe(latency time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(w.clock.Now())
+	b := &w.buckets[w.cur]
+	b.observed++
+	if failed {
+		b.errors++
+	}
+	b.latency[latencyBin(latency)]++
+}
+
+func (w *WindowMon) Qps(ctx context.Context) (int, error) {
+	return int(math.Round(w.Stats().Qps)), nil
+}
+
+func (w *WindowMon) Signal(ctx context.Context, signal Signal) (float64, error) {
+	stats := w.Stats()
+	switch signal {
+	case SignalQps:
+		return stats.Qps, nil
+	case SignalConcurrency:
+		return float64(stats.Concurrency), nil
+	case SignalErrorRate:
+		return stats.ErrorRate, nil
+	case SignalP50:
+		return float64(stats.P50) / float64(time.Millisecond), nil
+	case SignalP90:
+		return float64(stats.P90) / float64(time.Millisecond), nil
+	case SignalP99:
+		return float64(stats.P99) / float64(time.Millisecond), nil
+	default:
+		return 0, errUnknownSignal
+	}
+}
+
+func (w *WindowMon) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.clock.Now()
+	w.advance(now)
+	var (
+		requests, observed, errors int64
+		latency                    [latencyBins]int64
+	)
+	for i, b := range w.buckets {
+		observed += b.observed
+		errors += b.errors
+		for j, cnt := range b.latency {
+			latency[j] += cnt
+		}
+		// 当前桶还没结束，算 qps 的时候不参与
+		if i != w.cur {
+			requests += b.requests
+		}
+	}
+	stats := Stats{Concurrency: int(w.concurrency.Load())}
+	// 刚启动的时候，已经结束的桶不足整个窗口
+	elapsed := min(time.Duration(len(w.buckets)-1)*w.bucketDuration, w.curStart.Sub(w.start))
+	if elapsed > 0 {
+		stats.Qps = float64(requests) / elapsed.Seconds()
+	}
+	if observed > 0 {
+		stats.ErrorRate = float64(errors) * 100 / float64(observed)
+		stats.P50 = percentile(latency, observed, 0.5)
+		stats.P90 = percentile(latency, observed, 0.9)
+		stats.P99 = percentile(latency, observed, 0.99)
+	}
+	return stats
+}
+
+// advance 把窗口滑动到当前时间，过期的桶清零，调用者要持有锁
+func (w *WindowMon) advance(now time.Time) {
+	elapsed := int(now.Sub(w.curStart) / w.bucketDuration)
+	if elapsed <= 0 {
+		return
+	}
+	for i := 0; i < min(elapsed, len(w.buckets)); i++ {
+		w.cur = (w.cur + 1) % len(w.buckets)
+		w.buckets[w.cur] = windowBucket{}
+	}
+	w.curStart = w.curStart.Add(time.Duration(elapsed) * w.bucketDuration)
+}
+
+func latencyBin(latency time.Duration) int {
+	if latency <= minLatency {
+		return 0
+	}
+	bin := int(math.Ceil(math.Log(float64(latency)/float64(minLatency)) / math.Log(latencyFactor)))
+	return min(bin, latencyBins-1)
+}
+
+// percentile 返回分位数所在的桶的上界
+func percentile(latency [latencyBins]int64, total int64, p float64) time.Duration {
+	target := int64(math.Ceil(float64(total) * p))
+	var cnt int64
+	for i, c := range latency {
+		cnt += c
+		if cnt >= target {
+			return time.Duration(float64(minLatency) * math.Pow(latencyFactor, float64(i)))
+		}
+	}
+	return time.Duration(float64(minLatency) * math.Pow(latencyFactor, latencyBins-1))
+}
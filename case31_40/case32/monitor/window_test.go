@@ -0,0 +1,53 @@
+package monitor
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/clock"
+	"testing"
+	"time"
+)
+
+func TestWindowMon(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	// 窗口 5 秒，每个桶 1 秒
+	mon := NewWindowMonWithClock(5*time.Second, 5, clk)
+	// 前两秒每秒 100 个请求，其中 10 个失败
+	for sec := 0; sec < 2; sec++ {
+		for i := 0; i < 100; i++ {
+			mon.InCr()
+			mon.Observe(time.Duration(i+1)*time.Millisecond, i < 10)
+			mon.Decr()
+		}
+		clk.Advance(time.Second)
+	}
+	// 还有两个请求在处理中
+	mon.InCr()
+	mon.InCr()
+
+	stats := mon.Stats()
+	// 当前桶还没有结束，只有两个完整的桶
+	assert.InDelta(t, 100, stats.Qps, 0.01)
+	assert.Equal(t, 2, stats.Concurrency)
+	assert.InDelta(t, 10, stats.ErrorRate, 0.01)
+	// 直方图的精度是 25%
+	assert.InDelta(t, 50*time.Millisecond, stats.P50, float64(13*time.Millisecond))
+	assert.InDelta(t, 90*time.Millisecond, stats.P90, float64(23*time.Millisecond))
+	assert.InDelta(t, 99*time.Millisecond, stats.P99, float64(25*time.Millisecond))
+	assert.True(t, stats.P50 >= 50*time.Millisecond)
+
+	qps, err := mon.Qps(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 100, qps)
+	p99, err := mon.Signal(context.Background(), SignalP99)
+	require.NoError(t, err)
+	assert.Equal(t, float64(stats.P99)/float64(time.Millisecond), p99)
+	_, err = mon.Signal(context.Background(), Signal(100))
+	assert.Error(t, err)
+
+	// 整个窗口滑过去之后，除了正在处理的请求数，其它数据都清零了
+	clk.Advance(10 * time.Second)
+	stats = mon.Stats()
+	assert.Equal(t, Stats{Concurrency: 2}, stats)
+}
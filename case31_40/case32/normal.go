@@ -2,6 +2,7 @@ package case32
 
 import (
 	"context"
+	"interview-cases/clock"
 	"sync"
 	"time"
 )
@@ -10,22 +11,33 @@ import (
 // 滑动窗口
 type NormalAdaptiveStrategy struct {
 	mu *sync.RWMutex
-	// 重试间隔上限啊
+	// 底层策略是有状态的，每次操作用 newStrategy 创建一个新的
+	newStrategy func() Strategy
+	// 直接调用 Next 的时候用的底层策略
 	strategy    Strategy
 	slideWindow []req
 	// 滑动窗口的阈值
 	interval time.Duration
 	// 请求允许的失败次数
 	failNum int
+	clock   clock.Clock
 }
 
-func NewNormalAdaptiveStrategy(strategy Strategy, interval time.Duration, failNum int) *NormalAdaptiveStrategy {
+// NewNormalAdaptiveStrategy newStrategy 创建底层策略，比如说退避策略
+func NewNormalAdaptiveStrategy(newStrategy func() Strategy, interval time.Duration, failNum int) *NormalAdaptiveStrategy {
+	return NewNormalAdaptiveStrategyWithClock(newStrategy, interval, failNum, clock.New())
+}
+
+// NewNormalAdaptiveStrategyWithClock 滑动窗口的时间由 clk 决定
+func NewNormalAdaptiveStrategyWithClock(newStrategy func() Strategy, interval time.Duration, failNum int, clk clock.Clock) *NormalAdaptiveStrategy {
 	return &NormalAdaptiveStrategy{
 		mu:          &sync.RWMutex{},
-		strategy:    strategy,
+		newStrategy: newStrategy,
+		strategy:    newStrategy(),
 		slideWindow: make([]req, 0),
 		failNum:     failNum,
 		interval:    interval,
+		clock:       clk,
 	}
 }
 
@@ -36,28 +48,57 @@ type req struct {
 	success bool
 }
 
+// Next 所有调用共用一个底层策略，底层策略有状态的时候，每次操作要用 Operation 创建的策略
 func (n *NormalAdaptiveStrategy) Next(ctx context.Context, err error) (time.Duration, bool) {
+	return n.next(ctx, err, n.strategy)
+}
+
+// Operation 为一次操作创建一个策略，滑动窗口是共享的，底层策略是新创建的
+// 可以直接作为 retry.UnaryClientInterceptor 和 retry.NewRoundTripper 的 newStrategy
+func (n *NormalAdaptiveStrategy) Operation() Strategy {
+	return &normalOperation{n: n, strategy: n.newStrategy()}
+}
+
+type normalOperation struct {
+	n        *NormalAdaptiveStrategy
+	strategy Strategy
+}
+
+func (o *normalOperation) Next(ctx context.Context, err error) (time.Duration, bool) {
+	return o.n.next(ctx, err, o.strategy)
+}
+
+// LimitHint 服务端建议的间隔受底层策略的限制
+func (n *NormalAdaptiveStrategy) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(n.strategy, d)
+}
+
+func (o *normalOperation) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(o.strategy, d)
+}
+
+func (n *NormalAdaptiveStrategy) next(ctx context.Context, err error, strategy Strategy) (time.Duration, bool) {
 	if err == nil {
-		return n.success(ctx, err)
+		return n.success(ctx, err, strategy)
 	} else {
-		return n.fail(ctx, err)
+		return n.fail(ctx, err, strategy)
 	}
 }
 
-func (n *NormalAdaptiveStrategy) success(ctx context.Context, err error) (time.Duration, bool) {
+func (n *NormalAdaptiveStrategy) success(ctx context.Context, err error, strategy Strategy) (time.Duration, bool) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.slideWindow = append(n.slideWindow, req{
-		timestamp: time.Now().UnixMilli(),
+		timestamp: n.clock.Now().UnixMilli(),
 		success:   true,
 	})
-	return n.strategy.Next(ctx, err)
+	return strategy.Next(ctx, err)
 }
 
-func (n *NormalAdaptiveStrategy) fail(ctx context.Context, err error) (time.Duration, bool) {
+func (n *NormalAdaptiveStrategy) fail(ctx context.Context, err error, strategy Strategy) (time.Duration, bool) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	now := time.Now().UnixMilli()
+	now := n.clock.Now().UnixMilli()
 	threshold := now - n.interval.Milliseconds()
 	validIdx := 0
 	// 剔除超过时间的请求
@@ -85,7 +126,7 @@ func (n *NormalAdaptiveStrategy) fail(ctx context.Context, err error) (time.Dura
 		// 如果超过阈值就不重试了
 		return 0, false
 	}
-	return n.strategy.Next(ctx, err)
+	return strategy.Next(ctx, err)
 }
 
 // getCount 测试用
@@ -3,6 +3,7 @@ package case32
 import (
 	"context"
 	"fmt"
+	"interview-cases/clock"
 	"sync"
 	"testing"
 	"time"
@@ -17,13 +18,18 @@ func (m MockStrategy) Next(ctx context.Context, err error) (time.Duration, bool)
 	return 1 * time.Second, true
 }
 
+func newMockStrategy() Strategy {
+	return &MockStrategy{}
+}
+
 // 测试场景 间隔1s 最多20个失败请求
 // 10个成功的请求 20个失败请求 这20个请求调用next是可以继续的。第21个调用next不可以继续。 睡到600ms
 // 然后再发 10个成功的请求 10个失败的请求 断言成功的请求next可以继续，失败的请求next是不能继续
 // 再睡500ms 失败请求断言可以继续 断言有 slicewindow有10个成功的请求 11个失败请求
 func TestNormalAdaptiveStrategy_Next_FailuresWithinThreshold(t *testing.T) {
 	// 初始化策略
-	s := NewNormalAdaptiveStrategy(&MockStrategy{}, time.Second, 20)
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	s := NewNormalAdaptiveStrategyWithClock(newMockStrategy, time.Second, 20, clk)
 	var wg sync.WaitGroup
 	// 并发发起10个成功请求
 	for i := 0; i < 10; i++ {
@@ -52,7 +58,7 @@ func TestNormalAdaptiveStrategy_Next_FailuresWithinThreshold(t *testing.T) {
 	assert.False(t, ok, "第21个失败请求不应该继续")
 
 	// 等待600ms
-	time.Sleep(600 * time.Millisecond)
+	clk.Advance(600 * time.Millisecond)
 	// 并发再发10个成功请求
 	for i := 0; i < 10; i++ {
 		wg.Add(1)
@@ -76,7 +82,7 @@ func TestNormalAdaptiveStrategy_Next_FailuresWithinThreshold(t *testing.T) {
 	wg.Wait()
 
 	// 再等待500ms
-	time.Sleep(500 * time.Millisecond)
+	clk.Advance(500 * time.Millisecond)
 
 	// 发起一个失败请求
 	_, ok = s.Next(context.Background(), fmt.Errorf("mock error"))
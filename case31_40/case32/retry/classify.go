@@ -0,0 +1,111 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Classifier 判断错误能不能重试
+type Classifier func(err error) bool
+
+// Any 任意一个 Classifier 认为可以重试就重试
+func Any(cs ...Classifier) Classifier {
+	return func(err error) bool {
+		for _, c := range cs {
+			if c(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Default 覆盖了常见的临时性错误
+var Default = Any(
+	GRPCCodes(codes.Unavailable, codes.ResourceExhausted, codes.Aborted),
+	HTTPStatuses(http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout),
+	NetErrors,
+	MySQLDeadlock,
+)
+
+// GRPCCodes 错误码在 cs 里面的 gRPC 错误可以重试
+func GRPCCodes(cs ...codes.Code) Classifier {
+	return func(err error) bool {
+		st, ok := status.FromError(err)
+		if !ok {
+			return false
+		}
+		for _, c := range cs {
+			if st.Code() == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StatusError 代表 HTTP 响应的状态码不是 2xx
+// 业务代码把它作为错误返回，HTTPStatuses 才能识别
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP 响应状态码 %d", e.StatusCode)
+}
+
+// HTTPStatuses 状态码在 statuses 里面的 StatusError 可以重试
+func HTTPStatuses(statuses ...int) Classifier {
+	return func(err error) bool {
+		var se *StatusError
+		if !errors.As(err, &se) {
+			return false
+		}
+		for _, s := range statuses {
+			if se.StatusCode == s {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NetErrors 超时、连接被拒绝、连接被重置以及连接被意外关闭都可以重试
+// ctx 超时或者被取消不在此列，调用方已经不想要结果了
+func NetErrors(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+)
+
+// MySQLDeadlock 死锁（1213）的时候 MySQL 会回滚整个事务；等锁超时（1205）默认只回滚出错的那条语句，
+// 除非开启了 innodb_rollback_on_timeout，事务还开着，之前的语句也还在。
+// 所以 fn 必须包含整个事务：在 fn 里面开启事务，出错了回滚，重试的时候从头开始，不能只重试出错的语句
+func MySQLDeadlock(err error) bool {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return false
+	}
+	return me.Number == mysqlDeadlock || me.Number == mysqlLockWaitTimeout
+}
@@ -0,0 +1,104 @@
+// Package retry 按照 case32.Strategy 执行重试
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/case31_40/case32"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"time"
+)
+
+type options struct {
+	clock clock.Clock
+}
+
+// Option Do 的可选配置
+type Option func(o *options)
+
+// WithClock 等待重试的间隔和计算 Retry-After 的时间由 clk 决定，默认是真实的时间
+func WithClock(clk clock.Clock) Option {
+	return func(o *options) {
+		o.clock = clk
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{clock: clock.New()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Do 使用 Default 判断错误能不能重试
+func Do(ctx context.Context, s case32.Strategy, fn func(ctx context.Context) error, opts ...Option) error {
+	return DoWithClassifier(ctx, s, Default, fn, opts...)
+}
+
+// DoWithClassifier 执行 fn，失败了并且错误可以重试，就按照策略给出的间隔重试
+// 服务端通过 RetryInfo 或者 Retry-After 建议了更长的间隔的时候，以服务端的建议为准，
+// 但是不能超出策略的限制（见 case32.HintLimiter），ctx 等不到下一次重试的时候直接返回最后一次的错误
+// 策略实现了 case32.Resetter 的时候，开始之前会调用 Reset，所以同一个策略不能同时用于多个操作
+func DoWithClassifier(ctx context.Context, s case32.Strategy, c Classifier, fn func(ctx context.Context) error, opts ...Option) error {
+	o := newOptions(opts)
+	if r, ok := s.(case32.Resetter); ok {
+		r.Reset()
+	}
+	for {
+		err := fn(ctx)
+		if err == nil {
+			// 自适应的策略要统计成功的请求
+			s.Next(ctx, nil)
+			return nil
+		}
+		if ctx.Err() != nil || !c(err) {
+			return err
+		}
+		d, ok := s.Next(ctx, err)
+		if !ok {
+			return err
+		}
+		if hint, ok := retryAfter(err, o.clock.Now()); ok && hint > d {
+			hint, ok = case32.LimitHint(s, hint)
+			if !ok {
+				return err
+			}
+			d = max(d, hint)
+		}
+		// ctx 的超时时间总是真实的时间，所以比较的是剩余的时长
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		if cerr := sleep(ctx, o.clock, d); cerr != nil {
+			return fmt.Errorf("%w，最后一次的错误：%w", cerr, err)
+		}
+	}
+}
+
+// retryAfter 服务端建议的重试时间，HTTP 日期格式的 Retry-After 按照 now 换算
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	var hinted interface {
+		RetryAfter(now time.Time) (time.Duration, bool)
+	}
+	if errors.As(err, &hinted) {
+		return hinted.RetryAfter(now)
+	}
+	return reject.RetryAfter(err)
+}
+
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := clk.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
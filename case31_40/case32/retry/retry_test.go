@@ -0,0 +1,321 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/case31_40/case32"
+	"interview-cases/clock"
+	"interview-cases/reject"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newStrategy(maxAttempts int) func() case32.Strategy {
+	return func() case32.Strategy {
+		return case32.NewFixedBackoff(time.Millisecond, case32.BackoffConfig{MaxAttempts: maxAttempts})
+	}
+}
+
+func TestDo(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "节点不可用")
+	testCases := []struct {
+		name string
+		// 第几次调用返回什么错误，超出的部分返回 nil
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "重试之后成功",
+			errs:      []error{unavailable, unavailable},
+			wantCalls: 3,
+		},
+		{
+			name:      "不能重试的错误",
+			errs:      []error{status.Error(codes.InvalidArgument, "参数错误")},
+			wantCalls: 1,
+			wantErr:   status.Error(codes.InvalidArgument, "参数错误"),
+		},
+		{
+			name:      "超过重试次数",
+			errs:      []error{unavailable, unavailable, unavailable, unavailable},
+			wantCalls: 3,
+			wantErr:   unavailable,
+		},
+	}
+	s := newStrategy(2)()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			// 同一个策略用于多次操作，每次开始之前都会 Reset
+			err := Do(context.Background(), s, func(ctx context.Context) error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+}
+
+func TestDo_Context(t *testing.T) {
+	mockErr := status.Error(codes.Unavailable, "节点不可用")
+	// 等到下一次重试 ctx 已经过期了，直接返回，不会傻等
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	err := Do(ctx, case32.NewFixedBackoff(time.Hour, case32.BackoffConfig{}), func(ctx context.Context) error {
+		return mockErr
+	})
+	assert.Equal(t, mockErr, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 等待重试的时候 ctx 被取消了
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = Do(ctx, case32.NewFixedBackoff(time.Hour, case32.BackoffConfig{}), func(ctx context.Context) error {
+		return mockErr
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, mockErr)
+
+	// 服务端建议的间隔比策略的长
+	var calls int
+	start = time.Now()
+	err = Do(context.Background(), newStrategy(1)(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return reject.Error(reject.Info{RetryAfter: 30 * time.Millisecond})
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+// 服务端建议的间隔受策略和 ctx 的限制
+func TestDo_RetryAfterLimit(t *testing.T) {
+	hourLater := reject.Error(reject.Info{RetryAfter: time.Hour})
+
+	// ctx 剩下的时间等不到服务端建议的时间，直接返回最后一次的错误
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var calls int
+	start := time.Now()
+	err := Do(ctx, newStrategy(3)(), func(ctx context.Context) error {
+		calls++
+		return hourLater
+	})
+	assert.Equal(t, hourLater, err)
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 超过 MaxElapsed 不再重试
+	calls = 0
+	err = Do(context.Background(), case32.NewFixedBackoff(time.Millisecond, case32.BackoffConfig{
+		MaxElapsed: time.Minute,
+	}), func(ctx context.Context) error {
+		calls++
+		return hourLater
+	})
+	assert.Equal(t, hourLater, err)
+	assert.Equal(t, 1, calls)
+
+	// 不超过 MaxInterval
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	s := case32.NewFixedBackoffWithClock(time.Millisecond, case32.BackoffConfig{MaxInterval: 10 * time.Second}, clk)
+	calls = 0
+	done := make(chan error, 1)
+	go func() {
+		done <- Do(context.Background(), s, func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				return hourLater
+			}
+			return nil
+		}, WithClock(clk))
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("没有按照 MaxInterval 重试")
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestDefault(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "gRPC Unavailable", err: status.Error(codes.Unavailable, ""), want: true},
+		{name: "gRPC NotFound", err: status.Error(codes.NotFound, "")},
+		{name: "HTTP 503", err: fmt.Errorf("调用失败: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), want: true},
+		{name: "HTTP 400", err: &StatusError{StatusCode: http.StatusBadRequest}},
+		{name: "网络超时", err: &net.DNSError{IsTimeout: true}, want: true},
+		{name: "连接被拒绝", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "连接被意外关闭", err: io.ErrUnexpectedEOF, want: true},
+		{name: "ctx 超时", err: context.DeadlineExceeded},
+		{name: "MySQL 死锁", err: &mysql.MySQLError{Number: 1213}, want: true},
+		{name: "MySQL 主键冲突", err: &mysql.MySQLError{Number: 1062}},
+		{name: "普通错误", err: errors.New("mock error")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Default(tc.err))
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor(newStrategy(3), Default)
+	var calls int
+	err := interceptor(context.Background(), "/proto.TestService/Test", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			calls++
+			if calls == 1 {
+				return status.Error(codes.Unavailable, "节点不可用")
+			}
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRoundTripper(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// 前两次请求失败
+		if calls.Add(1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("unavailable"))
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewRoundTripper(nil, newStrategy(3), Default)}
+
+	testCases := []struct {
+		name      string
+		req       func() *http.Request
+		wantCode  int
+		wantBody  string
+		wantCalls int32
+	}{
+		{
+			name: "GET",
+			req: func() *http.Request {
+				req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+				return req
+			},
+			wantCode:  http.StatusOK,
+			wantCalls: 3,
+		},
+		{
+			name: "带 Idempotency-Key 的 POST，重放请求体",
+			req: func() *http.Request {
+				req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello"))
+				req.Header.Set("Idempotency-Key", "order-1")
+				return req
+			},
+			wantCode:  http.StatusOK,
+			wantBody:  "hello",
+			wantCalls: 3,
+		},
+		{
+			name: "POST 不重试",
+			req: func() *http.Request {
+				req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello"))
+				return req
+			},
+			wantCode:  http.StatusServiceUnavailable,
+			wantBody:  "unavailable",
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls.Store(0)
+			resp, err := client.Do(tc.req())
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			assert.Equal(t, tc.wantBody, string(body))
+			assert.Equal(t, tc.wantCalls, calls.Load())
+		})
+	}
+
+	// 重试次数用完了，把最后一次的响应交给调用方
+	calls.Store(0)
+	client = &http.Client{Transport: NewRoundTripper(nil, newStrategy(1), Default)}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "unavailable", string(body))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRoundTripper_Clock(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// HTTP 日期格式的 Retry-After，按照 clk 的时间换算是 10s 之后
+			w.Header().Set("Retry-After", clk.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewRoundTripper(nil, newStrategy(1), Default, WithClock(clk))}
+
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		done <- resp
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(9 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("没有等到 Retry-After 就重试了")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	resp := <-done
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+}
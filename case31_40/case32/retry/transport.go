@@ -0,0 +1,126 @@
+package retry
+
+import (
+	"context"
+	"interview-cases/case31_40/case32"
+	"interview-cases/reject"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryClientInterceptor gRPC 客户端重试
+// 策略是有状态的，所以每次调用都通过 newStrategy 创建一个新的
+func UnaryClientInterceptor(newStrategy func() case32.Strategy, c Classifier, retryOpts ...Option) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return DoWithClassifier(ctx, newStrategy(), c, func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}, retryOpts...)
+	}
+}
+
+// RoundTripper 只重试幂等的请求
+// 幂等指的是 GET、HEAD、OPTIONS、TRACE、PUT、DELETE，或者带了 Idempotency-Key 头部的请求，
+// 有请求体的请求还要能通过 GetBody 重新拿到请求体
+type RoundTripper struct {
+	base        http.RoundTripper
+	newStrategy func() case32.Strategy
+	classifier  Classifier
+	opts        []Option
+}
+
+// NewRoundTripper base 为 nil 的时候使用 http.DefaultTransport
+func NewRoundTripper(base http.RoundTripper, newStrategy func() case32.Strategy, c Classifier, opts ...Option) *RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RoundTripper{
+		base:        base,
+		newStrategy: newStrategy,
+		classifier:  c,
+		opts:        opts,
+	}
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !replayable(req) {
+		return rt.base.RoundTrip(req)
+	}
+	var (
+		resp  *http.Response
+		first = true
+	)
+	err := DoWithClassifier(req.Context(), rt.newStrategy(), rt.classifier, func(ctx context.Context) error {
+		attempt := req
+		if !first {
+			drain(resp)
+			resp = nil
+			attempt = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				attempt.Body = body
+			}
+		}
+		first = false
+		var err error
+		resp, err = rt.base.RoundTrip(attempt)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < http.StatusBadRequest {
+			return nil
+		}
+		return &responseError{StatusError: StatusError{StatusCode: resp.StatusCode}, resp: resp}
+	}, rt.opts...)
+	if re, ok := err.(*responseError); ok {
+		// 不再重试了，把最后一次的响应交给调用方
+		return re.resp, nil
+	}
+	if err != nil && resp != nil && resp.StatusCode >= http.StatusBadRequest {
+		// 等待重试的时候 ctx 过期了，最后一次的响应已经没用了
+		drain(resp)
+		return nil, err
+	}
+	return resp, err
+}
+
+// responseError 把出错的响应包装成错误，交给 Classifier 判断
+// 要重试的时候才会丢弃这个响应
+type responseError struct {
+	StatusError
+	resp *http.Response
+}
+
+func (e *responseError) Unwrap() error {
+	return &e.StatusError
+}
+
+// RetryAfter 服务端在 Retry-After 头部里面建议了重试的时间
+func (e *responseError) RetryAfter(now time.Time) (time.Duration, bool) {
+	return reject.RetryAfterHTTP(e.resp, now)
+}
+
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// drain 读完并关闭响应体，这样连接可以被复用
+func drain(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+}
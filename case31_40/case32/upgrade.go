@@ -2,83 +2,140 @@ package case32
 
 import (
 	"context"
-	"math/bits"
+	"interview-cases/clock"
 	"sync/atomic"
 	"time"
 )
 
 // UpgradeAdaptiveStrategy 进阶版自适应策略
-// 使用ring buffer优化
+// 使用按时间分桶的环形缓冲区，每个桶只有几个原子计数器，不需要加锁，
+// 并且旧的桶会随着时间过期，流量低的时候失败也会被遗忘。
+// 是否允许重试由重试预算决定：窗口内的重试次数不能超过请求数的 ratio 倍，
+// 同时保证每秒至少可以重试 minPerSecond 次，免得流量低的时候完全不能重试
 type UpgradeAdaptiveStrategy struct {
-	s          Strategy
-	threshold  int // 比特数量
-	ringBuffer []uint64
-	reqCount   uint64
+	// 底层策略是有状态的，每次操作用 newS 创建一个新的
+	newS func() Strategy
+	// 直接调用 Next 的时候用的底层策略
+	s       Strategy
+	buckets []bucket
+	width   time.Duration
+	// 重试次数占请求数的比例上限
+	ratio float64
+	// 每秒至少允许的重试次数
+	minPerSecond float64
+	clock        clock.Clock
 }
 
-func NewUpgradeAdaptiveStrategy(s Strategy, threshold int) *UpgradeAdaptiveStrategy {
+type bucket struct {
+	// 桶的开始时间，UnixNano
+	start   atomic.Int64
+	success atomic.Int64
+	failure atomic.Int64
+	retries atomic.Int64
+}
+
+// NewUpgradeAdaptiveStrategy 窗口一共 size 个桶，每个桶 width 那么长，newS 创建底层策略
+func NewUpgradeAdaptiveStrategy(newS func() Strategy, size int, width time.Duration, ratio float64, minPerSecond float64) *UpgradeAdaptiveStrategy {
+	return NewUpgradeAdaptiveStrategyWithClock(newS, size, width, ratio, minPerSecond, clock.New())
+}
+
+// NewUpgradeAdaptiveStrategyWithClock 桶的过期时间由 clk 决定
+func NewUpgradeAdaptiveStrategyWithClock(newS func() Strategy, size int, width time.Duration, ratio float64, minPerSecond float64, clk clock.Clock) *UpgradeAdaptiveStrategy {
 	return &UpgradeAdaptiveStrategy{
-		s:         s,
-		threshold: threshold,
-		// 初始化16个uint64 表示1024个请求
-		ringBuffer: make([]uint64, 16),
+		newS:         newS,
+		s:            newS(),
+		buckets:      make([]bucket, size),
+		width:        width,
+		ratio:        ratio,
+		minPerSecond: minPerSecond,
+		clock:        clk,
 	}
 }
 
+// Next 所有调用共用一个底层策略，底层策略有状态的时候，每次操作要用 Operation 创建的策略
 func (u *UpgradeAdaptiveStrategy) Next(ctx context.Context, err error) (time.Duration, bool) {
-	if err == nil {
-		u.markSuccess()
-		return u.s.Next(ctx, err)
-	} else {
-		failCount := u.getFailed()
-		u.markFail()
-		if failCount >= u.threshold {
-			return 0, false
-		}
-		return u.s.Next(ctx, err)
-	}
+	return u.next(ctx, err, u.s)
 }
 
-func (u *UpgradeAdaptiveStrategy) getFailed() int {
-	var failCount int
-	for i := 0; i < len(u.ringBuffer); i++ {
-		v := atomic.LoadUint64(&u.ringBuffer[i])
-		failCount += bits.OnesCount64(v)
-	}
-	return failCount
+// Operation 为一次操作创建一个策略，环形缓冲区是共享的，底层策略是新创建的
+func (u *UpgradeAdaptiveStrategy) Operation() Strategy {
+	return &upgradeOperation{u: u, s: u.newS()}
+}
+
+type upgradeOperation struct {
+	u *UpgradeAdaptiveStrategy
+	s Strategy
+}
+
+func (o *upgradeOperation) Next(ctx context.Context, err error) (time.Duration, bool) {
+	return o.u.next(ctx, err, o.s)
+}
+
+// LimitHint 服务端建议的间隔受底层策略的限制
+func (u *UpgradeAdaptiveStrategy) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(u.s, d)
 }
 
-func (u *UpgradeAdaptiveStrategy) markSuccess() {
-	count := atomic.AddUint64(&u.reqCount, 1)
-	count = count % 1024
-	// 确定在ringBuffer中的索引
-	index := count / 64
-	// 确定在uint64中的位
-	bitPosition := uint(count % 64)
+func (o *upgradeOperation) LimitHint(d time.Duration) (time.Duration, bool) {
+	return LimitHint(o.s, d)
+}
 
-	// 使用原子操作将该位设置为0
+func (u *UpgradeAdaptiveStrategy) next(ctx context.Context, err error, s Strategy) (time.Duration, bool) {
+	cur := u.current()
+	if err == nil {
+		cur.success.Add(1)
+		return s.Next(ctx, err)
+	}
+	cur.failure.Add(1)
+	// 先占用预算再检查，并发的时候不会超出预算
+	cur.retries.Add(1)
+	if !u.withinBudget() {
+		cur.retries.Add(-1)
+		return 0, false
+	}
+	d, ok := s.Next(ctx, err)
+	if !ok {
+		cur.retries.Add(-1)
+	}
+	return d, ok
+}
+
+// current 返回当前时间对应的桶，桶过期了就清空
+// 清空和计数不是一个原子操作，桶切换的瞬间可能会丢掉几个计数，对于限制重试来说可以接受
+func (u *UpgradeAdaptiveStrategy) current() *bucket {
+	start := u.clock.Now().Truncate(u.width).UnixNano()
+	b := &u.buckets[int(start/int64(u.width))%len(u.buckets)]
 	for {
-		old := atomic.LoadUint64(&u.ringBuffer[index])
-		ne := old &^ (uint64(1) << bitPosition) // 使用按位清除操作
-		if atomic.CompareAndSwapUint64(&u.ringBuffer[index], old, ne) {
-			break
+		old := b.start.Load()
+		if old >= start {
+			return b
+		}
+		if b.start.CompareAndSwap(old, start) {
+			b.success.Store(0)
+			b.failure.Store(0)
+			b.retries.Store(0)
+			return b
 		}
 	}
 }
 
-func (u *UpgradeAdaptiveStrategy) markFail() {
-	count := atomic.AddUint64(&u.reqCount, 1)
-	count = count % 1024
-	// 确定在ringBuffer中的索引
-	index := count / 64
-	// 确定在uint64中的位
-	bitPosition := uint(count % 64)
-
-	for {
-		old := atomic.LoadUint64(&u.ringBuffer[index])
-		ne := old | (uint64(1) << bitPosition) // 使用按位或操作来设置1
-		if atomic.CompareAndSwapUint64(&u.ringBuffer[index], old, ne) {
-			break
+// stat 统计窗口内的请求数和重试次数
+func (u *UpgradeAdaptiveStrategy) stat() (total, retries int64) {
+	oldest := u.clock.Now().Truncate(u.width).Add(-u.width * time.Duration(len(u.buckets)-1)).UnixNano()
+	for i := range u.buckets {
+		b := &u.buckets[i]
+		if b.start.Load() < oldest {
+			continue
 		}
+		total += b.success.Load() + b.failure.Load()
+		retries += b.retries.Load()
 	}
+	return
+}
+
+func (u *UpgradeAdaptiveStrategy) withinBudget() bool {
+	total, retries := u.stat()
+	window := u.width * time.Duration(len(u.buckets))
+	budget := u.ratio*float64(total) + u.minPerSecond*window.Seconds()
+	return float64(retries) <= budget
 }
@@ -3,67 +3,120 @@ package case32
 import (
 	"context"
 	"github.com/pkg/errors"
+	"interview-cases/clock"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 // 测试场景
-// 阈值是50
-// 2000个请求 有1500个成功的 有500个失败的 最后统计500个失败的有50个可以执行 有450个不能执行 1500成功的都能执行
+// 重试预算是请求数的 10%，不设下限
+// 先并发发起 1500 个成功的请求，再并发发起 500 个失败的请求，
+// 窗口内一共 2000 个请求，最多允许 200 次重试，成功的请求都能执行
 func TestUpgradeAdaptiveStrategy_Next_Concurrent(t *testing.T) {
-	// 创建一个基础策略
-	baseStrategy := &MockStrategy{}
-
-	// 创建升级版自适应策略，设置阈值为50
-	strategy := NewUpgradeAdaptiveStrategy(baseStrategy, 50)
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	strategy := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, time.Second, 0.1, 0, clk)
 
 	var wg sync.WaitGroup
-	var successCount, errCount int64
+	var allowedCount, rejectedCount int64
 	mockErr := errors.New("mock error")
-
-	// 并发执行2000个请求
-	for i := 0; i < 2000; i++ {
+	for i := 0; i < 1500; i++ {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-
-			// 前1500个请求成功，后500个失败
-			var err error
-			if index >= 1500 {
-				err = mockErr
+			if _, allowed := strategy.Next(context.Background(), nil); !allowed {
+				t.Errorf("预期成功请求应该被允许执行，但第%d个请求被拒绝", index+1)
 			}
-
-			_, allowed := strategy.Next(context.Background(), err)
-
-			if err != nil {
-				// 失败请求的统计
-				if allowed {
-					atomic.AddInt64(&successCount, 1)
-				} else {
-					atomic.AddInt64(&errCount, 1)
-				}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, allowed := strategy.Next(context.Background(), mockErr); allowed {
+				atomic.AddInt64(&allowedCount, 1)
 			} else {
-				// 成功请求必须被允许
-				if !allowed {
-					t.Errorf("预期成功请求应该被允许执行，但第%d个请求被拒绝", index+1)
-				}
+				atomic.AddInt64(&rejectedCount, 1)
 			}
-		}(i)
+		}()
 	}
-
-	// 等待所有goroutine完成
 	wg.Wait()
 
-	// 验证结果：期望大约50个失败请求可以执行，450个被拒绝
-	// 由于是环形缓冲区和并发执行，可能会有一些误差，这里使用一个合理的范围进行判断
-	finalSuccessCount := int(atomic.LoadInt64(&successCount))
-	finalErrCount := int(atomic.LoadInt64(&errCount))
-	if finalSuccessCount < 45 || finalSuccessCount > 55 {
-		t.Errorf("期望大约50个失败请求被允许执行，实际允许执行的失败请求数量为: %d", finalSuccessCount)
+	// 早到的失败请求看到的请求数少一些，所以可能会比 200 略少，但是一定不会超过预算
+	assert.LessOrEqual(t, allowedCount, int64(200))
+	assert.GreaterOrEqual(t, allowedCount, int64(190))
+	assert.Equal(t, int64(500), allowedCount+rejectedCount)
+	total, retries := strategy.stat()
+	assert.Equal(t, int64(2000), total)
+	assert.Equal(t, allowedCount, retries)
+}
+
+// 测试场景
+// 窗口 10 个桶，每个桶 100ms，重试预算是请求数的 10%，每秒至少 2 次
+func TestUpgradeAdaptiveStrategy_Next_Decay(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	strategy := NewUpgradeAdaptiveStrategyWithClock(newMockStrategy, 10, 100*time.Millisecond, 0.1, 2, clk)
+	mockErr := errors.New("mock error")
+	next := func(err error) bool {
+		_, ok := strategy.Next(context.Background(), err)
+		return ok
 	}
 
-	if finalErrCount < 445 || finalErrCount > 455 {
-		t.Errorf("期望大约450个失败请求被拒绝执行，实际被拒绝的失败请求数量为: %d", finalErrCount)
+	// 没有成功的请求，只有每秒 2 次的下限
+	assert.True(t, next(mockErr))
+	assert.True(t, next(mockErr))
+	assert.False(t, next(mockErr))
+	// 成功的请求增加预算，再失败 4 次的时候一共 47 个请求：47 * 0.1 + 2 = 6.7
+	for i := 0; i < 40; i++ {
+		assert.True(t, next(nil))
+	}
+	for i := 0; i < 4; i++ {
+		assert.True(t, next(mockErr))
 	}
+	assert.False(t, next(mockErr))
+
+	// 窗口没过完，预算还是用完的状态
+	clk.Advance(900 * time.Millisecond)
+	assert.False(t, next(mockErr))
+	// 最早的桶过期了，只剩下刚才那一次失败
+	clk.Advance(100 * time.Millisecond)
+	total, retries := strategy.stat()
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, int64(0), retries)
+	assert.True(t, next(mockErr))
+
+	// 低流量的时候，失败也会随着时间过期
+	clk.Advance(time.Second)
+	total, retries = strategy.stat()
+	assert.Equal(t, int64(0), total)
+	assert.Equal(t, int64(0), retries)
+}
+
+// 每个 goroutine 里面 10% 的请求失败
+func benchmarkStrategy(b *testing.B, s Strategy) {
+	mockErr := errors.New("mock error")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			var err error
+			if i%10 == 0 {
+				err = mockErr
+			}
+			s.Next(context.Background(), err)
+			i++
+		}
+	})
+}
+
+func BenchmarkNormalAdaptiveStrategy(b *testing.B) {
+	benchmarkStrategy(b, NewNormalAdaptiveStrategy(newMockStrategy, time.Second, 100))
+}
+
+func BenchmarkUpgradeAdaptiveStrategy(b *testing.B) {
+	benchmarkStrategy(b, NewUpgradeAdaptiveStrategy(newMockStrategy, 10, 100*time.Millisecond, 0.1, 10))
 }
@@ -0,0 +1,185 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake 手动拨动的时钟，只有调用 Advance 时间才会前进
+// Sleep、After、Timer 和 Ticker 都挂在 Fake 上，时间到了才会被唤醒
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter 一个在等待时间到达的 Sleep、After、Timer 或者 Ticker
+type waiter struct {
+	deadline time.Time
+	// Ticker 的周期，0 代表只触发一次
+	period time.Duration
+	c      chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) Until(t time.Time) time.Duration {
+	return t.Sub(f.Now())
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.add(d, 0).c
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{f: f, w: f.add(d, 0)}
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: ticker 的周期必须大于 0")
+	}
+	return &fakeTicker{f: f, w: f.add(d, d)}
+}
+
+// Advance 把时间往前拨 d，中间到期的 waiter 按照到期时间的顺序被唤醒
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool {
+			return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(target) {
+			break
+		}
+		w := f.waiters[0]
+		f.now = w.deadline
+		// 和 time 包一样，没人接收就丢掉
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = target
+	f.cond.Broadcast()
+}
+
+// BlockUntil 阻塞直到至少有 n 个 waiter
+// 后台协程处理完一轮，重新开始 Sleep 或者 After 的时候才会回到等待状态，
+// 所以测试里面先 BlockUntil 再 Advance，就能保证后台协程跟上了时间
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters 当前有多少个 waiter
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func (f *Fake) add(d, period time.Duration) *waiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	f.schedule(w, d)
+	return w
+}
+
+// schedule 让 w 在 d 之后到期，调用者要持有锁
+func (f *Fake) schedule(w *waiter, d time.Duration) {
+	w.deadline = f.now.Add(d)
+	// 已经到期了就直接触发
+	if d <= 0 && w.period == 0 {
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		return
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// remove 移除 waiter，返回 waiter 之前是否还在等待，调用者要持有锁
+func (f *Fake) remove(w *waiter) bool {
+	for i, item := range f.waiters {
+		if item == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.remove(t.w)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	active := t.f.remove(t.w)
+	t.f.schedule(t.w, d)
+	return active
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.f.remove(t.w)
+}
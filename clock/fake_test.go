@@ -0,0 +1,67 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	f := NewFake(start)
+	assert.Equal(t, start, f.Now())
+
+	after := f.After(time.Second)
+	timer := f.NewTimer(2 * time.Second)
+	ticker := f.NewTicker(time.Second)
+	assert.Equal(t, 3, f.Waiters())
+
+	f.Advance(500 * time.Millisecond)
+	assert.Equal(t, 500*time.Millisecond, f.Since(start))
+	assertEmpty(t, after, timer.C(), ticker.C())
+
+	f.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-after)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+	assertEmpty(t, timer.C())
+
+	// 一次拨过多个周期，ticker 和 time 包一样，没人接收的 tick 会被丢掉
+	f.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-timer.C())
+	assert.Equal(t, start.Add(2*time.Second), <-ticker.C())
+	assertEmpty(t, ticker.C())
+	assert.Equal(t, 1, f.Waiters())
+
+	assert.False(t, timer.Stop())
+	assert.False(t, timer.Reset(time.Second))
+	assert.True(t, timer.Stop())
+	f.Advance(time.Second)
+	assertEmpty(t, timer.C())
+
+	ticker.Stop()
+	assert.Equal(t, 0, f.Waiters())
+}
+
+func TestFake_Sleep(t *testing.T) {
+	f := NewFake(time.Unix(1700000000, 0))
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Minute)
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(59 * time.Second)
+	assertEmpty(t, done)
+	f.Advance(time.Second)
+	<-done
+}
+
+func assertEmpty[T any](t *testing.T, chs ...<-chan T) {
+	for _, ch := range chs {
+		select {
+		case <-ch:
+			t.Fatal("不应该被触发")
+		default:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package reject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/clock"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrThrottled = errors.New("服务端限流，还没到建议的重试时间")
+
+// ThrottledError 客户端在本地拒绝的请求
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s，%s 之后再试", ErrThrottled.Error(), e.RetryAfter)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrThrottled
+}
+
+// RetryAfter 从 gRPC 的错误里面解析服务端建议的重试时间
+// 也能解析 Gate 在本地拒绝时返回的 ThrottledError
+func RetryAfter(err error) (time.Duration, bool) {
+	var te *ThrottledError
+	if errors.As(err, &te) {
+		return te.RetryAfter, true
+	}
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// RetryAfterHTTP 从 429 或者 503 响应里面解析 Retry-After，支持秒数和 HTTP 日期两种格式
+func RetryAfterHTTP(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	val := resp.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(max(0, secs)) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return max(0, t.Sub(now)), true
+	}
+	return 0, false
+}
+
+// Gate 客户端遵守服务端的重试建议
+// 收到限流响应之后，在建议的时间之前不再向这个目标发请求，而是直接在本地失败，
+// 免得被限流的服务端还要花资源来拒绝请求
+type Gate struct {
+	// 服务端建议的时间太长的时候，最多等这么久
+	maxWait time.Duration
+	clock   clock.Clock
+	mu      sync.Mutex
+	until   map[string]time.Time
+}
+
+func NewGate(maxWait time.Duration) *Gate {
+	return NewGateWithClock(maxWait, clock.New())
+}
+
+func NewGateWithClock(maxWait time.Duration, clk clock.Clock) *Gate {
+	return &Gate{
+		maxWait: maxWait,
+		clock:   clk,
+		until:   make(map[string]time.Time),
+	}
+}
+
+// Blocked 返回 key 还要等多久才能发请求，0 代表可以发
+func (g *Gate) Blocked(key string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	until, ok := g.until[key]
+	if !ok {
+		return 0
+	}
+	remain := until.Sub(g.clock.Now())
+	if remain <= 0 {
+		delete(g.until, key)
+		return 0
+	}
+	return remain
+}
+
+// Observe 记录服务端建议的重试时间
+func (g *Gate) Observe(key string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		return
+	}
+	until := g.clock.Now().Add(min(retryAfter, g.maxWait))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until.After(g.until[key]) {
+		g.until[key] = until
+	}
+}
+
+// UnaryClientInterceptor 按照方法记录服务端的重试建议
+func (g *Gate) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if remain := g.Blocked(method); remain > 0 {
+			return &ThrottledError{RetryAfter: remain}
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if d, ok := RetryAfter(err); ok {
+			g.Observe(method, d)
+		}
+		return err
+	}
+}
+
+// RoundTripper 按照 host 记录服务端的重试建议，base 为 nil 的时候使用 http.DefaultTransport
+func (g *Gate) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		key := req.URL.Host
+		if remain := g.Blocked(key); remain > 0 {
+			return nil, &ThrottledError{RetryAfter: remain}
+		}
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		if d, ok := RetryAfterHTTP(resp, g.clock.Now()); ok {
+			g.Observe(key, d)
+		}
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,94 @@
+// Package reject 统一限流之后拒绝请求的方式
+// HTTP 返回 429，带上 Retry-After 和 RateLimit-* 头部；
+// gRPC 返回 codes.ResourceExhausted，带上 RetryInfo 和 QuotaFailure。
+// 客户端可以从这些信息里面知道多久之后再重试
+package reject
+
+import (
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultDescription = "触发了限流"
+
+// Info 拒绝请求的时候告诉调用方的信息
+type Info struct {
+	// 窗口内的配额，以及还剩多少，Limit 为 0 代表没有配额的概念，比如说按照负载限流
+	Limit     int
+	Remaining int
+	// 多久之后配额会重置
+	Reset time.Duration
+	// 建议调用方多久之后再重试
+	RetryAfter time.Duration
+	// 被限流的维度，比如说 ip:127.0.0.1，对应 QuotaFailure 里面的 subject
+	Subject     string
+	Description string
+}
+
+func (i Info) description() string {
+	if i.Description == "" {
+		return defaultDescription
+	}
+	return i.Description
+}
+
+// SetHeaders 设置 RateLimit-* 头部，没有被拒绝的请求也可以带上，让调用方知道还剩多少配额
+func SetHeaders(h http.Header, info Info) {
+	if info.Limit > 0 {
+		h.Set("RateLimit-Limit", strconv.Itoa(info.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(max(0, info.Remaining)))
+		h.Set("RateLimit-Reset", seconds(info.Reset))
+	}
+}
+
+// WriteHTTP 返回 429
+func WriteHTTP(w http.ResponseWriter, info Info) {
+	SetHeaders(w.Header(), info)
+	if info.RetryAfter > 0 {
+		w.Header().Set("Retry-After", seconds(info.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(info.description()))
+}
+
+// AbortGin 返回 429 并且中断 gin 后续的 handler
+func AbortGin(c *gin.Context, info Info) {
+	WriteHTTP(c.Writer, info)
+	c.Abort()
+}
+
+// Error 返回 codes.ResourceExhausted
+func Error(info Info) error {
+	st := status.New(codes.ResourceExhausted, info.description())
+	quota := &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: info.Subject, Description: info.description()},
+		},
+	}
+	var (
+		res *status.Status
+		err error
+	)
+	if info.RetryAfter > 0 {
+		res, err = st.WithDetails(quota, &errdetails.RetryInfo{RetryDelay: durationpb.New(info.RetryAfter)})
+	} else {
+		res, err = st.WithDetails(quota)
+	}
+	if err != nil {
+		return st.Err()
+	}
+	return res.Err()
+}
+
+// seconds 向上取整到秒，Retry-After 只支持整数秒，取整之后不会让调用方提前重试
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(max(0, d).Seconds())))
+}
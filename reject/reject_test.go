@@ -0,0 +1,135 @@
+package reject
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/clock"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteHTTP(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	WriteHTTP(recorder, Info{
+		Limit:      100,
+		Remaining:  -1,
+		Reset:      1500 * time.Millisecond,
+		RetryAfter: 200 * time.Millisecond,
+	})
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "100", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", recorder.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, defaultDescription, recorder.Body.String())
+
+	// 没有配额的概念，就不带 RateLimit-* 头部
+	recorder = httptest.NewRecorder()
+	WriteHTTP(recorder, Info{Description: "系统过载"})
+	assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+	assert.Empty(t, recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "系统过载", recorder.Body.String())
+}
+
+func TestError(t *testing.T) {
+	err := Error(Info{RetryAfter: 3 * time.Second, Subject: "ip:127.0.0.1"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 2)
+	quota, ok := st.Details()[0].(*errdetails.QuotaFailure)
+	require.True(t, ok)
+	assert.Equal(t, "ip:127.0.0.1", quota.GetViolations()[0].GetSubject())
+
+	d, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	_, ok = RetryAfter(Error(Info{}))
+	assert.False(t, ok)
+	_, ok = RetryAfter(status.Error(codes.Unavailable, "不可用"))
+	assert.False(t, ok)
+	_, ok = RetryAfter(errors.New("普通错误"))
+	assert.False(t, ok)
+}
+
+func TestRetryAfterHTTP(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "5")
+	d, ok := RetryAfterHTTP(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	resp.Header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	d, ok = RetryAfterHTTP(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+
+	resp.StatusCode = http.StatusOK
+	_, ok = RetryAfterHTTP(resp, now)
+	assert.False(t, ok)
+}
+
+func TestGate_UnaryClientInterceptor(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	gate := NewGateWithClock(10*time.Second, clk)
+	interceptor := gate.UnaryClientInterceptor()
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return Error(Info{RetryAfter: time.Second})
+	}
+	err := interceptor(context.Background(), "/proto.TestService/Test", nil, nil, nil, invoker)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// 一秒之内直接在本地失败
+	clk.Advance(400 * time.Millisecond)
+	err = interceptor(context.Background(), "/proto.TestService/Test", nil, nil, nil, invoker)
+	assert.ErrorIs(t, err, ErrThrottled)
+	d, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 600*time.Millisecond, d)
+	assert.Equal(t, 1, calls)
+	// 其它方法不受影响
+	_ = interceptor(context.Background(), "/proto.TestService/Other", nil, nil, nil, invoker)
+	assert.Equal(t, 2, calls)
+
+	clk.Advance(600 * time.Millisecond)
+	_ = interceptor(context.Background(), "/proto.TestService/Test", nil, nil, nil, invoker)
+	assert.Equal(t, 3, calls)
+}
+
+func TestGate_RoundTripper(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// 建议一分钟之后再试，但是客户端最多等 2 秒
+		WriteHTTP(w, Info{RetryAfter: time.Minute})
+	}))
+	defer server.Close()
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	client := &http.Client{Transport: NewGateWithClock(2*time.Second, clk).RoundTripper(nil)}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	_, err = client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrThrottled)
+	assert.Equal(t, int32(1), calls.Load())
+
+	clk.Advance(2 * time.Second)
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(2), calls.Load())
+}